@@ -0,0 +1,230 @@
+package contract
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+var (
+	// ENS names are resolved on chain through ENS_RPC_URL, a mainnet RPC,
+	// or else by an HTTP API at ENS_RESOLVER_URL
+	ENS_RPC_URL      = os.Getenv("ENS_RPC_URL")
+	ENS_REGISTRY     = os.Getenv("ENS_REGISTRY")
+	ENS_RESOLVER_URL = os.Getenv("ENS_RESOLVER_URL")
+)
+
+// DefaultENSRegistry is the ENS registry on mainnet.
+var DefaultENSRegistry = common.HexToAddress("0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e")
+
+var (
+	// ErrNoAddress is returned when minting for a user with no verified
+	// address to mint to.
+	ErrNoAddress      = errors.New("user has no verified address")
+	ErrInvalidAddress = errors.New("not an address or ENS name")
+	ErrBadChecksum    = errors.New("address checksum doesn't match")
+	ErrUnresolved     = errors.New("ENS name has no address")
+	ErrNoResolver     = errors.New("ENS names are not supported")
+)
+
+var (
+	_ Resolver = (*ENSResolver)(nil)
+	_ Resolver = (*HTTPResolver)(nil)
+)
+
+// EVMAddresses returns the EVM addresses of verifications, in order and
+// without duplicates. Verifications of other chains are skipped.
+func EVMAddresses(verifications []string) []common.Address {
+	seen := make(map[common.Address]bool)
+	addrs := []common.Address{}
+	for _, v := range verifications {
+		if !common.IsHexAddress(v) {
+			continue
+		}
+		addr := common.HexToAddress(v)
+		if seen[addr] || addr == (common.Address{}) {
+			continue
+		}
+		seen[addr] = true
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// ShortAddress abbreviates addr for button labels.
+func ShortAddress(addr common.Address) string {
+	hex := addr.Hex()
+	return hex[:6] + "…" + hex[len(hex)-4:]
+}
+
+// Resolver resolves ENS names to addresses.
+type Resolver interface {
+	Resolve(ctx context.Context, name string) (common.Address, error)
+}
+
+// NewResolver returns the resolver configured by ENS_RPC_URL or
+// ENS_RESOLVER_URL, or nil if neither is set.
+func NewResolver() (Resolver, error) {
+	if ENS_RPC_URL != "" {
+		client, err := ethclient.Dial(ENS_RPC_URL)
+		if err != nil {
+			return nil, err
+		}
+		registry := DefaultENSRegistry
+		if ENS_REGISTRY != "" {
+			registry = common.HexToAddress(ENS_REGISTRY)
+		}
+		return NewENSResolver(client, registry), nil
+	}
+	if ENS_RESOLVER_URL != "" {
+		return &HTTPResolver{URL: ENS_RESOLVER_URL}, nil
+	}
+	return nil, nil
+}
+
+// ParseRecipient parses typed text as an address or, with a resolver, an
+// ENS name.
+func ParseRecipient(ctx context.Context, r Resolver, text string) (common.Address, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		if !common.IsHexAddress(text) {
+			return common.Address{}, ErrInvalidAddress
+		}
+		addr := common.HexToAddress(text)
+		if addr == (common.Address{}) {
+			return common.Address{}, ErrInvalidAddress
+		}
+		// mixed case is an EIP-55 checksum, which catches typos
+		digits := text[2:]
+		if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && digits != addr.Hex()[2:] {
+			return common.Address{}, ErrBadChecksum
+		}
+		return addr, nil
+	}
+	if !strings.Contains(text, ".") || strings.ContainsAny(text, " /") {
+		return common.Address{}, ErrInvalidAddress
+	}
+	if r == nil {
+		return common.Address{}, ErrNoResolver
+	}
+	return r.Resolve(ctx, strings.ToLower(text))
+}
+
+// NameHash is the ENS namehash of name, which must already be normalized.
+func NameHash(name string) common.Hash {
+	var node common.Hash
+	if name == "" {
+		return node
+	}
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		label := crypto.Keccak256([]byte(labels[i]))
+		node = crypto.Keccak256Hash(node[:], label)
+	}
+	return node
+}
+
+// ENSResolver resolves names with the ENS registry and resolver
+// contracts.
+type ENSResolver struct {
+	caller   bind.ContractCaller
+	registry common.Address
+}
+
+func NewENSResolver(caller bind.ContractCaller, registry common.Address) *ENSResolver {
+	return &ENSResolver{caller: caller, registry: registry}
+}
+
+var (
+	// resolver(bytes32) on the registry and addr(bytes32) on resolvers
+	resolverSelector = crypto.Keccak256([]byte("resolver(bytes32)"))[:4]
+	addrSelector     = crypto.Keccak256([]byte("addr(bytes32)"))[:4]
+)
+
+func (r *ENSResolver) Resolve(ctx context.Context, name string) (common.Address, error) {
+	node := NameHash(name)
+	resolver, err := r.callAddress(ctx, r.registry, resolverSelector, node)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if resolver == (common.Address{}) {
+		return common.Address{}, fmt.Errorf("%s: %w", name, ErrUnresolved)
+	}
+	addr, err := r.callAddress(ctx, resolver, addrSelector, node)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if addr == (common.Address{}) {
+		return common.Address{}, fmt.Errorf("%s: %w", name, ErrUnresolved)
+	}
+	return addr, nil
+}
+
+// callAddress calls a view function taking a node and returning an
+// address.
+func (r *ENSResolver) callAddress(ctx context.Context, to common.Address, selector []byte, node common.Hash) (common.Address, error) {
+	data := append(append([]byte{}, selector...), node[:]...)
+	out, err := r.caller.CallContract(ctx, ethereum.CallMsg{To: &to, Data: data}, nil)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if len(out) < 32 {
+		return common.Address{}, nil
+	}
+	return common.BytesToAddress(out[12:32]), nil
+}
+
+// HTTPResolver resolves names with a JSON API, such as
+// https://api.ensideas.com/ens/resolve/, that responds to a GET of URL
+// followed by the name with {"address": "0x..."}.
+type HTTPResolver struct {
+	URL    string
+	Client *http.Client
+}
+
+var defaultResolverClient = &http.Client{Timeout: 10 * time.Second}
+
+func (r *HTTPResolver) Resolve(ctx context.Context, name string) (common.Address, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL+url.PathEscape(name), nil)
+	if err != nil {
+		return common.Address{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	client := r.Client
+	if client == nil {
+		client = defaultResolverClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return common.Address{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return common.Address{}, fmt.Errorf("%s: %w", name, ErrUnresolved)
+	}
+	if res.StatusCode != http.StatusOK {
+		return common.Address{}, fmt.Errorf("resolve %s: %s", name, res.Status)
+	}
+	var body struct {
+		Address string `json:"address"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return common.Address{}, err
+	}
+	if !common.IsHexAddress(body.Address) || common.HexToAddress(body.Address) == (common.Address{}) {
+		return common.Address{}, fmt.Errorf("%s: %w", name, ErrUnresolved)
+	}
+	return common.HexToAddress(body.Address), nil
+}
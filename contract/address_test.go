@@ -0,0 +1,188 @@
+package contract
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestNameHash(t *testing.T) {
+	// from EIP-137
+	for name, want := range map[string]string{
+		"":        "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"eth":     "0x93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae",
+		"foo.eth": "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f",
+	} {
+		if got := NameHash(name).Hex(); got != want {
+			t.Errorf("NameHash(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+// stubResolver resolves the names in addrs.
+type stubResolver map[string]common.Address
+
+func (r stubResolver) Resolve(ctx context.Context, name string) (common.Address, error) {
+	addr, ok := r[name]
+	if !ok {
+		return common.Address{}, ErrUnresolved
+	}
+	return addr, nil
+}
+
+func TestParseRecipient(t *testing.T) {
+	// an EIP-55 test vector
+	const checksummed = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	want := common.HexToAddress(checksummed)
+	resolver := stubResolver{"vitalik.eth": want}
+
+	for _, tc := range []struct {
+		text     string
+		resolver Resolver
+		err      error
+	}{
+		{text: checksummed},
+		{text: "  " + checksummed + "\n"},
+		{text: strings.ToLower(checksummed)},
+		{text: "0x" + strings.ToUpper(checksummed[2:])},
+		{text: "0X" + checksummed[2:]},
+		{text: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", err: ErrBadChecksum},
+		{text: checksummed[:41], err: ErrInvalidAddress},
+		{text: checksummed + "00", err: ErrInvalidAddress},
+		{text: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAez", err: ErrInvalidAddress},
+		{text: "0x0000000000000000000000000000000000000000", err: ErrInvalidAddress},
+		{text: "", err: ErrInvalidAddress},
+		{text: "vitalik", resolver: resolver, err: ErrInvalidAddress},
+		{text: "vitalik eth.", resolver: resolver, err: ErrInvalidAddress},
+		{text: "vitalik.eth/x", resolver: resolver, err: ErrInvalidAddress},
+		{text: "vitalik.eth", err: ErrNoResolver},
+		{text: "vitalik.eth", resolver: resolver},
+		{text: " Vitalik.ETH ", resolver: resolver},
+		{text: "nobody.eth", resolver: resolver, err: ErrUnresolved},
+	} {
+		got, err := ParseRecipient(context.Background(), tc.resolver, tc.text)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("ParseRecipient(%q) error = %v, want %v", tc.text, err, tc.err)
+			continue
+		}
+		if err == nil && got != want {
+			t.Errorf("ParseRecipient(%q) = %s, want %s", tc.text, got, want)
+		}
+	}
+}
+
+func TestEVMAddresses(t *testing.T) {
+	a := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	b := "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"
+	got := EVMAddresses([]string{
+		b,
+		"9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM",
+		strings.ToLower(b),
+		"0x0000000000000000000000000000000000000000",
+		a,
+	})
+	want := []common.Address{common.HexToAddress(b), common.HexToAddress(a)}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("EVMAddresses = %v, want %v", got, want)
+	}
+}
+
+// stubCaller answers calls to the ENS registry and resolver contracts
+// from returns, keyed by contract address and call data.
+type stubCaller struct {
+	returns map[common.Address]map[string][]byte
+	err     error
+}
+
+func (c *stubCaller) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return []byte{1}, nil
+}
+
+func (c *stubCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.returns[*call.To][string(call.Data)], nil
+}
+
+func (c *stubCaller) set(to common.Address, selector []byte, name string, addr common.Address) {
+	if c.returns[to] == nil {
+		c.returns[to] = map[string][]byte{}
+	}
+	node := NameHash(name)
+	data := append(append([]byte{}, selector...), node[:]...)
+	c.returns[to][string(data)] = common.LeftPadBytes(addr[:], 32)
+}
+
+func TestENSResolver(t *testing.T) {
+	registry := common.HexToAddress("0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e")
+	resolver := common.HexToAddress("0x4976fb03C32e5B8cfe2b6cCB31c09Ba78EBaBa41")
+	want := common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+
+	caller := &stubCaller{returns: map[common.Address]map[string][]byte{}}
+	caller.set(registry, resolverSelector, "foo.eth", resolver)
+	caller.set(resolver, addrSelector, "foo.eth", want)
+	// bar.eth has a resolver but no address
+	caller.set(registry, resolverSelector, "bar.eth", resolver)
+	r := NewENSResolver(caller, registry)
+	ctx := context.Background()
+
+	got, err := r.Resolve(ctx, "foo.eth")
+	if err != nil || got != want {
+		t.Errorf("Resolve(foo.eth) = %s, %v, want %s", got, err, want)
+	}
+	for _, name := range []string{"bar.eth", "baz.eth"} {
+		if _, err := r.Resolve(ctx, name); !errors.Is(err, ErrUnresolved) {
+			t.Errorf("Resolve(%s) = %v, want ErrUnresolved", name, err)
+		}
+	}
+
+	caller.err = errors.New("rpc down")
+	if _, err := r.Resolve(ctx, "foo.eth"); err != caller.err {
+		t.Errorf("Resolve with a failing rpc = %v, want its error", err)
+	}
+}
+
+func TestHTTPResolver(t *testing.T) {
+	want := common.HexToAddress("0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ens/resolve/foo.eth":
+			w.Write([]byte(`{"address": "` + want.Hex() + `"}`))
+		case "/ens/resolve/empty.eth":
+			w.Write([]byte(`{"address": null}`))
+		case "/ens/resolve/broken.eth":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/ens/resolve/garbled.eth":
+			w.Write(bytes.Repeat([]byte("{"), 3))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	r := &HTTPResolver{URL: srv.URL + "/ens/resolve/", Client: srv.Client()}
+	ctx := context.Background()
+
+	got, err := r.Resolve(ctx, "foo.eth")
+	if err != nil || got != want {
+		t.Errorf("Resolve(foo.eth) = %s, %v, want %s", got, err, want)
+	}
+	for _, name := range []string{"empty.eth", "missing.eth"} {
+		if _, err := r.Resolve(ctx, name); !errors.Is(err, ErrUnresolved) {
+			t.Errorf("Resolve(%s) = %v, want ErrUnresolved", name, err)
+		}
+	}
+	for _, name := range []string{"broken.eth", "garbled.eth"} {
+		if _, err := r.Resolve(ctx, name); err == nil || errors.Is(err, ErrUnresolved) {
+			t.Errorf("Resolve(%s) = %v, want a lookup failure", name, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package contract
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// CHAINS_CONFIG is a JSON file listing the chains to mint on. Without it
+// a single chain is configured from RPC_URL and CONTRACT_ADDRESS, or none
+// if neither is set.
+var CHAINS_CONFIG = os.Getenv("CHAINS_CONFIG")
+
+// SimulatedRPC as a chain's RPC mints on an in-memory devnet.
+const SimulatedRPC = "simulated"
+
+// Token standards, minted through thirdweb's TokenERC1155, or DropERC1155
+// in claim mode, and TokenERC721.
+const (
+	StandardERC1155 = "erc1155"
+	StandardERC721  = "erc721"
+)
+
+// Mint modes, selected per chain.
+const (
+	// ModeOneOfOne mints a single token of each image.
+	ModeOneOfOne = "one-of-one"
+	// ModeOpenEdition mints each image as an ERC-1155 token that others
+	// can mint copies of through a share link.
+	ModeOpenEdition = "open-edition"
+	// ModeClaim lazy mints each image on an ERC-1155 drop with a free
+	// claim condition, claiming it and any copies under it.
+	ModeClaim = "claim"
+)
+
+// Chain is a chain and the contract minted to on it.
+type Chain struct {
+	// Name identifies the chain in URLs and jobs, e.g. "base".
+	Name string `json:"name"`
+	// Label is shown on the chain's button.
+	Label string `json:"label"`
+	// ChainID, if set, is checked against the RPC.
+	ChainID int64 `json:"chain_id,omitempty"`
+	// RPC may reference environment variables, e.g. for API keys, as
+	// ${NAME}.
+	RPC string `json:"rpc"`
+	// Explorer is a transaction URL with {tx} in place of the hash.
+	Explorer string `json:"explorer,omitempty"`
+	Contract string `json:"contract"`
+	Standard string `json:"standard,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+}
+
+// Editions reports whether tokens minted on the chain can be copied.
+func (c *Chain) Editions() bool {
+	return c.Mode == ModeOpenEdition || c.Mode == ModeClaim
+}
+
+// TxURL links to transaction hash in the chain's explorer, or is empty if
+// it has none.
+func (c *Chain) TxURL(hash string) string {
+	if c.Explorer == "" {
+		return ""
+	}
+	return strings.ReplaceAll(c.Explorer, "{tx}", hash)
+}
+
+func (c *Chain) validate() error {
+	if c.Name == "" {
+		return errors.New("chain has no name")
+	}
+	if c.RPC == "" {
+		return fmt.Errorf("chain %s has no rpc", c.Name)
+	}
+	if c.RPC != SimulatedRPC && !common.IsHexAddress(c.Contract) {
+		return fmt.Errorf("chain %s: invalid contract %q", c.Name, c.Contract)
+	}
+	if c.Standard != StandardERC1155 && c.Standard != StandardERC721 {
+		return fmt.Errorf("chain %s: unsupported token standard %q", c.Name, c.Standard)
+	}
+	switch c.Mode {
+	case ModeOneOfOne:
+	case ModeOpenEdition, ModeClaim:
+		if c.Standard != StandardERC1155 {
+			return fmt.Errorf("chain %s: %s mode needs an %s contract", c.Name, c.Mode, StandardERC1155)
+		}
+	default:
+		return fmt.Errorf("chain %s: unknown mint mode %q", c.Name, c.Mode)
+	}
+	return nil
+}
+
+// Registry is the chains minting is offered on, in the order offered.
+type Registry struct {
+	chains []Chain
+}
+
+// NewRegistry checks chains, defaulting their labels, standards and modes.
+// Without any chains, minting isn't offered.
+func NewRegistry(chains ...Chain) (*Registry, error) {
+	seen := make(map[string]bool)
+	for i := range chains {
+		c := &chains[i]
+		if c.Label == "" {
+			c.Label = c.Name
+		}
+		if c.Standard == "" {
+			c.Standard = StandardERC1155
+		}
+		c.Standard = strings.ToLower(c.Standard)
+		if c.Mode == "" {
+			c.Mode = ModeOneOfOne
+		}
+		c.RPC = os.ExpandEnv(c.RPC)
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("chain %s is configured twice", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	return &Registry{chains: chains}, nil
+}
+
+// LoadRegistry reads the chains in CHAINS_CONFIG, or configures the one
+// chain of the older environment variables.
+func LoadRegistry() (*Registry, error) {
+	if CHAINS_CONFIG == "" {
+		switch MINTER {
+		case "", "chain":
+			if RPC_ENDPOINT == "" && CONTRACT_ADDRESS == "" {
+				return NewRegistry()
+			}
+			return NewRegistry(envChain())
+		case "simulated":
+			return NewRegistry(envChain())
+		default:
+			return nil, fmt.Errorf("unknown minter %q", MINTER)
+		}
+	}
+	data, err := os.ReadFile(CHAINS_CONFIG)
+	if err != nil {
+		return nil, err
+	}
+	var chains []Chain
+	if err := json.Unmarshal(data, &chains); err != nil {
+		return nil, fmt.Errorf("%s: %w", CHAINS_CONFIG, err)
+	}
+	return NewRegistry(chains...)
+}
+
+// envChain is CONTRACT_ADDRESS through RPC_URL, linked to the Zora
+// Sepolia explorer, or a devnet when MINTER is simulated.
+func envChain() Chain {
+	if MINTER == "simulated" {
+		return Chain{
+			Name:     "devnet",
+			Label:    "Devnet",
+			ChainID:  simulatedChainID.Int64(),
+			RPC:      SimulatedRPC,
+			Standard: TOKEN_STANDARD,
+			Mode:     MINT_MODE,
+		}
+	}
+	return Chain{
+		Name:     "zora-sepolia",
+		Label:    "Zora Sepolia",
+		RPC:      RPC_ENDPOINT,
+		Explorer: "https://sepolia.explorer.zora.energy/tx/{tx}",
+		Contract: CONTRACT_ADDRESS,
+		Standard: TOKEN_STANDARD,
+		Mode:     MINT_MODE,
+	}
+}
+
+// Chains returns the configured chains.
+func (r *Registry) Chains() []Chain {
+	return append([]Chain{}, r.chains...)
+}
+
+// Empty reports whether no chains are configured.
+func (r *Registry) Empty() bool {
+	return len(r.chains) == 0
+}
+
+// Get returns chain name. The empty name is the first chain.
+func (r *Registry) Get(name string) (Chain, bool) {
+	if name == "" {
+		if r.Empty() {
+			return Chain{}, false
+		}
+		return r.chains[0], true
+	}
+	for _, c := range r.chains {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Chain{}, false
+}
@@ -0,0 +1,35 @@
+package contract
+
+import "testing"
+
+func TestLoadRegistryWithoutChains(t *testing.T) {
+	defer func(rpc, addr, minter, config string) {
+		RPC_ENDPOINT, CONTRACT_ADDRESS, MINTER, CHAINS_CONFIG = rpc, addr, minter, config
+	}(RPC_ENDPOINT, CONTRACT_ADDRESS, MINTER, CHAINS_CONFIG)
+	RPC_ENDPOINT, CONTRACT_ADDRESS, MINTER, CHAINS_CONFIG = "", "", "", ""
+
+	chains, err := LoadRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chains.Empty() {
+		t.Errorf("chains = %+v, want none", chains.Chains())
+	}
+	if c, ok := chains.Get(""); ok {
+		t.Errorf("Get(\"\") = %+v on an empty registry", c)
+	}
+
+	// half a chain is a mistake rather than minting being off
+	RPC_ENDPOINT = "https://rpc.example.com"
+	if _, err := LoadRegistry(); err == nil {
+		t.Error("loaded a chain without a contract")
+	}
+	CONTRACT_ADDRESS = "0x00000000000000000000000000000000000000aa"
+	chains, err = LoadRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := chains.Get(""); !ok || c.Contract != CONTRACT_ADDRESS {
+		t.Errorf("Get(\"\") = %+v, %v", c, ok)
+	}
+}
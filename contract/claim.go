@@ -0,0 +1,181 @@
+package contract
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	gethabi "github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/thirdweb-dev/go-sdk/v2/abi"
+	"github.com/treethought/impression-frame/pin"
+)
+
+// nativeToken is how thirdweb contracts denote the chain's own currency.
+var nativeToken = common.HexToAddress("0xEeeeeEeeeEeEeeEeEeEeeEEEeeeeEeeeeeeeEEeE")
+
+// ClaimMinter mints through a thirdweb DropERC1155 contract. A new token
+// is lazy minted, given a free claim condition and claimed in a single
+// multicall; copies are claimed under the same condition. Its key needs
+// the minter and admin roles.
+//
+// The drop's URI of a token is the lazy mint's base URI followed by the
+// token id, so each new token's metadata is pinned again as a file named
+// by its id in a directory, whose URI is the base.
+//
+// The transaction names the token id the lazy mint will assign, so the
+// contract must only be lazy minted through one ClaimMinter.
+type ClaimMinter struct {
+	// Tx sends the mint transactions.
+	Tx *TxManager
+	// Pinner pins the metadata directory of each new token.
+	Pinner  pin.Pinner
+	address common.Address
+	drop    *abi.DropERC1155
+	abi     *gethabi.ABI
+	chainID *big.Int
+
+	mu sync.Mutex
+	// next is the token id the next lazy mint assigns, or nil to ask the
+	// contract.
+	next *big.Int
+}
+
+func NewClaimMinter(backend Backend, address common.Address, key *ecdsa.PrivateKey, chainID *big.Int) (*ClaimMinter, error) {
+	drop, err := abi.NewDropERC1155(address, backend)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := abi.DropERC1155MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	return &ClaimMinter{Tx: NewTxManager(backend, key, chainID), address: address, drop: drop, abi: parsed, chainID: chainID}, nil
+}
+
+func (m *ClaimMinter) Submit(ctx context.Context, req MintRequest) (*PendingTx, error) {
+	if req.TokenID != nil {
+		return m.Tx.Send(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+			return m.drop.Claim(opts, req.To, req.TokenID, big.NewInt(1), nativeToken, new(big.Int), freeClaim(), nil)
+		})
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.next
+	if id == nil {
+		var err error
+		id, err = m.drop.NextTokenIdToMint(&bind.CallOpts{Context: ctx, Pending: true})
+		if err != nil {
+			return nil, err
+		}
+	}
+	baseURI, err := m.pinMetadata(ctx, id, req)
+	if err != nil {
+		return nil, err
+	}
+	calls, err := m.lazyClaim(id, baseURI, req)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := m.Tx.Send(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return m.drop.Multicall(opts, calls)
+	})
+	if err != nil {
+		m.next = nil
+		return nil, err
+	}
+	m.next = new(big.Int).Add(id, common.Big1)
+	return tx, nil
+}
+
+// pinMetadata pins the metadata of req as token id, returning the base
+// URI the drop appends the id to.
+func (m *ClaimMinter) pinMetadata(ctx context.Context, id *big.Int, req MintRequest) (string, error) {
+	if m.Pinner == nil || req.Metadata == nil {
+		return "", fmt.Errorf("token %v of %s has no metadata to pin", id, req.URI)
+	}
+	pinned, err := m.Pinner.PinDir(ctx, "metadata", []pin.File{{Name: id.String(), Data: req.Metadata}})
+	if err != nil {
+		return "", err
+	}
+	return pinned.URI() + "/", nil
+}
+
+// lazyClaim encodes the calls minting req as token id, lazy minted under
+// baseURI.
+func (m *ClaimMinter) lazyClaim(id *big.Int, baseURI string, req MintRequest) ([][]byte, error) {
+	lazyMint, err := m.abi.Pack("lazyMint", big.NewInt(1), baseURI, []byte{})
+	if err != nil {
+		return nil, err
+	}
+	conditions := []abi.IClaimConditionClaimCondition{{
+		StartTimestamp:         new(big.Int),
+		MaxClaimableSupply:     maxUint256,
+		SupplyClaimed:          new(big.Int),
+		QuantityLimitPerWallet: maxUint256,
+		PricePerToken:          new(big.Int),
+		Currency:               nativeToken,
+	}}
+	setConditions, err := m.abi.Pack("setClaimConditions", id, conditions, false)
+	if err != nil {
+		return nil, err
+	}
+	claim, err := m.abi.Pack("claim", req.To, id, big.NewInt(1), nativeToken, new(big.Int), freeClaim(), []byte{})
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{lazyMint, setConditions, claim}, nil
+}
+
+// freeClaim is the allowlist proof of a claim condition without one.
+func freeClaim() abi.IDrop1155AllowlistProof {
+	return abi.IDrop1155AllowlistProof{
+		Proof:                  [][32]byte{},
+		QuantityLimitPerWallet: new(big.Int),
+		PricePerToken:          new(big.Int),
+	}
+}
+
+func (m *ClaimMinter) Confirm(ctx context.Context, tx *PendingTx) (*MintResult, error) {
+	receipt, err := m.Tx.Check(ctx, tx)
+	if errors.Is(err, ErrReverted) {
+		// the token id may have been wrong
+		m.mu.Lock()
+		m.next = nil
+		m.mu.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &MintResult{
+		TxHash:   receipt.TxHash,
+		ChainID:  m.chainID.Int64(),
+		Contract: m.address.Hex(),
+	}
+	for _, l := range receipt.Logs {
+		if ev, err := m.drop.ParseTransferSingle(*l); err == nil {
+			result.TokenID = ev.Id
+			break
+		}
+	}
+	if result.TokenID != nil {
+		if result.URI, err = m.URI(ctx, result.TokenID); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (m *ClaimMinter) BalanceOf(ctx context.Context, account common.Address, id *big.Int) (*big.Int, error) {
+	return m.drop.BalanceOf(&bind.CallOpts{Context: ctx}, account, id)
+}
+
+func (m *ClaimMinter) URI(ctx context.Context, id *big.Int) (string, error) {
+	return m.drop.Uri(&bind.CallOpts{Context: ctx}, id)
+}
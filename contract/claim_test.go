@@ -0,0 +1,90 @@
+package contract
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/treethought/impression-frame/pin"
+)
+
+// mint submits req and waits for it to be confirmed.
+func mint(t *testing.T, m *SimulatedMinter, req MintRequest) *MintResult {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := m.Submit(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		result, err := m.Confirm(ctx, tx)
+		if err == nil {
+			return result
+		}
+		if err != ErrPending || time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClaimMinterPinsMetadataByTokenID(t *testing.T) {
+	pinner := pin.NewFakePinner("")
+	chain := Chain{Name: "sim", RPC: SimulatedRPC, Standard: StandardERC1155, Mode: ModeClaim}
+	m, err := NewSimulatedMinter(chain, pinner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	ctx := context.Background()
+	alice := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	bob := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+
+	var ids []*big.Int
+	for i, metadata := range []string{`{"name":"first"}`, `{"name":"second"}`} {
+		minted := mint(t, m, MintRequest{To: alice, URI: "ipfs://metadata", Metadata: []byte(metadata)})
+		if minted.TokenID == nil || minted.TokenID.Int64() != int64(i) {
+			t.Fatalf("minted token %v, want %d", minted.TokenID, i)
+		}
+		if !strings.HasPrefix(minted.URI, "ipfs://") || !strings.HasSuffix(minted.URI, "/"+minted.TokenID.String()) {
+			t.Errorf("token URI is %s, want a file named by its id", minted.URI)
+		}
+		if data, ok := pinner.Get(strings.TrimPrefix(minted.URI, "ipfs://")); !ok || string(data) != metadata {
+			t.Errorf("token URI %s serves %q, %v, want %s", minted.URI, data, ok, metadata)
+		}
+		ids = append(ids, minted.TokenID)
+	}
+
+	copied := mint(t, m, MintRequest{To: bob, TokenID: ids[0]})
+	mint(t, m, MintRequest{To: alice, TokenID: ids[1]})
+	if copied.TokenID == nil || copied.TokenID.Cmp(ids[0]) != 0 {
+		t.Errorf("copy minted token %v, want %v", copied.TokenID, ids[0])
+	}
+	for _, tc := range []struct {
+		account common.Address
+		id      *big.Int
+		want    int64
+	}{
+		{alice, ids[0], 1},
+		{alice, ids[1], 2},
+		{bob, ids[0], 1},
+		{bob, ids[1], 0},
+	} {
+		balance, err := m.BalanceOf(ctx, tc.account, tc.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance.Int64() != tc.want {
+			t.Errorf("balance of %s in token %v is %v, want %d", ShortAddress(tc.account), tc.id, balance, tc.want)
+		}
+	}
+
+	// tokens must be lazy minted before they can be claimed
+	if _, err := m.Submit(ctx, MintRequest{To: bob, TokenID: big.NewInt(5)}); err == nil {
+		t.Error("claimed a token that wasn't lazy minted")
+	}
+}
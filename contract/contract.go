@@ -3,6 +3,7 @@ package contract
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"image"
 	_ "image/gif"
@@ -10,13 +11,15 @@ import (
 	"image/png"
 	_ "image/png"
 	"log"
+	"math/big"
 	"os"
+	"time"
 
-	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/thirdweb-dev/go-sdk/v2/thirdweb"
 	fc "github.com/treethought/impression-frame/farcaster"
-
-	"github.com/wabarc/ipfs-pinner/pkg/pinata"
+	"github.com/treethought/impression-frame/pin"
+	"github.com/treethought/impression-frame/util"
 )
 
 var (
@@ -26,20 +29,81 @@ var (
 	CLIENT_ID        = os.Getenv("CLIENT_ID")
 	CONTRACT_ADDRESS = os.Getenv("CONTRACT_ADDRESS")
 
+	// PINNER is one of pinata (default), nftstorage, kubo or fake
+	PINNER            = os.Getenv("PINNER")
+	PIN_GATEWAY       = os.Getenv("PIN_GATEWAY")
 	PINATA_API_KEY    = os.Getenv("PINATA_API_KEY")
 	PINATA_SECRET_KEY = os.Getenv("PINATA_SECRET_KEY")
+	PINATA_JWT        = os.Getenv("PINATA_JWT")
+	NFT_STORAGE_URL   = os.Getenv("NFT_STORAGE_URL")
+	NFT_STORAGE_TOKEN = os.Getenv("NFT_STORAGE_TOKEN")
+	KUBO_API_URL      = os.Getenv("KUBO_API_URL")
 	TW_GATEWAY        = os.Getenv("TW_GATEWAY")
 
+	// MINTER is chain (default), minting through CONTRACT_ADDRESS on
+	// RPC_URL, or simulated, minting to a test token on an in-memory chain.
+	// Both are ignored when CHAINS_CONFIG is set.
+	MINTER = os.Getenv("MINTER")
+	// erc1155 (default) or erc721, and one-of-one (default), open-edition
+	// or claim, for the chain configured by the variables above
+	TOKEN_STANDARD = os.Getenv("TOKEN_STANDARD")
+	MINT_MODE      = os.Getenv("MINT_MODE")
+	// durations such as 2m, and a cap in wei for replacement gas prices
+	TX_STUCK_AFTER   = os.Getenv("TX_STUCK_AFTER")
+	TX_TIMEOUT       = os.Getenv("TX_TIMEOUT")
+	TX_MAX_GAS_PRICE = os.Getenv("TX_MAX_GAS_PRICE")
+
 	description = "PFP chopped & screwed"
 )
 
 type Contract struct {
-	sdk      *thirdweb.ThirdwebSDK
-	contract *thirdweb.SmartContract
+	Chain  Chain
+	Minter Minter
+	Pinner pin.Pinner
+}
+
+// MintResult is a token minted by a confirmed transaction.
+type MintResult struct {
+	TxHash   common.Hash
+	ChainID  int64
+	Contract string
+	TokenID  *big.Int
+	// URI, if set, is the token's metadata URI as the contract reports it,
+	// when that differs from the URI minted.
+	URI string
+}
+
+// Pinned is a token's metadata pinned by Contract.Pin, ready to mint.
+type Pinned struct {
+	MetadataURI string
+	Metadata    *Metadata
+	// Data is the metadata JSON pinned.
+	Data []byte
+}
+
+// NewPinner returns the pinner selected by PINNER.
+func NewPinner() (pin.Pinner, error) {
+	switch PINNER {
+	case "", "pinata":
+		return pin.NewPinata(PINATA_API_KEY, PINATA_SECRET_KEY, PINATA_JWT, PIN_GATEWAY), nil
+	case "nftstorage":
+		return pin.NewNFTStorage(NFT_STORAGE_URL, NFT_STORAGE_TOKEN, PIN_GATEWAY), nil
+	case "kubo":
+		return pin.NewKubo(KUBO_API_URL, PIN_GATEWAY), nil
+	case "fake":
+		return pin.NewFakePinner(PIN_GATEWAY), nil
+	default:
+		return nil, fmt.Errorf("unknown pinner %q", PINNER)
+	}
 }
 
-func NewContract() (*Contract, error) {
-	sdk, err := thirdweb.NewThirdwebSDK(RPC_ENDPOINT, &thirdweb.SDKOptions{
+// NewMinter returns a minter for the contract of chain, by its standard
+// and mode. pinner pins the metadata of tokens claimed in claim mode.
+func NewMinter(ctx context.Context, chain Chain, pinner pin.Pinner) (Minter, error) {
+	if chain.RPC == SimulatedRPC {
+		return NewSimulatedMinter(chain, pinner)
+	}
+	sdk, err := thirdweb.NewThirdwebSDK(chain.RPC, &thirdweb.SDKOptions{
 		SecretKey:  SECRET_KEY,
 		PrivateKey: PRIVATE_KEY,
 		GatewayUrl: TW_GATEWAY,
@@ -47,48 +111,128 @@ func NewContract() (*Contract, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	contract, err := sdk.GetContract(context.Background(), CONTRACT_ADDRESS)
+	chainID, err := sdk.GetChainID(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &Contract{sdk, contract}, nil
+	if chain.ChainID != 0 && chainID.Int64() != chain.ChainID {
+		return nil, fmt.Errorf("chain %s: rpc is chain %v, not %d", chain.Name, chainID, chain.ChainID)
+	}
+	backend, address, key := sdk.GetProvider(), common.HexToAddress(chain.Contract), sdk.GetPrivateKey()
+
+	var m Minter
+	var tx *TxManager
+	switch {
+	case chain.Standard == StandardERC721:
+		nft, err := NewNFTMinter(backend, address, key, chainID)
+		if err != nil {
+			return nil, err
+		}
+		m, tx = nft, nft.Tx
+	case chain.Mode == ModeClaim:
+		drop, err := NewClaimMinter(backend, address, key, chainID)
+		if err != nil {
+			return nil, err
+		}
+		drop.Pinner = pinner
+		m, tx = drop, drop.Tx
+	default:
+		token, err := NewTokenMinter(backend, address, key, chainID)
+		if err != nil {
+			return nil, err
+		}
+		token.Editions = chain.Mode == ModeOpenEdition
+		m, tx = token, token.Tx
+	}
+	if err := configureTx(tx); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
-func (c *Contract) Mint(ctx context.Context, img image.Image, user *fc.User) (*types.Transaction, error) {
-	if len(user.Verfications) == 0 {
-		return nil, fmt.Errorf("user has no verifications")
+func configureTx(tx *TxManager) error {
+	var err error
+	if TX_STUCK_AFTER != "" {
+		if tx.StuckAfter, err = time.ParseDuration(TX_STUCK_AFTER); err != nil {
+			return fmt.Errorf("invalid TX_STUCK_AFTER: %w", err)
+		}
+	}
+	if TX_TIMEOUT != "" {
+		if tx.Timeout, err = time.ParseDuration(TX_TIMEOUT); err != nil {
+			return fmt.Errorf("invalid TX_TIMEOUT: %w", err)
+		}
+	}
+	if TX_MAX_GAS_PRICE != "" {
+		max, ok := new(big.Int).SetString(TX_MAX_GAS_PRICE, 10)
+		if !ok {
+			return fmt.Errorf("invalid TX_MAX_GAS_PRICE %q", TX_MAX_GAS_PRICE)
+		}
+		tx.MaxGasPrice = max
 	}
-	to := user.Verfications[0]
-	fmt.Println("MintTo: ", to)
+	return nil
+}
 
-	ctx = context.Background()
-	// Encode the image to JPEG format
-	var buf bytes.Buffer
-	err := png.Encode(&buf, img) // Change "jpeg" to "png" or "gif" if needed
+// NewContract returns a contract minting on chain.
+func NewContract(ctx context.Context, chain Chain) (*Contract, error) {
+	pinner, err := NewPinner()
+	if err != nil {
+		return nil, err
+	}
+	minter, err := NewMinter(ctx, chain, pinner)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	return &Contract{Chain: chain, Minter: minter, Pinner: pinner}, nil
+}
+
+// Pin pins img and its metadata, built from prov, for minting as a token
+// of user. Animated images are pinned as the animation_url, with their
+// first frame as the image.
+func (c *Contract) Pin(ctx context.Context, img image.Image, user *fc.User, prov Provenance) (*Pinned, error) {
+	_, animated := img.(*util.Animated)
+	md := &Metadata{
+		Name:        user.Username,
+		Description: description,
+		Attributes:  prov.attributes(animated),
+		Properties:  prov,
 	}
 
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
 	log.Println("Pinning image to IPFS")
-	pnt := pinata.Pinata{Apikey: PINATA_API_KEY, Secret: PINATA_SECRET_KEY}
-	cid, err := pnt.PinWithBytes(buf.Bytes())
+	pinned, err := c.Pinner.Pin(ctx, fmt.Sprintf("%s.png", user.Username), buf.Bytes())
 	if err != nil {
 		log.Println("Error pinning image to IPFS: ", err)
 		return nil, err
 	}
-	log.Println("CID: ", cid)
+	log.Println("CID: ", pinned.CID, pinned.URL)
+	md.Image = pinned.URI()
 
-	imgUrl := fmt.Sprintf("ipfs://%s", cid)
+	if animated {
+		buf.Reset()
+		if err := util.EncodeImage(&buf, img, "gif"); err != nil {
+			return nil, err
+		}
+		pinned, err := c.Pinner.Pin(ctx, fmt.Sprintf("%s.gif", user.Username), buf.Bytes())
+		if err != nil {
+			log.Println("Error pinning animation to IPFS: ", err)
+			return nil, err
+		}
+		md.AnimationURL = pinned.URI()
+	}
 
-	md := &thirdweb.EditionMetadataInput{
-		Supply: 1,
-		Metadata: &thirdweb.NFTMetadataInput{
-			Name:        user.Username,
-			Description: description,
-			Image:       imgUrl,
-		},
+	data, err := json.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	pinned, err = c.Pinner.Pin(ctx, "metadata.json", data)
+	if err != nil {
+		log.Println("Error pinning metadata to IPFS: ", err)
+		return nil, err
 	}
+	log.Println("Metadata: ", pinned.URI(), pinned.URL)
 
-	return c.contract.ERC1155.MintTo(context.Background(), to, md)
+	return &Pinned{MetadataURI: pinned.URI(), Metadata: md, Data: data}, nil
 }
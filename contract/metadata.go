@@ -0,0 +1,73 @@
+package contract
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metadata is the ERC-1155 metadata JSON of a minted token, following the
+// OpenSea conventions for attributes and animation_url.
+type Metadata struct {
+	Name         string      `json:"name"`
+	Description  string      `json:"description"`
+	Image        string      `json:"image"`
+	AnimationURL string      `json:"animation_url,omitempty"`
+	ExternalURL  string      `json:"external_url,omitempty"`
+	Attributes   []Attribute `json:"attributes"`
+	Properties   Provenance  `json:"properties"`
+}
+
+type Attribute struct {
+	TraitType   string      `json:"trait_type"`
+	Value       interface{} `json:"value"`
+	DisplayType string      `json:"display_type,omitempty"`
+}
+
+// Provenance records how a minted image was made, so it can be reproduced
+// from its source PFP.
+type Provenance struct {
+	SourceFID uint64 `json:"source_fid"`
+	SourcePFP string `json:"source_pfp"`
+	// SourceHash is the SHA-256 of the source PFP's pixels.
+	SourceHash string `json:"source_pfp_hash,omitempty"`
+	Transforms []Step `json:"transforms"`
+	// Truncated is set when the earliest transforms were evicted from the
+	// session history, so Transforms doesn't start from the source PFP.
+	Truncated   bool      `json:"truncated,omitempty"`
+	ParentToken *TokenRef `json:"parent_token,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Session     string    `json:"session,omitempty"`
+}
+
+// Step is one transform of the chain that produced an image, in order.
+type Step struct {
+	Transform string            `json:"transform"`
+	Seed      int64             `json:"seed,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+}
+
+// TokenRef identifies a previously minted token that was remixed.
+type TokenRef struct {
+	ChainID     int64  `json:"chain_id,omitempty"`
+	Contract    string `json:"contract,omitempty"`
+	TokenID     string `json:"token_id,omitempty"`
+	TxHash      string `json:"tx_hash,omitempty"`
+	MetadataURI string `json:"metadata_uri,omitempty"`
+}
+
+// attributes flattens the provenance into marketplace traits.
+func (p *Provenance) attributes(animated bool) []Attribute {
+	attrs := []Attribute{
+		{TraitType: "Source FID", Value: p.SourceFID, DisplayType: "number"},
+		{TraitType: "Transforms", Value: len(p.Transforms), DisplayType: "number"},
+		{TraitType: "Generated", Value: p.GeneratedAt.Unix(), DisplayType: "date"},
+		{TraitType: "Animated", Value: fmt.Sprint(animated)},
+	}
+	for i, step := range p.Transforms {
+		attrs = append(attrs, Attribute{TraitType: fmt.Sprintf("Step %d", i+1), Value: step.Transform})
+	}
+	if p.ParentToken != nil {
+		attrs = append(attrs, Attribute{TraitType: "Remix", Value: "true"})
+	}
+	return attrs
+}
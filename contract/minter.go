@@ -0,0 +1,220 @@
+package contract
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	gethabi "github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/thirdweb-dev/go-sdk/v2/abi"
+	"github.com/treethought/impression-frame/pin"
+)
+
+var (
+	_ Minter = (*TokenMinter)(nil)
+	_ Minter = (*NFTMinter)(nil)
+	_ Minter = (*ClaimMinter)(nil)
+	_ Minter = (*SimulatedMinter)(nil)
+)
+
+var (
+	// ErrPending is returned while a transaction isn't mined.
+	ErrPending = errors.New("transaction pending")
+	// ErrReverted is returned for a failed transaction.
+	ErrReverted = errors.New("transaction reverted")
+	// ErrNoCopies is returned for copies of tokens minted one of one.
+	ErrNoCopies = errors.New("tokens can't be copied")
+)
+
+// MintRequest is a token to mint to To.
+type MintRequest struct {
+	To common.Address
+	// URI is the metadata of a new token.
+	URI string
+	// Metadata is the JSON pinned at URI, pinned again by minters whose
+	// token URIs end in the token id.
+	Metadata []byte
+	// TokenID, if set, mints a copy of an existing token instead.
+	TokenID *big.Int
+}
+
+// Minter mints tokens by metadata URI. Minting is split in two so the
+// transaction can be recorded before waiting for it.
+type Minter interface {
+	// Submit sends a transaction minting a single token, new or a copy, as
+	// requested.
+	Submit(ctx context.Context, req MintRequest) (*PendingTx, error)
+	// Confirm returns the token minted by whichever version of tx was
+	// mined. It returns ErrPending until then, replacing tx if it is
+	// stuck, ErrReverted if it failed and ErrTimeout if it took too long
+	// and was cancelled.
+	Confirm(ctx context.Context, tx *PendingTx) (*MintResult, error)
+	// BalanceOf returns how many of token id account holds.
+	BalanceOf(ctx context.Context, account common.Address, id *big.Int) (*big.Int, error)
+	// URI returns the metadata URI of token id.
+	URI(ctx context.Context, id *big.Int) (string, error)
+}
+
+// Backend is a chain a TokenMinter sends transactions to.
+type Backend interface {
+	bind.ContractBackend
+	bind.DeployBackend
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+}
+
+// TokenMinter mints through a thirdweb TokenERC1155 contract.
+type TokenMinter struct {
+	// Tx sends the mint transactions.
+	Tx *TxManager
+	// Editions allows minting copies of tokens.
+	Editions bool
+	address  common.Address
+	token    *abi.TokenERC1155
+	chainID  *big.Int
+}
+
+func NewTokenMinter(backend Backend, address common.Address, key *ecdsa.PrivateKey, chainID *big.Int) (*TokenMinter, error) {
+	token, err := abi.NewTokenERC1155(address, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenMinter{Tx: NewTxManager(backend, key, chainID), address: address, token: token, chainID: chainID}, nil
+}
+
+// maxUint256 is type(uint256).max.
+var maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(common.Big1, 256), common.Big1)
+
+func (m *TokenMinter) Submit(ctx context.Context, req MintRequest) (*PendingTx, error) {
+	// the max token id asks the contract for the next unused id
+	id := maxUint256
+	if req.TokenID != nil {
+		if !m.Editions {
+			return nil, ErrNoCopies
+		}
+		id = req.TokenID
+	}
+	return m.Tx.Send(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return m.token.MintTo(opts, req.To, id, req.URI, big.NewInt(1))
+	})
+}
+
+func (m *TokenMinter) Confirm(ctx context.Context, tx *PendingTx) (*MintResult, error) {
+	receipt, err := m.Tx.Check(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	result := &MintResult{
+		TxHash:   receipt.TxHash,
+		ChainID:  m.chainID.Int64(),
+		Contract: m.address.Hex(),
+	}
+	for _, l := range receipt.Logs {
+		if ev, err := m.token.ParseTransferSingle(*l); err == nil {
+			result.TokenID = ev.Id
+			break
+		}
+	}
+	return result, nil
+}
+
+func (m *TokenMinter) BalanceOf(ctx context.Context, account common.Address, id *big.Int) (*big.Int, error) {
+	return m.token.BalanceOf(&bind.CallOpts{Context: ctx}, account, id)
+}
+
+func (m *TokenMinter) URI(ctx context.Context, id *big.Int) (string, error) {
+	return m.token.Uri(&bind.CallOpts{Context: ctx}, id)
+}
+
+//go:generate go run testtoken_gen.go
+
+// simulatedChainID is the chain id of backends.SimulatedBackend.
+var simulatedChainID = big.NewInt(1337)
+
+// testTokenABI deploys testTokenBin, testNFTBin and testDropBin; they are
+// called through the TokenERC1155, TokenERC721 and DropERC1155 bindings,
+// whose selectors they share.
+const testTokenABI = `[{"inputs":[],"stateMutability":"nonpayable","type":"constructor"}]`
+
+// SimulatedMinter mints to a test token deployed on an in-memory chain, so
+// minting can run end to end without an RPC endpoint or funds.
+type SimulatedMinter struct {
+	Minter
+	// Tx sends the mint transactions.
+	Tx      *TxManager
+	Backend *backends.SimulatedBackend
+	// Owner deployed the token and signs mints.
+	Owner common.Address
+}
+
+// NewSimulatedMinter starts a simulated chain with a funded key and
+// deploys a test token of chain's standard to it, minting in its mode.
+// pinner pins the metadata of tokens claimed in claim mode.
+func NewSimulatedMinter(chain Chain, pinner pin.Pinner) (*SimulatedMinter, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	owner := crypto.PubkeyToAddress(key.PublicKey)
+	balance := new(big.Int).Mul(big.NewInt(1000), big.NewInt(1e18))
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{owner: {Balance: balance}}, 30_000_000)
+
+	parsed, err := gethabi.JSON(strings.NewReader(testTokenABI))
+	if err != nil {
+		return nil, err
+	}
+	opts, err := bind.NewKeyedTransactorWithChainID(key, simulatedChainID)
+	if err != nil {
+		return nil, err
+	}
+	bin := testTokenBin
+	switch {
+	case chain.Standard == StandardERC721:
+		bin = testNFTBin
+	case chain.Mode == ModeClaim:
+		bin = testDropBin
+	}
+	address, _, _, err := bind.DeployContract(opts, parsed, common.FromHex(bin), sim)
+	if err != nil {
+		return nil, fmt.Errorf("deploy test token: %w", err)
+	}
+	sim.Commit()
+
+	m := &SimulatedMinter{Backend: sim, Owner: owner}
+	switch {
+	case chain.Standard == StandardERC721:
+		nft, err := NewNFTMinter(sim, address, key, simulatedChainID)
+		if err != nil {
+			return nil, err
+		}
+		m.Minter, m.Tx = nft, nft.Tx
+	case chain.Mode == ModeClaim:
+		drop, err := NewClaimMinter(sim, address, key, simulatedChainID)
+		if err != nil {
+			return nil, err
+		}
+		drop.Pinner = pinner
+		m.Minter, m.Tx = drop, drop.Tx
+	default:
+		token, err := NewTokenMinter(sim, address, key, simulatedChainID)
+		if err != nil {
+			return nil, err
+		}
+		token.Editions = chain.Mode == ModeOpenEdition
+		m.Minter, m.Tx = token, token.Tx
+	}
+	m.Tx.commit = sim.Commit
+	return m, nil
+}
+
+func (m *SimulatedMinter) Close() error {
+	return m.Backend.Close()
+}
@@ -0,0 +1,74 @@
+package contract
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/thirdweb-dev/go-sdk/v2/abi"
+)
+
+// NFTMinter mints one of one tokens through a thirdweb TokenERC721
+// contract.
+type NFTMinter struct {
+	// Tx sends the mint transactions.
+	Tx      *TxManager
+	address common.Address
+	token   *abi.TokenERC721
+	chainID *big.Int
+}
+
+func NewNFTMinter(backend Backend, address common.Address, key *ecdsa.PrivateKey, chainID *big.Int) (*NFTMinter, error) {
+	token, err := abi.NewTokenERC721(address, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &NFTMinter{Tx: NewTxManager(backend, key, chainID), address: address, token: token, chainID: chainID}, nil
+}
+
+func (m *NFTMinter) Submit(ctx context.Context, req MintRequest) (*PendingTx, error) {
+	if req.TokenID != nil {
+		return nil, ErrNoCopies
+	}
+	return m.Tx.Send(ctx, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return m.token.MintTo(opts, req.To, req.URI)
+	})
+}
+
+func (m *NFTMinter) Confirm(ctx context.Context, tx *PendingTx) (*MintResult, error) {
+	receipt, err := m.Tx.Check(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	result := &MintResult{
+		TxHash:   receipt.TxHash,
+		ChainID:  m.chainID.Int64(),
+		Contract: m.address.Hex(),
+	}
+	for _, l := range receipt.Logs {
+		if ev, err := m.token.ParseTransfer(*l); err == nil {
+			result.TokenID = ev.TokenId
+			break
+		}
+	}
+	return result, nil
+}
+
+// BalanceOf returns 1 if account owns token id, else 0.
+func (m *NFTMinter) BalanceOf(ctx context.Context, account common.Address, id *big.Int) (*big.Int, error) {
+	owner, err := m.token.OwnerOf(&bind.CallOpts{Context: ctx}, id)
+	if err != nil {
+		return nil, err
+	}
+	if owner == account {
+		return big.NewInt(1), nil
+	}
+	return new(big.Int), nil
+}
+
+func (m *NFTMinter) URI(ctx context.Context, id *big.Int) (string, error) {
+	return m.token.TokenURI(&bind.CallOpts{Context: ctx}, id)
+}
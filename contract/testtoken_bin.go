@@ -0,0 +1,9 @@
+// Code generated by testtoken_gen.go; DO NOT EDIT.
+
+package contract
+
+const testTokenBin = "0x336000556101af806100116000396000f360003560e01c8063b03f4528146100d45780630e89341c14610079578062fdd58e146100595780633b1475a71461004d5780638da5cb5b14610041575b600080fd5b60005460005260206000f35b60015460005260206000f35b600435600052602435602052600260405260606000205460005260206000f35b6004358060005260036020526040600020805460206000528060205280601f0160051c60005b818110156100be57808401600101548160051b6040015260010161009f565b505090509050601f0160051c60051b6040016000f35b33600054141561003c57602435807fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff14156101155750600154806001016001555b6004356000528060205260026040526060600020805460643501905580600052600360205260406000206044356004018035808355601f0160051c60005b81811015610174578060051b83016020013581850160010155600101610153565b505050506000526064356020526004356000337fc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f6260406000a400"
+
+const testNFTBin = "0x336000556101ab806100116000396000f360003560e01c806275a317146100f3578063c87b56dd146100985780636352211e1461006457806370a082311461007e5780633b1475a7146100585780638da5cb5b1461004c575b600080fd5b60005460005260206000f35b60015460005260206000f35b600435600052600260205260406000205460005260206000f35b600435600052600460205260406000205460005260206000f35b6004358060005260036020526040600020805460206000528060205280601f0160051c60005b818110156100dd57808401600101548160051b604001526001016100be565b505090509050601f0160051c60051b6040016000f35b3360005414156100475760015480600101600155600435816000526002602052604060002055600435600052600460205260406000208054600101905580600052600360205260406000206024356004018035808355601f0160051c60005b81811015610173578060051b83016020013581850160010155600101610152565b505050508060043560007fddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef600080a460005260206000f3"
+
+const testDropBin = "0x33600055610241806100116000396000f360003560e01c8063ac9650d814610132578063d37c353b14610177578063183718d1146101d557806357bc3d78146101e15780630e89341c1461009a578062fdd58e1461007a5780633b1475a71461006e5780638da5cb5b14610062575b600080fd5b60005460005260206000f35b60015460005260206000f35b600435600052602435602052600260405260606000205460005260206000f35b6004358060005260036020526040600020805460206000528060205280601f0160051c60005b818110156100df57808401600101548160051b604001526001016100c0565b505090508160005b60010190600a900490816100e75790508181018060205280603f01845b600a81066030018253600a90049060019003908061010457505092505050601f0160051c60051b6040016000f35b600435600401803560005b81811015610175578060051b83016020013583016020018035809160200160003760006000916000305af41561005d5760010161013d565b005b33600054141561005d57600154806004350160015580600052600360205260406000206024356004018035808355601f0160051c60005b818110156101cf578060051b830160200135818501600101556001016101ae565b50505050005b33600054141561005d57005b60243560015481101561005d57600435600052806020526002604052606060002080546044350190556000526044356020526004356000337fc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f6260406000a400"
@@ -0,0 +1,644 @@
+//go:build ignore
+
+// This program assembles testtoken_bin.go, the EVM bytecode of the minimal
+// tokens deployed by the simulated minter, when go generate is run in this
+// package. The ERC-1155 implements the
+// subset of thirdweb's TokenERC1155 used for minting:
+//
+//	mintTo(address to, uint256 tokenId, string uri, uint256 amount)
+//	uri(uint256 id) returns (string)
+//	balanceOf(address account, uint256 id) returns (uint256)
+//	nextTokenIdToMint() returns (uint256)
+//	owner() returns (address)
+//
+// and the ERC-721 that of TokenERC721:
+//
+//	mintTo(address to, string uri) returns (uint256)
+//	tokenURI(uint256 id) returns (string)
+//	ownerOf(uint256 id) returns (address)
+//	balanceOf(address owner) returns (uint256)
+//	nextTokenIdToMint() returns (uint256)
+//	owner() returns (address)
+//
+// and the ERC-1155 drop that of DropERC1155:
+//
+//	multicall(bytes[] data)
+//	lazyMint(uint256 amount, string baseURI, bytes data)
+//	setClaimConditions(uint256 tokenId, ClaimCondition[] conditions, bool reset)
+//	claim(address receiver, uint256 tokenId, uint256 quantity, ...)
+//	uri(uint256 id) returns (string)
+//	balanceOf(address account, uint256 id) returns (uint256)
+//	nextTokenIdToMint() returns (uint256)
+//	owner() returns (address)
+//
+// Only the deployer may mint, or lazy mint and set claim conditions on the
+// drop. Anyone may claim a lazy minted drop token, as under the free claim
+// condition the claim minter sets, which is otherwise ignored. The drop's
+// uri is the base URI of the token's batch followed by its id; it is
+// stored under the first id of the batch, as the minter lazy mints one
+// token at a time. A tokenId of type(uint256).max mints the
+// next unused ERC-1155 id, as TokenERC1155 does, and other ids mint more
+// of an existing token. Mints emit TransferSingle or Transfer. Storage is
+// laid out as:
+//
+//	slot 0                      owner
+//	slot 1                      next token id
+//	keccak(account, id, 2)      ERC-1155 balance
+//	keccak(id, 2)               ERC-721 owner
+//	keccak(owner, 4)            ERC-721 balance
+//	keccak(id, 3)               uri length, followed by its 32 byte words
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	STOP         = 0x00
+	ADD          = 0x01
+	SUB          = 0x03
+	DIV          = 0x04
+	MOD          = 0x06
+	LT           = 0x10
+	EQ           = 0x14
+	ISZERO       = 0x15
+	SHL          = 0x1b
+	SHR          = 0x1c
+	SHA3         = 0x20
+	ADDRESS      = 0x30
+	CALLER       = 0x33
+	CALLDATALOAD = 0x35
+	CALLDATACOPY = 0x37
+	CODECOPY     = 0x39
+	POP          = 0x50
+	MSTORE       = 0x52
+	MSTORE8      = 0x53
+	SLOAD        = 0x54
+	SSTORE       = 0x55
+	JUMP         = 0x56
+	JUMPI        = 0x57
+	GAS          = 0x5a
+	JUMPDEST     = 0x5b
+	PUSH1        = 0x60
+	DUP1         = 0x80
+	SWAP1        = 0x90
+	LOG4         = 0xa4
+	RETURN       = 0xf3
+	DELEGATECALL = 0xf4
+	REVERT       = 0xfd
+)
+
+func DUP(n int) byte  { return byte(DUP1 + n - 1) }
+func SWAP(n int) byte { return byte(SWAP1 + n - 1) }
+
+// asm is a two pass assembler; label references are always PUSH2.
+type asm struct {
+	code   []byte
+	labels map[string]int
+	refs   map[int]string
+}
+
+func newAsm() *asm {
+	return &asm{labels: map[string]int{}, refs: map[int]string{}}
+}
+
+func (a *asm) op(ops ...byte) {
+	a.code = append(a.code, ops...)
+}
+
+// push emits the smallest PUSH for v.
+func (a *asm) push(v []byte) {
+	v = bytes.TrimLeft(v, "\x00")
+	if len(v) == 0 {
+		v = []byte{0}
+	}
+	a.op(byte(PUSH1 + len(v) - 1))
+	a.op(v...)
+}
+
+func (a *asm) pushInt(v int64) {
+	a.push(big.NewInt(v).Bytes())
+}
+
+func (a *asm) label(name string) {
+	a.labels[name] = len(a.code)
+	a.op(JUMPDEST)
+}
+
+func (a *asm) pushLabel(name string) {
+	a.op(PUSH1 + 1)
+	a.refs[len(a.code)] = name
+	a.op(0, 0)
+}
+
+func (a *asm) jump(name string)  { a.pushLabel(name); a.op(JUMP) }
+func (a *asm) jumpi(name string) { a.pushLabel(name); a.op(JUMPI) }
+
+func (a *asm) bytes() []byte {
+	for pos, name := range a.refs {
+		dest, ok := a.labels[name]
+		if !ok {
+			log.Fatalf("undefined label %s", name)
+		}
+		a.code[pos] = byte(dest >> 8)
+		a.code[pos+1] = byte(dest)
+	}
+	return a.code
+}
+
+func selector(sig string) []byte {
+	return crypto.Keccak256([]byte(sig))[:4]
+}
+
+// returnWord returns the word on top of the stack.
+func returnWord(a *asm) {
+	a.pushInt(0)
+	a.op(MSTORE)
+	a.pushInt(0x20)
+	a.pushInt(0)
+	a.op(RETURN)
+}
+
+type fn struct{ sig, label string }
+
+// dispatch jumps to the label of the function selected by the calldata,
+// or reverts, and defines the owner and next functions.
+func dispatch(a *asm, fns ...fn) {
+	a.pushInt(0)
+	a.op(CALLDATALOAD)
+	a.pushInt(0xe0)
+	a.op(SHR)
+	for _, fn := range append(fns, fn{"nextTokenIdToMint()", "next"}, fn{"owner()", "owner"}) {
+		a.op(DUP1)
+		a.push(selector(fn.sig))
+		a.op(EQ)
+		a.jumpi(fn.label)
+	}
+	a.label("revert")
+	a.pushInt(0)
+	a.op(DUP1, REVERT)
+
+	a.label("owner")
+	a.pushInt(0)
+	a.op(SLOAD)
+	returnWord(a)
+
+	a.label("next")
+	a.pushInt(1)
+	a.op(SLOAD)
+	returnWord(a)
+}
+
+// mapSlot replaces the word on top of the stack with the storage slot
+// keccak(word, slot) of a mapping.
+func mapSlot(a *asm, slot int64) {
+	a.pushInt(0)
+	a.op(MSTORE)
+	a.pushInt(slot)
+	a.pushInt(0x20)
+	a.op(MSTORE)
+	a.pushInt(0x40)
+	a.pushInt(0)
+	a.op(SHA3)
+}
+
+// onlyOwner reverts unless the caller deployed the token.
+func onlyOwner(a *asm) {
+	a.op(CALLER)
+	a.pushInt(0)
+	a.op(SLOAD, EQ, ISZERO)
+	a.jumpi("revert")
+}
+
+// uriFn defines the function at label returning the string stored for the
+// token id argument, ABI encoded.
+func uriFn(a *asm, label string) {
+	a.label(label)
+	a.pushInt(4)
+	a.op(CALLDATALOAD)
+	loadURI(a) // [id len]
+	a.op(SWAP1, POP)
+	returnString(a)
+}
+
+// loadURI copies the uri stored for the token id on top of the stack to
+// memory, ABI encoded, pushing its length.
+func loadURI(a *asm) {
+	a.op(DUP1)
+	mapSlot(a, 3)     // [id base]
+	a.op(DUP1, SLOAD) // [id base len]
+	a.pushInt(0x20)
+	a.pushInt(0)
+	a.op(MSTORE) // mem[0] = 0x20
+	a.op(DUP1)
+	a.pushInt(0x20)
+	a.op(MSTORE) // mem[0x20] = len
+	a.op(DUP1)
+	a.pushInt(0x1f)
+	a.op(ADD)
+	a.pushInt(5)
+	a.op(SHR) // [id base len words]
+	a.pushInt(0)
+	a.label("uriLoop") // [id base len words i]
+	a.op(DUP(2), DUP(2), LT, ISZERO)
+	a.jumpi("uriDone")
+	a.op(DUP1, DUP(5), ADD)
+	a.pushInt(1)
+	a.op(ADD, SLOAD) // [id base len words i word]
+	a.op(DUP(2))
+	a.pushInt(5)
+	a.op(SHL)
+	a.pushInt(0x40)
+	a.op(ADD, MSTORE) // mem[0x40+i*32] = word
+	a.pushInt(1)
+	a.op(ADD)
+	a.jump("uriLoop")
+	a.label("uriDone")
+	a.op(POP, POP, SWAP1, POP) // [id len]
+}
+
+// appendID appends the decimal token id to the uri loaded in memory,
+// replacing the id and uri length on top of the stack with the new
+// length.
+func appendID(a *asm) {
+	// count the digits
+	a.op(DUP(2))
+	a.pushInt(0)
+	a.label("countDigits") // [id len t n]
+	a.pushInt(1)
+	a.op(ADD, SWAP1)
+	a.pushInt(10)
+	a.op(SWAP1, DIV, SWAP1) // [id len t/10 n+1]
+	a.op(DUP(2))
+	a.jumpi("countDigits")
+	a.op(SWAP1, POP)          // [id len n]
+	a.op(DUP(2), DUP(2), ADD) // [id len n total]
+	a.op(DUP1)
+	a.pushInt(0x20)
+	a.op(MSTORE) // mem[0x20] = total
+
+	// write them from the last
+	a.op(DUP1)
+	a.pushInt(0x3f)
+	a.op(ADD, DUP(5))     // [id len n total pos t]
+	a.label("writeDigit") // [... pos t]
+	a.pushInt(10)
+	a.op(DUP(2), MOD)
+	a.pushInt('0')
+	a.op(ADD, DUP(3), MSTORE8) // mem[pos] = '0' + t%10
+	a.pushInt(10)
+	a.op(SWAP1, DIV, SWAP1)
+	a.pushInt(1)
+	a.op(SWAP1, SUB, SWAP1) // [... pos-1 t/10]
+	a.op(DUP1)
+	a.jumpi("writeDigit")
+	a.op(POP, POP, SWAP(3), POP, POP, POP) // [total]
+}
+
+// returnString returns the string loaded in memory, whose length is on
+// top of the stack.
+func returnString(a *asm) {
+	a.pushInt(0x1f)
+	a.op(ADD)
+	a.pushInt(5)
+	a.op(SHR)
+	a.pushInt(5)
+	a.op(SHL)
+	a.pushInt(0x40)
+	a.op(ADD)
+	a.pushInt(0)
+	a.op(RETURN)
+}
+
+// storeURI copies the string argument whose offset is at calldata arg
+// into storage as the uri of the token id on top of the stack.
+func storeURI(a *asm, arg int64) {
+	a.op(DUP1)
+	mapSlot(a, 3) // [id base]
+	a.pushInt(arg)
+	a.op(CALLDATALOAD)
+	a.pushInt(4)
+	a.op(ADD)                  // [id base pos]
+	a.op(DUP1, CALLDATALOAD)   // [id base pos len]
+	a.op(DUP1, DUP(4), SSTORE) // store the length at base
+	a.pushInt(0x1f)
+	a.op(ADD)
+	a.pushInt(5)
+	a.op(SHR) // [id base pos words]
+	a.pushInt(0)
+	a.label("mintLoop") // [id base pos words i]
+	a.op(DUP(2), DUP(2), LT, ISZERO)
+	a.jumpi("mintDone")
+	a.op(DUP1)
+	a.pushInt(5)
+	a.op(SHL, DUP(4), ADD)
+	a.pushInt(0x20)
+	a.op(ADD, CALLDATALOAD) // [id base pos words i word]
+	a.op(DUP(2), DUP(6), ADD)
+	a.pushInt(1)
+	a.op(ADD, SSTORE) // storage[base+1+i] = word
+	a.pushInt(1)
+	a.op(ADD)
+	a.jump("mintLoop")
+	a.label("mintDone")
+	a.op(POP, POP, POP, POP) // [id]
+}
+
+// nextID replaces the token id on top of the stack with the next unused
+// id, advancing it, if it is type(uint256).max.
+func nextID(a *asm) {
+	maxUint := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	a.op(DUP1)
+	a.push(maxUint.Bytes())
+	a.op(EQ, ISZERO)
+	a.jumpi("haveID")
+	a.op(POP)
+	newID(a)
+	a.label("haveID") // [id]
+}
+
+// newID pushes the next unused token id and advances it.
+func newID(a *asm) {
+	a.pushInt(1)
+	a.op(SLOAD, DUP1) // [id id]
+	a.pushInt(1)
+	a.op(ADD)
+	a.pushInt(1)
+	a.op(SSTORE) // next = id + 1
+}
+
+// balanceFn defines balanceOf(address,uint256) of the ERC-1155s.
+func balanceFn(a *asm) {
+	a.label("balanceOf")
+	a.pushInt(4)
+	a.op(CALLDATALOAD)
+	a.pushInt(0)
+	a.op(MSTORE)
+	a.pushInt(0x24)
+	a.op(CALLDATALOAD)
+	a.pushInt(0x20)
+	a.op(MSTORE)
+	a.pushInt(2)
+	a.pushInt(0x40)
+	a.op(MSTORE)
+	a.pushInt(0x60)
+	a.pushInt(0)
+	a.op(SHA3, SLOAD)
+	returnWord(a)
+}
+
+// credit adds the amount at calldata amount to the ERC-1155 balance of
+// the account at calldata to in the token id on top of the stack.
+func credit(a *asm, to, amount int64) {
+	a.pushInt(to)
+	a.op(CALLDATALOAD)
+	a.pushInt(0)
+	a.op(MSTORE)
+	a.op(DUP1)
+	a.pushInt(0x20)
+	a.op(MSTORE)
+	a.pushInt(2)
+	a.pushInt(0x40)
+	a.op(MSTORE)
+	a.pushInt(0x60)
+	a.pushInt(0)
+	a.op(SHA3)        // [id slot]
+	a.op(DUP1, SLOAD) // [id slot balance]
+	a.pushInt(amount)
+	a.op(CALLDATALOAD, ADD, SWAP1, SSTORE) // [id]
+}
+
+// transferSingle emits TransferSingle(caller, 0, to, id, amount) for the
+// token id on top of the stack, taking to and amount from calldata.
+func transferSingle(a *asm, to, amount int64) {
+	a.pushInt(0)
+	a.op(MSTORE)
+	a.pushInt(amount)
+	a.op(CALLDATALOAD)
+	a.pushInt(0x20)
+	a.op(MSTORE)
+	a.pushInt(to)
+	a.op(CALLDATALOAD)
+	a.pushInt(0)
+	a.op(CALLER)
+	a.push(crypto.Keccak256([]byte("TransferSingle(address,address,address,uint256,uint256)")))
+	a.pushInt(0x40)
+	a.pushInt(0)
+	a.op(LOG4)
+}
+
+// erc1155 is the runtime code of the test ERC-1155.
+func erc1155() []byte {
+	a := newAsm()
+	dispatch(a,
+		fn{"mintTo(address,uint256,string,uint256)", "mintTo"},
+		fn{"uri(uint256)", "uri"},
+		fn{"balanceOf(address,uint256)", "balanceOf"},
+	)
+
+	balanceFn(a)
+	uriFn(a, "uri")
+
+	a.label("mintTo")
+	onlyOwner(a)
+	a.pushInt(0x24)
+	a.op(CALLDATALOAD) // [id]
+	nextID(a)
+	credit(a, 4, 0x64)
+	storeURI(a, 0x44)
+	transferSingle(a, 4, 0x64)
+	a.op(STOP)
+
+	return a.bytes()
+}
+
+// drop is the runtime code of the test ERC-1155 drop.
+func drop() []byte {
+	a := newAsm()
+	dispatch(a,
+		fn{"multicall(bytes[])", "multicall"},
+		fn{"lazyMint(uint256,string,bytes)", "lazyMint"},
+		fn{"setClaimConditions(uint256,(uint256,uint256,uint256,uint256,bytes32,uint256,address,string)[],bool)", "setClaimConditions"},
+		fn{"claim(address,uint256,uint256,address,uint256,(bytes32[],uint256,uint256,address),bytes)", "claim"},
+		fn{"uri(uint256)", "uri"},
+		fn{"balanceOf(address,uint256)", "balanceOf"},
+	)
+
+	balanceFn(a)
+
+	a.label("uri")
+	a.pushInt(4)
+	a.op(CALLDATALOAD)
+	loadURI(a)
+	appendID(a)
+	returnString(a)
+
+	// each call is delegated to the drop itself, reverting all of them if
+	// one fails
+	a.label("multicall")
+	a.pushInt(4)
+	a.op(CALLDATALOAD)
+	a.pushInt(4)
+	a.op(ADD)                // [arr]
+	a.op(DUP1, CALLDATALOAD) // [arr n]
+	a.pushInt(0)
+	a.label("callLoop") // [arr n i]
+	a.op(DUP(2), DUP(2), LT, ISZERO)
+	a.jumpi("callDone")
+	a.op(DUP1)
+	a.pushInt(5)
+	a.op(SHL, DUP(4), ADD)
+	a.pushInt(0x20)
+	a.op(ADD, CALLDATALOAD, DUP(4), ADD)
+	a.pushInt(0x20)
+	a.op(ADD)                // [arr n i pos]
+	a.op(DUP1, CALLDATALOAD) // [arr n i pos len]
+	a.op(DUP1, SWAP(2))
+	a.pushInt(0x20)
+	a.op(ADD)
+	a.pushInt(0)
+	a.op(CALLDATACOPY) // mem[0:len] = call i; [arr n i len]
+	a.pushInt(0)
+	a.pushInt(0)
+	a.op(SWAP(2))
+	a.pushInt(0)
+	a.op(ADDRESS, GAS, DELEGATECALL, ISZERO)
+	a.jumpi("revert")
+	a.pushInt(1)
+	a.op(ADD)
+	a.jump("callLoop")
+	a.label("callDone")
+	a.op(STOP)
+
+	a.label("lazyMint")
+	onlyOwner(a)
+	a.pushInt(1)
+	a.op(SLOAD) // [id]
+	a.op(DUP1)
+	a.pushInt(4)
+	a.op(CALLDATALOAD, ADD)
+	a.pushInt(1)
+	a.op(SSTORE) // next = id + amount
+	storeURI(a, 0x24)
+	a.op(STOP)
+
+	a.label("setClaimConditions")
+	onlyOwner(a)
+	a.op(STOP)
+
+	a.label("claim")
+	a.pushInt(0x24)
+	a.op(CALLDATALOAD) // [id]
+	// only lazy minted tokens can be claimed
+	a.pushInt(1)
+	a.op(SLOAD, DUP(2), LT, ISZERO)
+	a.jumpi("revert")
+	credit(a, 4, 0x44)
+	transferSingle(a, 4, 0x44)
+	a.op(STOP)
+
+	return a.bytes()
+}
+
+// erc721 is the runtime code of the test ERC-721.
+func erc721() []byte {
+	a := newAsm()
+	dispatch(a,
+		fn{"mintTo(address,string)", "mintTo"},
+		fn{"tokenURI(uint256)", "uri"},
+		fn{"ownerOf(uint256)", "ownerOf"},
+		fn{"balanceOf(address)", "balanceOf"},
+	)
+
+	a.label("ownerOf")
+	a.pushInt(4)
+	a.op(CALLDATALOAD)
+	mapSlot(a, 2)
+	a.op(SLOAD)
+	returnWord(a)
+
+	a.label("balanceOf")
+	a.pushInt(4)
+	a.op(CALLDATALOAD)
+	mapSlot(a, 4)
+	a.op(SLOAD)
+	returnWord(a)
+
+	uriFn(a, "uri")
+
+	a.label("mintTo")
+	onlyOwner(a)
+	newID(a) // [id]
+
+	// owner = to
+	a.pushInt(4)
+	a.op(CALLDATALOAD, DUP(2)) // [id to id]
+	mapSlot(a, 2)
+	a.op(SSTORE) // [id]
+
+	// balance += 1
+	a.pushInt(4)
+	a.op(CALLDATALOAD)
+	mapSlot(a, 4)     // [id slot]
+	a.op(DUP1, SLOAD) // [id slot balance]
+	a.pushInt(1)
+	a.op(ADD, SWAP1, SSTORE) // [id]
+
+	storeURI(a, 0x24)
+
+	// emit Transfer(0, to, id) and return id
+	a.op(DUP1)
+	a.pushInt(4)
+	a.op(CALLDATALOAD)
+	a.pushInt(0)
+	a.push(crypto.Keccak256([]byte("Transfer(address,address,uint256)")))
+	a.pushInt(0)
+	a.op(DUP1, LOG4)
+	returnWord(a)
+
+	return a.bytes()
+}
+
+// constructor stores the deployer as owner and returns the runtime code.
+func constructor(code []byte) []byte {
+	a := newAsm()
+	a.op(CALLER)
+	a.pushInt(0)
+	a.op(SSTORE)
+	a.op(PUSH1+1, byte(len(code)>>8), byte(len(code)))
+	a.op(DUP1)
+	// the constructor is 17 bytes long
+	a.op(PUSH1+1, 0, 17)
+	a.pushInt(0)
+	a.op(CODECOPY)
+	a.pushInt(0)
+	a.op(RETURN)
+	init := a.bytes()
+	if len(init) != 17 {
+		log.Fatalf("constructor is %d bytes", len(init))
+	}
+	return append(init, code...)
+}
+
+func main() {
+	src := fmt.Sprintf(`// Code generated by testtoken_gen.go; DO NOT EDIT.
+
+package contract
+
+const testTokenBin = "0x%s"
+
+const testNFTBin = "0x%s"
+
+const testDropBin = "0x%s"
+`, hex.EncodeToString(constructor(erc1155())), hex.EncodeToString(constructor(erc721())), hex.EncodeToString(constructor(drop())))
+	if err := os.WriteFile("testtoken_bin.go", []byte(src), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,288 @@
+package contract
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ErrTimeout is returned when no version of a transaction was mined in
+// time and its nonce has since been used up by a cancellation, so it
+// never will be.
+var ErrTimeout = errors.New("timed out waiting for receipt")
+
+const (
+	defaultStuckAfter   = 2 * time.Minute
+	defaultTxTimeout    = 15 * time.Minute
+	defaultPollInterval = 2 * time.Second
+	// replacements must raise the gas price by at least 10% to be accepted
+	defaultGasBump = 20
+)
+
+// PendingTx is a sent transaction and the replacements sent for it with
+// more gas, which all share its nonce so at most one is mined.
+type PendingTx struct {
+	// Hashes of every version sent, newest last.
+	Hashes []common.Hash
+	// Cancels are the versions of a transfer to ourselves sent with the
+	// same nonce once the transaction timed out, newest last.
+	Cancels []common.Hash
+	// SentAt is when the first version was sent, BumpedAt the newest.
+	SentAt   time.Time
+	BumpedAt time.Time
+}
+
+// Latest is the hash of the newest version.
+func (p *PendingTx) Latest() common.Hash {
+	return p.Hashes[len(p.Hashes)-1]
+}
+
+// TxManager sends the transactions of one key. It hands out nonces one at
+// a time so concurrent sends don't collide, and replaces transactions that
+// are stuck with ones paying more gas.
+type TxManager struct {
+	backend Backend
+	key     *ecdsa.PrivateKey
+	from    common.Address
+	chainID *big.Int
+
+	// StuckAfter is how long a version waits before it is replaced.
+	StuckAfter time.Duration
+	// Timeout is how long a transaction may take to be mined in total.
+	Timeout time.Duration
+	// PollInterval is how often Wait checks for receipts.
+	PollInterval time.Duration
+	// GasBump is the percentage a replacement raises the gas price by.
+	GasBump int64
+	// MaxGasPrice caps replacements; nil is no cap.
+	MaxGasPrice *big.Int
+
+	// commit mines pending transactions on backends that don't mine by
+	// themselves.
+	commit func()
+
+	mu sync.Mutex
+	// nonce is the next nonce to use, or nil to ask the backend.
+	nonce *uint64
+}
+
+func NewTxManager(backend Backend, key *ecdsa.PrivateKey, chainID *big.Int) *TxManager {
+	return &TxManager{
+		backend:      backend,
+		key:          key,
+		from:         crypto.PubkeyToAddress(key.PublicKey),
+		chainID:      chainID,
+		StuckAfter:   defaultStuckAfter,
+		Timeout:      defaultTxTimeout,
+		PollInterval: defaultPollInterval,
+		GasBump:      defaultGasBump,
+	}
+}
+
+// From is the address transactions are sent from.
+func (m *TxManager) From() common.Address {
+	return m.from
+}
+
+// Send sends the transaction built by send with the next nonce. send is
+// usually a contract binding's method called with opts.
+func (m *TxManager) Send(ctx context.Context, send func(opts *bind.TransactOpts) (*types.Transaction, error)) (*PendingTx, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.nonce == nil {
+		nonce, err := m.backend.PendingNonceAt(ctx, m.from)
+		if err != nil {
+			return nil, err
+		}
+		m.nonce = &nonce
+	}
+	opts, err := bind.NewKeyedTransactorWithChainID(m.key, m.chainID)
+	if err != nil {
+		return nil, err
+	}
+	opts.Context = ctx
+	opts.Nonce = new(big.Int).SetUint64(*m.nonce)
+
+	tx, err := send(opts)
+	if err != nil {
+		// the nonce may or may not have been used, so ask again next time
+		m.nonce = nil
+		return nil, err
+	}
+	*m.nonce++
+	if m.commit != nil {
+		m.commit()
+	}
+	now := time.Now()
+	return &PendingTx{Hashes: []common.Hash{tx.Hash()}, SentAt: now, BumpedAt: now}, nil
+}
+
+// Check returns the receipt of whichever version of p was mined,
+// ErrReverted with it if that failed, or ErrPending if none has been mined
+// yet. A pending p that is stuck is replaced, which appends to its Hashes.
+// Once p has waited longer than Timeout its nonce is cancelled instead,
+// as one of its versions may still be mined; Check returns ErrTimeout
+// only once a cancellation is.
+func (m *TxManager) Check(ctx context.Context, p *PendingTx) (*types.Receipt, error) {
+	receipt, hash, err := m.receipt(ctx, p.Hashes)
+	if err != nil || receipt != nil {
+		if receipt != nil && receipt.Status != types.ReceiptStatusSuccessful {
+			return receipt, fmt.Errorf("%s: %w", hash.Hex(), ErrReverted)
+		}
+		return receipt, err
+	}
+	receipt, hash, err = m.receipt(ctx, p.Cancels)
+	if err != nil {
+		return nil, err
+	}
+	if receipt != nil {
+		return nil, fmt.Errorf("%s cancelled by %s: %w", p.Latest().Hex(), hash.Hex(), ErrTimeout)
+	}
+
+	timedOut := m.Timeout > 0 && time.Since(p.SentAt) > m.Timeout
+	// the first cancellation is sent as soon as p times out
+	cancelDue := timedOut && len(p.Cancels) == 0 && p.BumpedAt.Before(p.SentAt.Add(m.Timeout))
+	stuck := m.StuckAfter > 0 && time.Since(p.BumpedAt) > m.StuckAfter
+	if cancelDue || stuck {
+		m.replace(ctx, p, timedOut)
+	}
+	return nil, ErrPending
+}
+
+// receipt returns the receipt of the first of hashes that was mined, or
+// nil if none was.
+func (m *TxManager) receipt(ctx context.Context, hashes []common.Hash) (*types.Receipt, common.Hash, error) {
+	for _, hash := range hashes {
+		receipt, err := m.backend.TransactionReceipt(ctx, hash)
+		// the simulated backend returns no receipt and no error
+		if errors.Is(err, ethereum.NotFound) || (err == nil && receipt == nil) {
+			continue
+		}
+		if err != nil {
+			return nil, hash, err
+		}
+		return receipt, hash, nil
+	}
+	return nil, common.Hash{}, nil
+}
+
+// replace sends a new version of p, or of its cancellation if cancel is
+// set, paying more gas. Replacements may be refused, e.g. once the price
+// is capped at MaxGasPrice, in which case p is left to be mined as is and
+// tried again once stuck.
+func (m *TxManager) replace(ctx context.Context, p *PendingTx, cancel bool) {
+	latest := p.Latest()
+	if len(p.Cancels) > 0 {
+		latest = p.Cancels[len(p.Cancels)-1]
+	}
+	p.BumpedAt = time.Now()
+	hash, err := m.bump(ctx, latest, cancel)
+	if err != nil {
+		log.Printf("failed to replace %s: %v", latest.Hex(), err)
+		return
+	}
+	if cancel {
+		log.Printf("cancelling timed out transaction %s with %s", latest.Hex(), hash.Hex())
+		p.Cancels = append(p.Cancels, hash)
+		return
+	}
+	log.Printf("replaced stuck transaction %s with %s", latest.Hex(), hash.Hex())
+	p.Hashes = append(p.Hashes, hash)
+}
+
+// Wait checks p until a version of it is mined or it times out.
+func (m *TxManager) Wait(ctx context.Context, p *PendingTx) (*types.Receipt, error) {
+	ticker := time.NewTicker(m.PollInterval)
+	defer ticker.Stop()
+	for {
+		receipt, err := m.Check(ctx, p)
+		if !errors.Is(err, ErrPending) {
+			return receipt, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// bump resends transaction hash with the same nonce and GasBump percent
+// more gas, or the suggested price if that is higher. With cancel set it
+// sends an empty transfer to ourselves instead, which uses up the nonce.
+func (m *TxManager) bump(ctx context.Context, hash common.Hash, cancel bool) (common.Hash, error) {
+	tx, _, err := m.backend.TransactionByHash(ctx, hash)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	suggested, err := m.backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	to, value, input, gas := tx.To(), tx.Value(), tx.Data(), tx.Gas()
+	if cancel {
+		to, value, input, gas = &m.from, new(big.Int), nil, params.TxGas
+	}
+
+	var data types.TxData
+	if tx.Type() == types.DynamicFeeTxType {
+		tip := m.raise(tx.GasTipCap(), nil)
+		data = &types.DynamicFeeTx{
+			ChainID:   m.chainID,
+			Nonce:     tx.Nonce(),
+			GasTipCap: tip,
+			GasFeeCap: m.raise(tx.GasFeeCap(), suggested),
+			Gas:       gas,
+			To:        to,
+			Value:     value,
+			Data:      input,
+		}
+	} else {
+		data = &types.LegacyTx{
+			Nonce:    tx.Nonce(),
+			GasPrice: m.raise(tx.GasPrice(), suggested),
+			Gas:      gas,
+			To:       to,
+			Value:    value,
+			Data:     input,
+		}
+	}
+	signed, err := types.SignNewTx(m.key, types.LatestSignerForChainID(m.chainID), data)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := m.backend.SendTransaction(ctx, signed); err != nil {
+		return common.Hash{}, err
+	}
+	if m.commit != nil {
+		m.commit()
+	}
+	return signed.Hash(), nil
+}
+
+// raise returns price raised by GasBump percent, at least floor and at
+// most MaxGasPrice.
+func (m *TxManager) raise(price, floor *big.Int) *big.Int {
+	raised := new(big.Int).Mul(price, big.NewInt(100+m.GasBump))
+	raised.Div(raised, big.NewInt(100))
+	if floor != nil && raised.Cmp(floor) < 0 {
+		raised.Set(floor)
+	}
+	if m.MaxGasPrice != nil && raised.Cmp(m.MaxGasPrice) > 0 {
+		raised.Set(m.MaxGasPrice)
+	}
+	return raised
+}
@@ -0,0 +1,181 @@
+package contract
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// mempool is a Backend that holds every sent transaction unmined until
+// mine is called, accepting replacements with the same nonce, which the
+// simulated backend can't.
+type mempool struct {
+	*backends.SimulatedBackend
+
+	mu       sync.Mutex
+	txs      map[common.Hash]*types.Transaction
+	receipts map[common.Hash]*types.Receipt
+	// sendErr fails every send when set
+	sendErr error
+}
+
+func newMempool(t *testing.T) *mempool {
+	sim := backends.NewSimulatedBackend(core.GenesisAlloc{}, 30_000_000)
+	t.Cleanup(func() { sim.Close() })
+	return &mempool{
+		SimulatedBackend: sim,
+		txs:              make(map[common.Hash]*types.Transaction),
+		receipts:         make(map[common.Hash]*types.Receipt),
+	}
+}
+
+func (b *mempool) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sendErr != nil {
+		return b.sendErr
+	}
+	b.txs[tx.Hash()] = tx
+	return nil
+}
+
+func (b *mempool) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tx, ok := b.txs[hash]
+	if !ok {
+		return nil, false, ethereum.NotFound
+	}
+	return tx, true, nil
+}
+
+func (b *mempool) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	receipt, ok := b.receipts[hash]
+	if !ok {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func (b *mempool) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1e9), nil
+}
+
+func (b *mempool) mine(hash common.Hash) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.receipts[hash] = &types.Receipt{TxHash: hash, Status: types.ReceiptStatusSuccessful}
+}
+
+// sendPending sends a transfer through a TxManager on b and returns it
+// unmined, sent long enough ago to be stuck.
+func sendPending(t *testing.T, b *mempool) (*TxManager, *PendingTx) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewTxManager(b, key, simulatedChainID)
+	p, err := m.Send(context.Background(), func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		tx := types.NewTransaction(opts.Nonce.Uint64(), common.HexToAddress("0x01"), big.NewInt(1), 21000, big.NewInt(1e9), []byte("mint"))
+		signed, err := opts.Signer(opts.From, tx)
+		if err != nil {
+			return nil, err
+		}
+		return signed, b.SendTransaction(opts.Context, signed)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.BumpedAt = time.Now().Add(-m.StuckAfter - time.Second)
+	return m, p
+}
+
+func TestCheckReplacesStuck(t *testing.T) {
+	b := newMempool(t)
+	m, p := sendPending(t, b)
+	if _, err := m.Check(context.Background(), p); !errors.Is(err, ErrPending) {
+		t.Fatalf("Check = %v, want ErrPending", err)
+	}
+	if len(p.Hashes) != 2 {
+		t.Fatalf("got %d versions, want a replacement", len(p.Hashes))
+	}
+	b.mine(p.Hashes[0])
+	receipt, err := m.Check(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.TxHash != p.Hashes[0] {
+		t.Errorf("got the receipt of %s, want the mined %s", receipt.TxHash.Hex(), p.Hashes[0].Hex())
+	}
+}
+
+func TestCheckKeepsPendingWhenReplacementFails(t *testing.T) {
+	b := newMempool(t)
+	m, p := sendPending(t, b)
+	b.sendErr = errors.New("replacement transaction underpriced")
+	if _, err := m.Check(context.Background(), p); !errors.Is(err, ErrPending) {
+		t.Fatalf("Check = %v, want ErrPending", err)
+	}
+	if len(p.Hashes) != 1 {
+		t.Errorf("got %d versions, want the refused replacement left out", len(p.Hashes))
+	}
+	if time.Since(p.BumpedAt) > time.Second {
+		t.Error("a refused replacement should wait until stuck again")
+	}
+}
+
+func TestCheckCancelsTimedOut(t *testing.T) {
+	b := newMempool(t)
+	m, p := sendPending(t, b)
+	p.SentAt = time.Now().Add(-m.Timeout - time.Second)
+	ctx := context.Background()
+
+	if _, err := m.Check(ctx, p); !errors.Is(err, ErrPending) {
+		t.Fatalf("Check = %v, want ErrPending while the cancellation is pending", err)
+	}
+	if len(p.Cancels) != 1 {
+		t.Fatalf("got %d cancellations, want 1", len(p.Cancels))
+	}
+	original, _, _ := b.TransactionByHash(ctx, p.Hashes[0])
+	cancel, _, _ := b.TransactionByHash(ctx, p.Cancels[0])
+	if cancel.Nonce() != original.Nonce() || *cancel.To() != m.From() || cancel.Value().Sign() != 0 || len(cancel.Data()) != 0 {
+		t.Errorf("cancellation isn't an empty transfer to self with the same nonce: %+v", cancel)
+	}
+	if cancel.GasPrice().Cmp(original.GasPrice()) <= 0 {
+		t.Errorf("cancellation pays %s, no more than the original %s", cancel.GasPrice(), original.GasPrice())
+	}
+
+	b.mine(p.Cancels[0])
+	if _, err := m.Check(ctx, p); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Check = %v, want ErrTimeout once cancelled", err)
+	}
+}
+
+func TestCheckTimedOutStillMined(t *testing.T) {
+	b := newMempool(t)
+	m, p := sendPending(t, b)
+	p.SentAt = time.Now().Add(-m.Timeout - time.Second)
+	ctx := context.Background()
+	if _, err := m.Check(ctx, p); !errors.Is(err, ErrPending) {
+		t.Fatalf("Check = %v, want ErrPending", err)
+	}
+	// the original beat its cancellation
+	b.mine(p.Hashes[0])
+	if _, err := m.Check(ctx, p); err != nil {
+		t.Fatalf("Check = %v, want the original's receipt", err)
+	}
+}
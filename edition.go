@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/store"
+)
+
+// warpcastCompose opens a cast draft in Warpcast.
+const warpcastCompose = "https://warpcast.com/~/compose"
+
+// shareURL drafts a cast embedding the edition frame of job's token, so
+// others can mint copies of it.
+func shareURL(job store.MintJob) string {
+	id := job.ID
+	if job.CopyOf != "" {
+		id = job.CopyOf
+	}
+	frame := fmt.Sprintf("%s/edition?job=%s", BASE_URL, id)
+	return fmt.Sprintf("%s?text=%s&embeds[]=%s", warpcastCompose, url.QueryEscape("Mint a copy of this chop"), url.QueryEscape(frame))
+}
+
+// edition returns the confirmed mint job in the query, if its chain mints
+// editions.
+func (s *server) edition(r *http.Request) (store.MintJob, error) {
+	job, err := s.mints.Edition(r.URL.Query().Get("job"))
+	if err != nil {
+		return job, err
+	}
+	if chain, ok := s.chains.Get(job.Chain); !ok || !chain.Editions() {
+		return job, store.ErrNotFound
+	}
+	return job, nil
+}
+
+// handleEdition renders the frame of a shared token, offering a copy.
+func (s *server) handleEdition(w http.ResponseWriter, r *http.Request) {
+	job, err := s.edition(r)
+	if err != nil {
+		log.Println("failed to get edition: ", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	session, err := s.getSession(job.FID, job.Session)
+	if err != nil {
+		log.Println("failed to get session: ", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	frame := fc.Frame{
+		FrameV:  "vNext",
+		Image:   s.store.URL(session.Key),
+		PostURL: fmt.Sprintf("%s/edition/mint?job=%s", BASE_URL, job.ID),
+		Buttons: []fc.Button{
+			{
+				Label:  []byte("Mint a copy"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("Make your own"),
+				Action: fc.ActionPOST,
+				Target: []byte(fmt.Sprintf("%s/start", BASE_URL)),
+			},
+		},
+	}
+	frame.Render(w)
+}
+
+// handleEditionMint queues a copy of the shared token in the query to the
+// user's first verified address.
+func (s *server) handleEditionMint(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	fid := packet.UntrustedData.FID
+	original, err := s.edition(r)
+	if err != nil {
+		log.Println("failed to get edition: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	retry := fmt.Sprintf("%s/edition/mint?job=%s", BASE_URL, original.ID)
+	if err := s.checkAllowed(r.Context(), packet); err != nil {
+		s.renderMintRefused(w, r, fid, retry, err)
+		return
+	}
+
+	addrs, err := s.mintAddresses(r.Context(), fid)
+	if err != nil {
+		log.Println("failed to get user: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(addrs) == 0 {
+		s.renderError(w, r, fid, retry, "Verify an address to mint", "Add an Ethereum address to your", "Farcaster profile")
+		return
+	}
+
+	job, err := s.mints.Enqueue(store.MintJob{
+		ID:          uuid.New().String(),
+		FID:         fid,
+		Session:     original.Session,
+		Chain:       original.Chain,
+		Image:       original.Image,
+		MessageHash: packet.UntrustedData.MessageHash,
+		CopyOf:      original.ID,
+		TokenID:     original.Mint.TokenID,
+		MetadataURI: original.MetadataURI,
+		To:          addrs[0].Hex(),
+	}, s.policy.quota)
+	if err != nil {
+		s.renderMintRefused(w, r, fid, retry, err)
+		return
+	}
+	log.Printf("queued mint %s of a copy of token %s to %s", job.ID, job.TokenID, job.To)
+	s.renderMintStatus(w, r, job)
+}
@@ -1,46 +1,182 @@
 package farcaster
 
 import (
-	"os"
+	"container/list"
+	"context"
+	"fmt"
+	"image"
 	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 var (
-	cacheDir = "tmp/framecache"
-	once     sync.Once
-	Cache    *PfpCache
+	Cache = NewPfpCache(pfpCacheSize, pfpCacheTTL)
+	// Disk is the PFP disk cache once opened with OpenDisk. Until then
+	// every PFP load is fetched.
+	Disk *DiskCache
+)
+
+// DefaultCacheDir is where the server keeps its PFP disk cache.
+const DefaultCacheDir = "tmp/framecache"
+
+const (
+	pfpCacheSize = 256
+	pfpCacheTTL  = 30 * time.Minute
+	pfpLoadLimit = 30 * time.Second
+
+	diskCacheBytes = 512 << 20
 )
 
+// OpenDisk opens the PFP disk cache in dir, creating it if needed.
+func OpenDisk(dir string) error {
+	d, err := NewDiskCache(dir, diskCacheBytes)
+	if err != nil {
+		return err
+	}
+	Disk = d
+	return nil
+}
+
+// PfpCache is a bounded LRU of decoded PFPs keyed by FID. Entries older
+// than the TTL are refetched, and concurrent loads of the same FID share
+// a single fetch.
 type PfpCache struct {
-	m    sync.RWMutex
-	pfps map[uint64]string
+	m     sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	pfps  map[uint64]*list.Element
+	group singleflight.Group
+	stats CacheStats
 }
 
-func init() {
-	once.Do(func() {
-		os.MkdirAll(cacheDir, 0755)
-		Cache = &PfpCache{
-			pfps: make(map[uint64]string),
-		}
-	})
+type pfpEntry struct {
+	fid     uint64
+	url     string
+	img     image.Image
+	fetched time.Time
+}
 
+// CacheStats counts cache lookups since the cache was created.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Expired   uint64 `json:"expired"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
 }
 
+func NewPfpCache(size int, ttl time.Duration) *PfpCache {
+	return &PfpCache{
+		size: size,
+		ttl:  ttl,
+		ll:   list.New(),
+		pfps: make(map[uint64]*list.Element),
+	}
+}
+
+// get returns the fresh entry for fid, counting the lookup.
+func (c *PfpCache) get(fid uint64) (*pfpEntry, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	el, ok := c.pfps[fid]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*pfpEntry)
+	if time.Since(e.fetched) > c.ttl {
+		c.stats.Expired++
+		c.stats.Misses++
+		c.ll.Remove(el)
+		delete(c.pfps, fid)
+		return nil, false
+	}
+	c.stats.Hits++
+	c.ll.MoveToFront(el)
+	return e, true
+}
+
+// GetPfpUrl returns the cached PFP URL for fid, or "" if it isn't cached.
 func (c *PfpCache) GetPfpUrl(fid uint64) string {
-	c.m.RLock()
-	defer c.m.RUnlock()
-	if url, ok := c.pfps[fid]; ok {
-		return url
+	if e, ok := c.get(fid); ok {
+		return e.url
 	}
 	return ""
 }
 
-func (c *PfpCache) SetPfpUrl(fid uint64, url string) {
+// Set stores the PFP of fid, evicting the least recently used entry when
+// the cache is full.
+func (c *PfpCache) Set(fid uint64, url string, img image.Image) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	e := &pfpEntry{fid: fid, url: url, img: img, fetched: time.Now()}
+	if el, ok := c.pfps[fid]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.pfps[fid] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.pfps, oldest.Value.(*pfpEntry).fid)
+		c.stats.Evictions++
+	}
+}
+
+// Load returns the cached PFP for fid, calling load on a miss. Concurrent
+// misses for the same fid wait for a single call to load. The load is not
+// cancelled if the caller that started it goes away, since others may
+// still be waiting on it.
+func (c *PfpCache) Load(ctx context.Context, fid uint64, load func(ctx context.Context) (string, image.Image, error)) (image.Image, error) {
+	if e, ok := c.get(fid); ok {
+		return e.img, nil
+	}
+
+	ch := c.group.DoChan(fmt.Sprint(fid), func() (val interface{}, err error) {
+		// DoChan re-panics a panicking load in its own goroutine, which
+		// would take the server down
+		defer func() {
+			if r := recover(); r != nil {
+				val, err = nil, fmt.Errorf("loading pfp of %d panicked: %v", fid, r)
+			}
+		}()
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pfpLoadLimit)
+		defer cancel()
+		url, img, err := load(loadCtx)
+		if err != nil {
+			return nil, err
+		}
+		c.Set(fid, url, img)
+		return img, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(image.Image), nil
+	}
+}
+
+func (c *PfpCache) Stats() CacheStats {
 	c.m.Lock()
 	defer c.m.Unlock()
-	// bust cache so we don't stay stale
-	if len(c.pfps) > 10 {
-		c.pfps = make(map[uint64]string)
+	stats := c.stats
+	stats.Entries = c.ll.Len()
+	return stats
+}
+
+// EachCachedPFP passes each image in the PFP disk cache to fn in turn.
+func EachCachedPFP(fn func(image.Image)) error {
+	if Disk == nil {
+		return nil
 	}
-	c.pfps[fid] = url
+	return Disk.EachImage(fn)
 }
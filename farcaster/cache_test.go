@@ -0,0 +1,179 @@
+package farcaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPfpCacheLoadRecoversPanic(t *testing.T) {
+	c := NewPfpCache(10, time.Minute)
+	_, err := c.Load(context.Background(), 3, func(ctx context.Context) (string, image.Image, error) {
+		panic("bad image")
+	})
+	if err == nil {
+		t.Fatal("expected an error from a panicking load")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	got, err := c.Load(context.Background(), 3, func(ctx context.Context) (string, image.Image, error) {
+		return "https://example.com/3.png", img, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != img {
+		t.Error("load after a panic did not return the loaded image")
+	}
+}
+
+// loadImage returns a load func for an image of the given width.
+func loadImage(width int) func(ctx context.Context) (string, image.Image, error) {
+	return func(ctx context.Context) (string, image.Image, error) {
+		return fmt.Sprintf("https://example.com/%d.png", width), image.NewRGBA(image.Rect(0, 0, width, 1)), nil
+	}
+}
+
+func TestPfpCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewPfpCache(2, time.Hour)
+	ctx := context.Background()
+	for fid := uint64(1); fid <= 2; fid++ {
+		if _, err := c.Load(ctx, fid, loadImage(int(fid))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// using 1 leaves 2 as the least recently used
+	if c.GetPfpUrl(1) == "" {
+		t.Fatal("1 isn't cached")
+	}
+	if _, err := c.Load(ctx, 3, loadImage(3)); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetPfpUrl(2) != "" {
+		t.Error("2 wasn't evicted")
+	}
+	for _, fid := range []uint64{1, 3} {
+		if c.GetPfpUrl(fid) == "" {
+			t.Errorf("%d was evicted", fid)
+		}
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("stats = %+v, want 1 eviction of 2 entries", stats)
+	}
+}
+
+func TestPfpCacheExpires(t *testing.T) {
+	c := NewPfpCache(10, time.Hour)
+	ctx := context.Background()
+	if _, err := c.Load(ctx, 1, loadImage(1)); err != nil {
+		t.Fatal(err)
+	}
+	c.m.Lock()
+	c.pfps[1].Value.(*pfpEntry).fetched = time.Now().Add(-2 * time.Hour)
+	c.m.Unlock()
+
+	img, err := c.Load(ctx, 1, loadImage(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 5 {
+		t.Error("expired entry wasn't loaded again")
+	}
+	if stats := c.Stats(); stats.Expired != 1 {
+		t.Errorf("stats = %+v, want 1 expired", stats)
+	}
+}
+
+func TestPfpCacheCoalescesLoads(t *testing.T) {
+	c := NewPfpCache(10, time.Hour)
+	release := make(chan struct{})
+	var calls int32
+	load := func(ctx context.Context) (string, image.Image, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return loadImage(7)(ctx)
+	}
+
+	const callers = 8
+	var started, done sync.WaitGroup
+	imgs := make([]image.Image, callers)
+	for i := 0; i < callers; i++ {
+		started.Add(1)
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			started.Done()
+			img, err := c.Load(context.Background(), 1, load)
+			if err != nil {
+				t.Error(err)
+			}
+			imgs[i] = img
+		}(i)
+	}
+	started.Wait()
+	// let the callers reach the load before it finishes
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	if calls != 1 {
+		t.Errorf("loaded %d times, want once", calls)
+	}
+	for i, img := range imgs {
+		if img != imgs[0] {
+			t.Errorf("caller %d got a different image", i)
+		}
+	}
+}
+
+func TestPfpCacheLoadOutlivesCaller(t *testing.T) {
+	c := NewPfpCache(10, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		_, err := c.Load(ctx, 1, func(ctx context.Context) (string, image.Image, error) {
+			cancel()
+			<-release
+			return loadImage(3)(ctx)
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Load = %v, want context.Canceled", err)
+		}
+	}()
+	<-loaded
+	close(release)
+	// the load carries on and caches the image for the next caller
+	deadline := time.Now().Add(5 * time.Second)
+	for c.GetPfpUrl(1) == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("abandoned load wasn't cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPfpCacheStats(t *testing.T) {
+	c := NewPfpCache(10, time.Hour)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Load(ctx, 1, loadImage(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Load(ctx, 2, func(ctx context.Context) (string, image.Image, error) {
+		return "", nil, errors.New("no pfp")
+	}); err == nil {
+		t.Fatal("failed load returned no error")
+	}
+	want := CacheStats{Hits: 2, Misses: 2, Entries: 1}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
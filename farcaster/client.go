@@ -1,21 +1,46 @@
 package farcaster
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"log"
-	"net/http"
-	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/treethought/impression-frame/internal/httpretry"
 	"github.com/treethought/impression-frame/util"
 )
 
-var NEYNAR_API_KEY = os.Getenv("API_KEY")
+var (
+	_ Client = (*NeynarClient)(nil)
+	_ Client = (*HubClient)(nil)
+	_ Client = (*FakeClient)(nil)
+)
 
-const API_URL = "https://hub-api.neynar.com"
+// ErrNotFound is returned when a user lookup matches nobody.
+var ErrNotFound = httpretry.ErrNotFound
+
+// Client reads users and validates frame messages from a Farcaster data
+// source, such as the Neynar API or a hub's HTTP API.
+type Client interface {
+	GetUser(ctx context.Context, fid uint64) (*User, error)
+	GetUsers(ctx context.Context, fids ...uint64) ([]User, error)
+	SearchUsers(ctx context.Context, query string, viewer uint64) ([]User, error)
+	GetVerifications(ctx context.Context, fid uint64) ([]string, error)
+	GetFollowers(ctx context.Context, fid uint64, limit int) ([]User, error)
+	GetFollowing(ctx context.Context, fid uint64, limit int) ([]User, error)
+	// IsFollowing reports whether fid follows target.
+	IsFollowing(ctx context.Context, fid, target uint64) (bool, error)
+	// GetCast returns the cast by fid with the 0x prefixed hash.
+	GetCast(ctx context.Context, fid uint64, hash string) (*Cast, error)
+	ValidateMessage(ctx context.Context, messageBytes string) (*ValidatedMessage, error)
+}
 
 type User struct {
+	FID          uint64   `json:"fid"`
 	Username     string   `json:"username"`
 	DisplayName  string   `json:"display_name"`
 	PfpUrl       string   `json:"pfp_url"`
@@ -27,102 +52,190 @@ type User struct {
 	} `json:"profile"`
 }
 
-type Users struct {
-	Users []User `json:"users"`
+// Cast is a cast a frame was shown in.
+type Cast struct {
+	FID  uint64 `json:"fid"`
+	Hash string `json:"hash"`
+	// ParentURL is the channel URL of casts in a channel.
+	ParentURL string `json:"parent_url,omitempty"`
+	// Channel is the channel id, when known.
+	Channel string `json:"channel,omitempty"`
 }
 
-type SearchResult struct {
-	Result struct {
-		Users []User
+// channelURLPrefix starts the parent URL of casts in a Warpcast channel.
+const channelURLPrefix = "https://warpcast.com/~/channel/"
+
+// InChannel reports whether the cast is in channel, given as an id such
+// as "art" or as a parent URL.
+func (c *Cast) InChannel(channel string) bool {
+	if channel == "" {
+		return false
 	}
+	if strings.EqualFold(c.Channel, channel) || c.ParentURL == channel {
+		return true
+	}
+	return strings.EqualFold(c.ParentURL, channelURLPrefix+channel)
 }
 
-func GetUserName(name string, viewer uint64) (*User, error) {
-	url := fmt.Sprintf("https://api.neynar.com/v2/farcaster/user/search?q=%s&viewer_fid=%d", name, viewer)
-	fmt.Println(url)
-
-	req, _ := http.NewRequest("GET", url, nil)
-
-	req.Header.Add("accept", "application/json")
-	req.Header.Add("api_key", NEYNAR_API_KEY)
-
-	res, _ := http.DefaultClient.Do(req)
+// ValidatedMessage is the trusted content of a signed frame action.
+type ValidatedMessage struct {
+	Valid       bool
+	FID         uint64
+	URL         string
+	ButtonIndex int
+	InputText   string
+	CastFID     uint64
+	// CastHash is 0x prefixed hex.
+	CastHash    string
+	MessageHash string
+	// Timestamp is when the message was signed.
+	Timestamp time.Time
+}
 
-	var resp SearchResult
-	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+// GetUserName searches for a user by username. An exact match is preferred
+// over the first search result.
+func GetUserName(ctx context.Context, c Client, name string, viewer uint64) (*User, error) {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+	if name == "" {
+		return nil, fmt.Errorf("user %q: %w", name, ErrNotFound)
+	}
+	users, err := c.SearchUsers(ctx, name, viewer)
+	if err != nil {
 		return nil, err
 	}
-	if len(resp.Result.Users) == 0 {
-		return nil, fmt.Errorf("no users found")
+	if len(users) == 0 {
+		return nil, fmt.Errorf("user %q: %w", name, ErrNotFound)
+	}
+	for i, u := range users {
+		if strings.EqualFold(u.Username, name) {
+			return &users[i], nil
+		}
 	}
-	return &resp.Result.Users[0], nil
+	return &users[0], nil
 }
 
-func GetUser(fid uint64) (*User, error) {
-
-	url := fmt.Sprintf("https://api.neynar.com/v2/farcaster/user/bulk?fids=%d", fid)
-	fmt.Println(url)
-
-	req, _ := http.NewRequest("GET", url, nil)
-
-	req.Header.Add("accept", "application/json")
-	req.Header.Add("api_key", NEYNAR_API_KEY)
-
-	res, _ := http.DefaultClient.Do(req)
-
-	// d, _ := io.ReadAll(res.Body)
-	// fmt.Println(string(d))
+// pfpRevalidateAfter is how long a cached PFP is trusted before asking
+// its host whether it changed. Avatars often change without their URL
+// changing, e.g. behind gateways and redirects.
+const pfpRevalidateAfter = 30 * time.Minute
+
+// LoadPFP returns the image at pfpUrl, reading it from the disk cache
+// when it was fetched before. Stale entries are revalidated with a
+// conditional request, and served as is if the host can't be reached.
+func LoadPFP(ctx context.Context, pfpUrl string) (image.Image, error) {
+	disk := Disk
+	if disk == nil {
+		img, _, _, err := util.FetchImageIfChanged(ctx, pfpUrl, util.Validators{})
+		return img, err
+	}
+	cached, meta, err := disk.Get(pfpUrl)
+	if err == nil && time.Since(meta.FetchedAt) < pfpRevalidateAfter {
+		return cached, nil
+	}
 
-	var resp Users
-	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
-		return nil, err
+	var validators util.Validators
+	if cached != nil {
+		validators = meta.Validators()
 	}
-	if len(resp.Users) == 0 {
-		return nil, fmt.Errorf("no users found")
+	img, format, validators, err := util.FetchImageIfChanged(ctx, pfpUrl, validators)
+	if errors.Is(err, util.ErrNotModified) {
+		if err := disk.Touch(pfpUrl); err != nil {
+			log.Println("failed to touch cached pfp: ", err)
+		}
+		return cached, nil
+	}
+	if err != nil {
+		log.Println("failed to fetch image: ", err)
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, err
 	}
-	fmt.Println(resp.Users[0].PfpUrl)
-	return &resp.Users[0], nil
 
+	meta = &CacheMeta{
+		URL:          pfpUrl,
+		ETag:         validators.ETag,
+		LastModified: validators.LastModified,
+		Format:       format,
+	}
+	if err := disk.Put(img, *meta); err != nil {
+		log.Println("failed to cache pfp: ", err)
+	}
+	return img, nil
 }
 
-func GetOrLoadPFP(fid uint64) (image.Image, error) {
-	known := Cache.GetPfpUrl(fid)
-	if known != "" {
-		cached, _, err := util.LoadImage(known)
-		if err == nil {
-			return cached, nil
-		}
-		img, _, err := util.FetchImage(known)
-		if err != nil {
-			log.Println("failed to fetch image: ", err)
-			return nil, err
+// LoadPFPs loads the PFPs of users using at most workers concurrent
+// fetches, returning those loaded within wait. Users without a PFP or
+// whose image fails to load in time are skipped; the order of the
+// remaining images follows users. Fetches still running carry on in the
+// background to warm the cache for next time.
+func LoadPFPs(ctx context.Context, users []User, workers int, wait time.Duration) []image.Image {
+	if workers < 1 {
+		workers = 1
+	}
+	fetchCtx := context.WithoutCancel(ctx)
+	var mu sync.Mutex
+	imgs := make([]image.Image, len(users))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, u := range users {
+		if u.PfpUrl == "" {
+			continue
 		}
-		cachePath := fmt.Sprintf("%s/%s.png", cacheDir, util.EscapeURL(known))
-		util.WriteImage(cachePath, img)
-		return img, nil
+		wg.Add(1)
+		go func(i int, pfpUrl string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			img, err := LoadPFP(fetchCtx, pfpUrl)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			imgs[i] = img
+			mu.Unlock()
+		}(i, u.PfpUrl)
 	}
 
-	log.Println("fetching pfp for fid: ", fid)
-	user, err := GetUser(fid)
-	if err != nil {
-		log.Println("failed to get pfp: ", err)
-		return nil, err
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	case <-ctx.Done():
 	}
-	pfpUrl := user.PfpUrl
-	log.Println("pfp url: ", pfpUrl)
-	Cache.SetPfpUrl(fid, pfpUrl)
 
-	cachePath := fmt.Sprintf("%s/%s.png", cacheDir, util.EscapeURL(pfpUrl))
-	img, _, err := util.LoadImage(cachePath)
-	if err != nil {
+	mu.Lock()
+	defer mu.Unlock()
+	loaded := []image.Image{}
+	for _, img := range imgs {
+		if img != nil {
+			loaded = append(loaded, img)
+		}
+	}
+	return loaded
+}
 
-		img, _, err = util.FetchImage(pfpUrl)
+// GetOrLoadPFP returns the PFP of fid from the in-memory cache, looking
+// up the user and loading their image on a miss.
+func GetOrLoadPFP(ctx context.Context, c Client, fid uint64) (image.Image, error) {
+	return Cache.Load(ctx, fid, func(ctx context.Context) (string, image.Image, error) {
+		log.Println("fetching pfp for fid: ", fid)
+		user, err := c.GetUser(ctx, fid)
 		if err != nil {
-			log.Println("failed to fetch image: ", err)
-			return nil, err
+			log.Println("failed to get pfp: ", err)
+			return "", nil, err
 		}
-		cachePath := fmt.Sprintf("%s/%s.png", cacheDir, util.EscapeURL(pfpUrl))
-		util.WriteImage(cachePath, img)
-	}
-	return img, nil
+		log.Println("pfp url: ", user.PfpUrl)
+		img, err := LoadPFP(ctx, user.PfpUrl)
+		if err != nil {
+			return "", nil, err
+		}
+		return user.PfpUrl, img, nil
+	})
 }
@@ -0,0 +1,172 @@
+package farcaster
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/treethought/impression-frame/util"
+)
+
+// useDisk points LoadPFP at a fresh disk cache and a fetcher that can
+// reach httptest servers, for the duration of the test.
+func useDisk(t *testing.T) *DiskCache {
+	t.Helper()
+	disk, err := NewDiskCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDisk, oldFetcher := Disk, util.DefaultFetcher
+	Disk = disk
+	util.DefaultFetcher = util.NewFetcherAllowing(func(ip net.IP) bool {
+		return ip.IsLoopback() || util.IsPublicIP(ip)
+	})
+	t.Cleanup(func() {
+		Disk, util.DefaultFetcher = oldDisk, oldFetcher
+	})
+	return disk
+}
+
+// pfpServer serves a PNG with validators, answering 304 to requests
+// carrying the current ETag.
+type pfpServer struct {
+	mu sync.Mutex
+	// conditional holds the validators of each request.
+	conditional []http.Header
+	downloads   int
+	etag        string
+	body        []byte
+}
+
+const pfpLastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+func (s *pfpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conditional = append(s.conditional, http.Header{
+		"If-None-Match":     r.Header.Values("If-None-Match"),
+		"If-Modified-Since": r.Header.Values("If-Modified-Since"),
+	})
+	w.Header().Set("ETag", s.etag)
+	w.Header().Set("Last-Modified", pfpLastModified)
+	if r.Header.Get("If-None-Match") == s.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.downloads++
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(s.body)
+}
+
+func (s *pfpServer) serve(t *testing.T, etag string, c color.Color) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, solidImage(4, c)); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.etag, s.body = etag, buf.Bytes()
+}
+
+// age makes the cached entry for url due for revalidation.
+func age(t *testing.T, disk *DiskCache, url string) {
+	t.Helper()
+	img, meta, err := disk.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.FetchedAt = time.Now().Add(-2 * pfpRevalidateAfter)
+	if err := disk.Put(img, *meta); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPFPRevalidates(t *testing.T) {
+	disk := useDisk(t)
+	pfps := &pfpServer{}
+	pfps.serve(t, `"v1"`, color.White)
+	srv := httptest.NewServer(pfps)
+	defer srv.Close()
+	url := srv.URL + "/pfp.png"
+	ctx := context.Background()
+
+	if _, err := LoadPFP(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	// fresh entries are served without asking
+	if _, err := LoadPFP(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	if len(pfps.conditional) != 1 || pfps.downloads != 1 {
+		t.Fatalf("made %d requests and %d downloads, want 1 of each", len(pfps.conditional), pfps.downloads)
+	}
+	if got := pfps.conditional[0]; got.Get("If-None-Match") != "" || got.Get("If-Modified-Since") != "" {
+		t.Errorf("first fetch sent validators %v", got)
+	}
+
+	age(t, disk, url)
+	img, err := LoadPFP(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := pfps.conditional[1]
+	if got.Get("If-None-Match") != `"v1"` || got.Get("If-Modified-Since") != pfpLastModified {
+		t.Errorf("revalidation sent %v, want the cached validators", got)
+	}
+	if pfps.downloads != 1 {
+		t.Errorf("downloaded %d times, want the 304 to reuse the cached image", pfps.downloads)
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r != 0xffff {
+		t.Error("didn't return the cached image")
+	}
+	_, meta, err := disk.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(meta.FetchedAt) > time.Minute {
+		t.Errorf("304 didn't touch the entry, fetched at %v", meta.FetchedAt)
+	}
+
+	// a changed image is downloaded and replaces the entry
+	pfps.serve(t, `"v2"`, color.Black)
+	age(t, disk, url)
+	img, err = LoadPFP(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pfps.downloads != 2 {
+		t.Errorf("downloaded %d times, want the changed image", pfps.downloads)
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r != 0 {
+		t.Error("returned the stale image")
+	}
+	if _, meta, err = disk.Get(url); err != nil || meta.ETag != `"v2"` {
+		t.Errorf("cached etag = %v, %v, want \"v2\"", meta, err)
+	}
+}
+
+func TestLoadPFPServesStaleWhenUnreachable(t *testing.T) {
+	disk := useDisk(t)
+	pfps := &pfpServer{}
+	pfps.serve(t, `"v1"`, color.White)
+	srv := httptest.NewServer(pfps)
+	url := srv.URL + "/pfp.png"
+	ctx := context.Background()
+
+	if _, err := LoadPFP(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	age(t, disk, url)
+	if _, err := LoadPFP(ctx, url); err != nil {
+		t.Errorf("LoadPFP = %v, want the stale image", err)
+	}
+}
@@ -0,0 +1,362 @@
+package farcaster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"image"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/treethought/impression-frame/util"
+)
+
+// ErrCacheMiss is returned by DiskCache.Get when nothing usable is cached.
+var ErrCacheMiss = errors.New("cache miss")
+
+// staleTempAge is how old an abandoned temporary file must be before the
+// cache removes it.
+const staleTempAge = time.Hour
+
+// CacheMeta is stored in a sidecar next to each cached image.
+type CacheMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+	// Format is the format the image was served in; cached images are
+	// stored as PNG, or GIF when animated.
+	Format string `json:"format"`
+}
+
+// Validators returns the conditional request validators for the entry.
+func (m *CacheMeta) Validators() util.Validators {
+	return util.Validators{ETag: m.ETag, LastModified: m.LastModified}
+}
+
+// DiskCache stores fetched images on disk under the SHA-256 of their
+// source URL, with a JSON sidecar of metadata. Writes are atomic, entries
+// that fail to decode are dropped, and the least recently fetched entries
+// are evicted once the cache grows past maxBytes.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	// mu guards the byte counts, and is held while files are removed or
+	// measured so they can't change between the two.
+	mu    sync.Mutex
+	bytes int64
+	sizes map[string]int64
+}
+
+// NewDiskCache opens the cache in dir, creating it if needed. A maxBytes
+// of 0 disables eviction.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &DiskCache{dir: dir, maxBytes: maxBytes, sizes: make(map[string]int64)}
+	entries, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		c.sizes[e.key] = e.size
+		c.bytes += e.size
+	}
+	return c, c.evict()
+}
+
+func (c *DiskCache) key(url string) string {
+	h := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(h[:])
+}
+
+func (c *DiskCache) imagePath(key string) string {
+	return filepath.Join(c.dir, key+".png")
+}
+
+func (c *DiskCache) animatedPath(key string) string {
+	return filepath.Join(c.dir, key+".gif")
+}
+
+// storedPath returns the path of the image stored for key, preferring an
+// animated copy.
+func (c *DiskCache) storedPath(key string) string {
+	if _, err := os.Stat(c.animatedPath(key)); err == nil {
+		return c.animatedPath(key)
+	}
+	return c.imagePath(key)
+}
+
+func (c *DiskCache) metaPath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// Get returns the cached image for url and its metadata. Corrupt or
+// mismatched entries are removed and reported as ErrCacheMiss.
+func (c *DiskCache) Get(url string) (image.Image, *CacheMeta, error) {
+	key := c.key(url)
+
+	meta, err := c.readMeta(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrCacheMiss
+	}
+	if err != nil || meta.URL != url {
+		log.Printf("dropping bad cache metadata for %s: %v", url, err)
+		c.remove(key)
+		return nil, nil, ErrCacheMiss
+	}
+
+	img, _, err := util.LoadImage(c.storedPath(key))
+	if err != nil {
+		log.Printf("dropping corrupt cache entry for %s: %v", url, err)
+		c.remove(key)
+		return nil, nil, ErrCacheMiss
+	}
+	return img, meta, nil
+}
+
+func (c *DiskCache) readMeta(key string) (*CacheMeta, error) {
+	data, err := os.ReadFile(c.metaPath(key))
+	if err != nil {
+		return nil, err
+	}
+	var meta CacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Put stores img for meta.URL. The image is written before its sidecar so
+// a crash never leaves metadata pointing at a missing image.
+func (c *DiskCache) Put(img image.Image, meta CacheMeta) error {
+	key := c.key(meta.URL)
+	if meta.FetchedAt.IsZero() {
+		meta.FetchedAt = time.Now()
+	}
+
+	path, stale, format := c.imagePath(key), c.animatedPath(key), "png"
+	if _, ok := img.(*util.Animated); ok {
+		path, stale, format = stale, path, "gif"
+	}
+	err := util.WriteFileAtomic(path, func(w io.Writer) error {
+		return util.EncodeImage(w, img, format)
+	})
+	if err != nil {
+		return err
+	}
+	os.Remove(stale)
+	err = util.WriteFileAtomic(c.metaPath(key), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(meta)
+	})
+	if err != nil {
+		c.remove(key)
+		return err
+	}
+
+	c.account(key)
+	return c.evict()
+}
+
+// Touch updates the fetch time of url without rewriting its image.
+func (c *DiskCache) Touch(url string) error {
+	key := c.key(url)
+	meta, err := c.readMeta(key)
+	if err != nil {
+		return err
+	}
+	meta.FetchedAt = time.Now()
+	err = util.WriteFileAtomic(c.metaPath(key), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(meta)
+	})
+	if err != nil {
+		return err
+	}
+	c.account(key)
+	return nil
+}
+
+// Remove drops the entry for url.
+func (c *DiskCache) Remove(url string) {
+	c.remove(c.key(url))
+}
+
+func (c *DiskCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	os.Remove(c.metaPath(key))
+	os.Remove(c.imagePath(key))
+	os.Remove(c.animatedPath(key))
+	c.bytes -= c.sizes[key]
+	delete(c.sizes, key)
+}
+
+// account measures the files of key after they were written. Concurrent
+// writes of the same key are each measured against the size last
+// recorded, so the count matches whichever write landed last.
+func (c *DiskCache) account(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := c.entrySize(key)
+	c.bytes += size - c.sizes[key]
+	if size == 0 {
+		delete(c.sizes, key)
+	} else {
+		c.sizes[key] = size
+	}
+}
+
+func (c *DiskCache) entrySize(key string) int64 {
+	var size int64
+	for _, p := range []string{c.imagePath(key), c.animatedPath(key), c.metaPath(key)} {
+		if fi, err := os.Stat(p); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+// Size returns the number of bytes used by the cache.
+func (c *DiskCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+type cacheEntry struct {
+	key     string
+	size    int64
+	modTime time.Time
+}
+
+// entries groups the files in the cache directory by key. Files from older
+// cache layouts are included so they are the first to be evicted.
+func (c *DiskCache) entries() ([]cacheEntry, error) {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	byKey := map[string]*cacheEntry{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		name := f.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			if time.Since(info.ModTime()) > staleTempAge {
+				os.Remove(filepath.Join(c.dir, name))
+			}
+			continue
+		}
+		key := strings.TrimSuffix(name, filepath.Ext(name))
+		e, ok := byKey[key]
+		if !ok {
+			e = &cacheEntry{key: key}
+			byKey[key] = e
+		}
+		e.size += info.Size()
+		if info.ModTime().After(e.modTime) {
+			e.modTime = info.ModTime()
+		}
+	}
+
+	entries := make([]cacheEntry, 0, len(byKey))
+	for _, e := range byKey {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	return entries, nil
+}
+
+// evict removes the oldest entries until the cache fits in maxBytes.
+func (c *DiskCache) evict() error {
+	if c.maxBytes <= 0 || c.Size() <= c.maxBytes {
+		return nil
+	}
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if c.Size() <= c.maxBytes {
+			break
+		}
+		log.Printf("evicting cached pfp %s (%d bytes)", e.key, e.size)
+		c.removeEntry(e)
+	}
+	return nil
+}
+
+// removeEntry removes every file of e, including those of older layouts.
+func (c *DiskCache) removeEntry(e cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ext := range []string{".json", ".png", ".jpg", ".jpeg", ".gif"} {
+		os.Remove(filepath.Join(c.dir, e.key+ext))
+	}
+	c.bytes -= c.sizes[e.key]
+	delete(c.sizes, e.key)
+}
+
+// Prune removes entries that haven't been fetched or revalidated within
+// maxAge, returning how many entries and bytes were, or in a dry run would
+// be, removed.
+func (c *DiskCache) Prune(maxAge time.Duration, dryRun bool) (int, int64, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, 0, err
+	}
+	var removed int
+	var freed int64
+	for _, e := range entries {
+		if time.Since(e.modTime) <= maxAge {
+			// entries are sorted oldest first
+			break
+		}
+		if dryRun {
+			log.Printf("janitor would remove cached pfp %s (%d bytes)", e.key, e.size)
+		} else {
+			c.removeEntry(e)
+		}
+		removed++
+		freed += e.size
+	}
+	return removed, freed, nil
+}
+
+// EachImage decodes the cached images one at a time, passing each to fn
+// and skipping any that are corrupt, so only one is in memory at once.
+func (c *DiskCache) EachImage(fn func(image.Image)) error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		meta, err := c.readMeta(e.key)
+		if err != nil {
+			continue
+		}
+		img, _, err := c.Get(meta.URL)
+		if err != nil {
+			continue
+		}
+		fn(img)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package farcaster
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func solidImage(size int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// noisyImage compresses poorly, so entries of different sizes differ in
+// bytes on disk.
+func noisyImage(size int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i*7919 + i/3)
+	}
+	return img
+}
+
+// dirSize is the size of the cache's files, with temporary files
+// reported as an error.
+func dirSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".tmp-") {
+			t.Errorf("temporary file %s left behind", f.Name())
+		}
+		info, err := f.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+	return size
+}
+
+func TestDiskCachePutGet(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const url = "https://example.com/pfp.png"
+	if _, _, err := c.Get(url); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("Get before Put = %v, want ErrCacheMiss", err)
+	}
+	err = c.Put(solidImage(8, color.White), CacheMeta{URL: url, ETag: `"v1"`, Format: "jpeg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, meta, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 8 || meta.ETag != `"v1"` || meta.Format != "jpeg" || meta.FetchedAt.IsZero() {
+		t.Errorf("Get = %v, %+v", img.Bounds(), meta)
+	}
+	for _, ext := range []string{".png", ".json"} {
+		if _, err := os.Stat(filepath.Join(dir, c.key(url)+ext)); err != nil {
+			t.Errorf("no %s file: %v", ext, err)
+		}
+	}
+	if got, want := c.Size(), dirSize(t, dir); got != want {
+		t.Errorf("Size = %d, want %d", got, want)
+	}
+}
+
+func TestDiskCacheFailedWriteKeepsEntry(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const url = "https://example.com/pfp.png"
+	if err := c.Put(solidImage(8, color.White), CacheMeta{URL: url}); err != nil {
+		t.Fatal(err)
+	}
+	// an empty image can't be encoded, failing the write half way
+	if err := c.Put(image.NewRGBA(image.Rect(0, 0, 0, 0)), CacheMeta{URL: url}); err == nil {
+		t.Fatal("stored an empty image")
+	}
+	img, _, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 8 {
+		t.Errorf("failed write replaced the image with %v", img.Bounds())
+	}
+	if got, want := c.Size(), dirSize(t, dir); got != want {
+		t.Errorf("Size = %d, want %d", got, want)
+	}
+}
+
+func TestDiskCacheDropsCorruptEntries(t *testing.T) {
+	cases := []struct {
+		name string
+		// corrupt breaks the entry for url in c
+		corrupt func(c *DiskCache, url string) error
+	}{
+		{"image", func(c *DiskCache, url string) error {
+			return os.WriteFile(c.imagePath(c.key(url)), []byte("not a png"), 0644)
+		}},
+		{"metadata", func(c *DiskCache, url string) error {
+			return os.WriteFile(c.metaPath(c.key(url)), []byte("{"), 0644)
+		}},
+		{"url", func(c *DiskCache, url string) error {
+			return os.WriteFile(c.metaPath(c.key(url)), []byte(`{"url":"https://example.com/other.png"}`), 0644)
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			c, err := NewDiskCache(dir, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const url = "https://example.com/pfp.png"
+			if err := c.Put(solidImage(8, color.White), CacheMeta{URL: url}); err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.corrupt(c, url); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := c.Get(url); !errors.Is(err, ErrCacheMiss) {
+				t.Fatalf("Get of a corrupt entry = %v, want ErrCacheMiss", err)
+			}
+			if size := dirSize(t, dir); size != 0 || c.Size() != 0 {
+				t.Errorf("corrupt entry left %d bytes, counted %d", size, c.Size())
+			}
+			// and it can be cached again
+			if err := c.Put(solidImage(8, color.White), CacheMeta{URL: url}); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := c.Get(url); err != nil {
+				t.Errorf("Get after recaching = %v", err)
+			}
+		})
+	}
+}
+
+func TestDiskCacheEvictsLeastRecentlyFetched(t *testing.T) {
+	// measure an entry, as all of them are the same size
+	probe, err := NewDiskCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	meta := func(name string) CacheMeta {
+		return CacheMeta{URL: "https://example.com/" + name + ".png", FetchedAt: fetched}
+	}
+	img := solidImage(16, color.Black)
+	if err := probe.Put(img, meta("a")); err != nil {
+		t.Fatal(err)
+	}
+	entry := probe.Size()
+
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 2*entry+entry/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"a", "b"} {
+		if err := c.Put(img, meta(name)); err != nil {
+			t.Fatal(err)
+		}
+		// a was fetched before b
+		old := time.Now().Add(-time.Duration(2-i) * time.Hour)
+		key := c.key(meta(name).URL)
+		for _, p := range []string{c.imagePath(key), c.metaPath(key)} {
+			if err := os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// revalidating a makes b the least recently fetched
+	if err := c.Touch(meta("a").URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(img, meta("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, kept := range map[string]bool{"a": true, "b": false, "c": true} {
+		_, _, err := c.Get(meta(name).URL)
+		if kept && err != nil {
+			t.Errorf("%s was evicted: %v", name, err)
+		}
+		if !kept && err == nil {
+			t.Errorf("%s wasn't evicted", name)
+		}
+	}
+	if got, want := c.Size(), dirSize(t, dir); got != want || got > c.maxBytes {
+		t.Errorf("Size = %d, want %d within %d", got, want, c.maxBytes)
+	}
+}
+
+func TestDiskCacheCountsConcurrentPuts(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// the same url with images of different sizes
+			url := "https://example.com/same.png"
+			if i%4 == 0 {
+				url = fmt.Sprintf("https://example.com/%d.png", i)
+			}
+			if err := c.Put(noisyImage(4+i*2), CacheMeta{URL: url}); err != nil {
+				t.Error(err)
+			}
+			if i%5 == 0 {
+				c.Remove(url)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got, want := c.Size(), dirSize(t, dir); got != want {
+		t.Errorf("Size = %d, want %d", got, want)
+	}
+	reopened, err := NewDiskCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Size() != c.Size() {
+		t.Errorf("reopened Size = %d, want %d", reopened.Size(), c.Size())
+	}
+}
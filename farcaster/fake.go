@@ -0,0 +1,159 @@
+package farcaster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FakeClient is an in-memory Client for tests and local development.
+type FakeClient struct {
+	mu       sync.RWMutex
+	users    map[uint64]User
+	follows  map[uint64][]uint64
+	messages map[string]ValidatedMessage
+	casts    map[string]Cast
+}
+
+func NewFakeClient(users ...User) *FakeClient {
+	c := &FakeClient{
+		users:    make(map[uint64]User),
+		follows:  make(map[uint64][]uint64),
+		messages: make(map[string]ValidatedMessage),
+		casts:    make(map[string]Cast),
+	}
+	for _, u := range users {
+		c.AddUser(u)
+	}
+	return c
+}
+
+func (c *FakeClient) AddUser(u User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.users[u.FID] = u
+}
+
+// AddFollow records that fid follows target.
+func (c *FakeClient) AddFollow(fid, target uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.follows[fid] = append(c.follows[fid], target)
+}
+
+func (c *FakeClient) AddCast(cast Cast) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.casts[cast.Hash] = cast
+}
+
+// AddMessage registers the result returned when messageBytes is validated.
+func (c *FakeClient) AddMessage(messageBytes string, msg ValidatedMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages[messageBytes] = msg
+}
+
+func (c *FakeClient) GetUser(ctx context.Context, fid uint64) (*User, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	u, ok := c.users[fid]
+	if !ok {
+		return nil, fmt.Errorf("fid %d: %w", fid, ErrNotFound)
+	}
+	return &u, nil
+}
+
+func (c *FakeClient) GetUsers(ctx context.Context, fids ...uint64) ([]User, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	users := []User{}
+	for _, fid := range fids {
+		if u, ok := c.users[fid]; ok {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (c *FakeClient) SearchUsers(ctx context.Context, query string, viewer uint64) ([]User, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	users := []User{}
+	for _, u := range c.users {
+		if strings.Contains(strings.ToLower(u.Username), strings.ToLower(query)) {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+func (c *FakeClient) GetVerifications(ctx context.Context, fid uint64) ([]string, error) {
+	u, err := c.GetUser(ctx, fid)
+	if err != nil {
+		return nil, err
+	}
+	return u.Verfications, nil
+}
+
+func (c *FakeClient) GetFollowers(ctx context.Context, fid uint64, limit int) ([]User, error) {
+	c.mu.RLock()
+	fids := []uint64{}
+	for follower, targets := range c.follows {
+		for _, t := range targets {
+			if t == fid {
+				fids = append(fids, follower)
+			}
+		}
+	}
+	c.mu.RUnlock()
+	return c.limit(ctx, fids, limit)
+}
+
+func (c *FakeClient) GetFollowing(ctx context.Context, fid uint64, limit int) ([]User, error) {
+	c.mu.RLock()
+	fids := append([]uint64{}, c.follows[fid]...)
+	c.mu.RUnlock()
+	return c.limit(ctx, fids, limit)
+}
+
+func (c *FakeClient) IsFollowing(ctx context.Context, fid, target uint64) (bool, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, t := range c.follows[fid] {
+		if t == target {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *FakeClient) GetCast(ctx context.Context, fid uint64, hash string) (*Cast, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cast, ok := c.casts[hash]
+	if !ok || cast.FID != fid {
+		return nil, fmt.Errorf("cast %s: %w", hash, ErrNotFound)
+	}
+	return &cast, nil
+}
+
+func (c *FakeClient) limit(ctx context.Context, fids []uint64, limit int) ([]User, error) {
+	if limit > 0 && len(fids) > limit {
+		fids = fids[:limit]
+	}
+	return c.GetUsers(ctx, fids...)
+}
+
+// ValidateMessage returns the message registered with AddMessage, or an
+// invalid result for unknown bytes.
+func (c *FakeClient) ValidateMessage(ctx context.Context, messageBytes string) (*ValidatedMessage, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	msg, ok := c.messages[messageBytes]
+	if !ok {
+		return &ValidatedMessage{Valid: false}, nil
+	}
+	return &msg, nil
+}
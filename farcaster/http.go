@@ -0,0 +1,32 @@
+package farcaster
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/treethought/impression-frame/internal/httpretry"
+)
+
+var (
+	// ErrRateLimited is matched by errors for 429 responses. The
+	// *StatusError carries how long the server asked us to wait.
+	ErrRateLimited = httpretry.ErrRateLimited
+
+	// DefaultHTTPClient is used by clients created without one.
+	DefaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+const defaultRetries = httpretry.DefaultRetries
+
+// StatusError is returned when an API responds with a non-2xx status.
+// It matches ErrNotFound for 404s and ErrRateLimited for 429s.
+type StatusError = httpretry.StatusError
+
+// newRequester sends JSON API requests with header, retrying idempotent
+// ones that fail with network errors, 429s or 5xxs.
+func newRequester(client *http.Client, retries int, header http.Header) *httpretry.Requester {
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+	return &httpretry.Requester{Client: client, Retries: retries, Header: header}
+}
@@ -0,0 +1,301 @@
+package farcaster
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/treethought/impression-frame/internal/httpretry"
+	"golang.org/x/sync/errgroup"
+)
+
+const API_URL = "https://hub-api.neynar.com"
+
+// farcasterEpoch is when Farcaster time, in which message timestamps are
+// given, starts.
+var farcasterEpoch = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// HubClient reads Farcaster data directly from a hub's HTTP API.
+// APIKey is only needed for hosted hubs such as Neynar's.
+type HubClient struct {
+	BaseURL string
+	APIKey  string
+	// HTTPClient defaults to DefaultHTTPClient.
+	HTTPClient *http.Client
+	// Retries is how many times failed reads are retried.
+	Retries int
+}
+
+func NewHubClient(baseURL, apiKey string) *HubClient {
+	if baseURL == "" {
+		baseURL = API_URL
+	}
+	return &HubClient{BaseURL: strings.TrimSuffix(baseURL, "/"), APIKey: apiKey, Retries: defaultRetries}
+}
+
+func (c *HubClient) get(ctx context.Context, path string, out interface{}) error {
+	return c.requester().Do(ctx, httpretry.Request{
+		Method:     http.MethodGet,
+		URL:        c.BaseURL + path,
+		Idempotent: true,
+	}, out)
+}
+
+func (c *HubClient) requester() *httpretry.Requester {
+	header := http.Header{}
+	if c.APIKey != "" {
+		header.Set("api_key", c.APIKey)
+	}
+	return newRequester(c.HTTPClient, c.Retries, header)
+}
+
+// hubMessage is the JSON form of a hub protobuf message. Only the bodies
+// used by this client are decoded.
+type hubMessage struct {
+	Data struct {
+		Type         string `json:"type"`
+		FID          uint64 `json:"fid"`
+		Timestamp    uint64 `json:"timestamp"` // seconds since farcasterEpoch
+		UserDataBody *struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"userDataBody"`
+		VerificationAddAddressBody *struct {
+			Address  string `json:"address"`
+			Protocol string `json:"protocol"`
+		} `json:"verificationAddAddressBody"`
+		VerificationAddEthAddressBody *struct {
+			Address string `json:"address"`
+		} `json:"verificationAddEthAddressBody"`
+		CastAddBody *struct {
+			ParentURL string `json:"parentUrl"`
+		} `json:"castAddBody"`
+		LinkBody *struct {
+			Type      string `json:"type"`
+			TargetFID uint64 `json:"targetFid"`
+		} `json:"linkBody"`
+		FrameActionBody *struct {
+			URL         string `json:"url"`
+			ButtonIndex int    `json:"buttonIndex"`
+			InputText   string `json:"inputText"`
+			CastID      struct {
+				FID  uint64 `json:"fid"`
+				Hash string `json:"hash"`
+			} `json:"castId"`
+		} `json:"frameActionBody"`
+	} `json:"data"`
+	Hash string `json:"hash"`
+}
+
+type hubMessages struct {
+	Messages []hubMessage `json:"messages"`
+}
+
+func (c *HubClient) GetUser(ctx context.Context, fid uint64) (*User, error) {
+	var resp hubMessages
+	if err := c.get(ctx, fmt.Sprintf("/v1/userDataByFid?fid=%d", fid), &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Messages) == 0 {
+		return nil, fmt.Errorf("fid %d: %w", fid, ErrNotFound)
+	}
+
+	user := &User{FID: fid}
+	for _, m := range resp.Messages {
+		body := m.Data.UserDataBody
+		if body == nil {
+			continue
+		}
+		switch body.Type {
+		case "USER_DATA_TYPE_PFP":
+			user.PfpUrl = body.Value
+		case "USER_DATA_TYPE_DISPLAY":
+			user.DisplayName = body.Value
+		case "USER_DATA_TYPE_USERNAME":
+			user.Username = body.Value
+		case "USER_DATA_TYPE_BIO":
+			user.Profile.Bio.Text = body.Value
+		}
+	}
+
+	verifications, err := c.GetVerifications(ctx, fid)
+	if err != nil {
+		return nil, err
+	}
+	user.Verfications = verifications
+	return user, nil
+}
+
+// hubUserWorkers is how many users GetUsers fetches at once.
+const hubUserWorkers = 8
+
+// GetUsers fetches the users concurrently, as hubs have no bulk endpoint,
+// cancelling the rest once one fails.
+func (c *HubClient) GetUsers(ctx context.Context, fids ...uint64) ([]User, error) {
+	users := make([]User, len(fids))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(hubUserWorkers)
+	for i, fid := range fids {
+		i, fid := i, fid
+		g.Go(func() error {
+			user, err := c.GetUser(ctx, fid)
+			if err != nil {
+				return err
+			}
+			users[i] = *user
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// SearchUsers resolves query as an exact fname; hubs can't search.
+func (c *HubClient) SearchUsers(ctx context.Context, query string, viewer uint64) ([]User, error) {
+	var proof struct {
+		FID uint64 `json:"fid"`
+	}
+	path := fmt.Sprintf("/v1/userNameProofByName?name=%s", url.QueryEscape(strings.ToLower(query)))
+	if err := c.get(ctx, path, &proof); err != nil {
+		return nil, err
+	}
+	if proof.FID == 0 {
+		return nil, nil
+	}
+	user, err := c.GetUser(ctx, proof.FID)
+	if err != nil {
+		return nil, err
+	}
+	return []User{*user}, nil
+}
+
+func (c *HubClient) GetVerifications(ctx context.Context, fid uint64) ([]string, error) {
+	var resp hubMessages
+	if err := c.get(ctx, fmt.Sprintf("/v1/verificationsByFid?fid=%d", fid), &resp); err != nil {
+		return nil, err
+	}
+	addrs := []string{}
+	for _, m := range resp.Messages {
+		if body := m.Data.VerificationAddAddressBody; body != nil {
+			addrs = append(addrs, body.Address)
+		} else if body := m.Data.VerificationAddEthAddressBody; body != nil {
+			addrs = append(addrs, body.Address)
+		}
+	}
+	return addrs, nil
+}
+
+func (c *HubClient) GetFollowers(ctx context.Context, fid uint64, limit int) ([]User, error) {
+	var resp hubMessages
+	path := fmt.Sprintf("/v1/linksByTargetFid?target_fid=%d&link_type=follow&pageSize=%d&reverse=true", fid, limit)
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+	fids := []uint64{}
+	for _, m := range resp.Messages {
+		fids = append(fids, m.Data.FID)
+	}
+	return c.GetUsers(ctx, fids...)
+}
+
+func (c *HubClient) GetFollowing(ctx context.Context, fid uint64, limit int) ([]User, error) {
+	var resp hubMessages
+	path := fmt.Sprintf("/v1/linksByFid?fid=%d&link_type=follow&pageSize=%d&reverse=true", fid, limit)
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+	fids := []uint64{}
+	for _, m := range resp.Messages {
+		if m.Data.LinkBody != nil {
+			fids = append(fids, m.Data.LinkBody.TargetFID)
+		}
+	}
+	return c.GetUsers(ctx, fids...)
+}
+
+// IsFollowing looks up the follow link from fid to target.
+func (c *HubClient) IsFollowing(ctx context.Context, fid, target uint64) (bool, error) {
+	var link hubMessage
+	path := fmt.Sprintf("/v1/linkById?fid=%d&target_fid=%d&link_type=follow", fid, target)
+	err := c.get(ctx, path, &link)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return link.Data.LinkBody != nil, nil
+}
+
+// GetCast reads the cast from the hub, which only knows its parent URL;
+// the channel id is taken from Warpcast channel URLs.
+func (c *HubClient) GetCast(ctx context.Context, fid uint64, hash string) (*Cast, error) {
+	var msg hubMessage
+	path := fmt.Sprintf("/v1/castById?fid=%d&hash=%s", fid, url.QueryEscape(hash))
+	if err := c.get(ctx, path, &msg); err != nil {
+		return nil, err
+	}
+	cast := &Cast{FID: fid, Hash: hash}
+	if body := msg.Data.CastAddBody; body != nil {
+		cast.ParentURL = body.ParentURL
+		if strings.HasPrefix(body.ParentURL, channelURLPrefix) {
+			cast.Channel = strings.TrimPrefix(body.ParentURL, channelURLPrefix)
+		}
+	}
+	return cast, nil
+}
+
+// ValidateMessage submits the hex encoded messageBytes to the hub's
+// validateMessage endpoint.
+func (c *HubClient) ValidateMessage(ctx context.Context, messageBytes string) (*ValidatedMessage, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(messageBytes, "0x"))
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Valid   bool       `json:"valid"`
+		Message hubMessage `json:"message"`
+	}
+	// validation has no side effects, so it is safe to retry
+	err = c.requester().Do(ctx, httpretry.Request{
+		Method:      http.MethodPost,
+		URL:         c.BaseURL + "/v1/validateMessage",
+		Body:        raw,
+		ContentType: "application/octet-stream",
+		Idempotent:  true,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &ValidatedMessage{
+		Valid:       resp.Valid,
+		FID:         resp.Message.Data.FID,
+		MessageHash: resp.Message.Hash,
+		Timestamp:   farcasterEpoch.Add(time.Duration(resp.Message.Data.Timestamp) * time.Second),
+	}
+	if body := resp.Message.Data.FrameActionBody; body != nil {
+		// bytes fields are base64 encoded in the hub's JSON, while hashes
+		// are 0x prefixed hex
+		frameURL, err := base64.StdEncoding.DecodeString(body.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame url %q: %w", body.URL, err)
+		}
+		msg.URL = string(frameURL)
+		msg.ButtonIndex = body.ButtonIndex
+		msg.CastFID = body.CastID.FID
+		msg.CastHash = body.CastID.Hash
+		if text, err := base64.StdEncoding.DecodeString(body.InputText); err == nil {
+			msg.InputText = string(text)
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,119 @@
+package farcaster
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHubValidateMessage(t *testing.T) {
+	fixture, err := os.ReadFile("testdata/validate_message.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/validateMessage" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/octet-stream" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !bytes.Equal(body, []byte{0x0a, 0x42}) {
+			t.Errorf("body = %x, want the decoded message bytes", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(fixture)
+	}))
+	defer srv.Close()
+
+	msg, err := NewHubClient(srv.URL, "").ValidateMessage(context.Background(), "0x0a42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ValidatedMessage{
+		Valid:       true,
+		FID:         2,
+		URL:         "http://example.com",
+		ButtonIndex: 1,
+		CastFID:     226,
+		CastHash:    "0xa48dd46161d8e57725f5e26e34ec19c13ff7f3b9",
+		MessageHash: "0xd2b1ddc6c88e865a33cb1a565e0058d757042974",
+		Timestamp:   time.Date(2022, 7, 22, 1, 34, 26, 0, time.UTC),
+	}
+	if !msg.Timestamp.Equal(want.Timestamp) {
+		t.Errorf("Timestamp = %s, want %s", msg.Timestamp, want.Timestamp)
+	}
+	msg.Timestamp, want.Timestamp = time.Time{}, time.Time{}
+	if *msg != want {
+		t.Errorf("ValidateMessage =\n %+v\nwant\n %+v", *msg, want)
+	}
+}
+
+func TestHubValidateMessageRejectsBadHex(t *testing.T) {
+	c := NewHubClient("http://127.0.0.1:0", "")
+	if _, err := c.ValidateMessage(context.Background(), "not hex"); err == nil {
+		t.Error("validated messageBytes that aren't hex")
+	}
+}
+
+func TestHubGetUsersConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fid := r.URL.Query().Get("fid")
+		if r.URL.Path == "/v1/verificationsByFid" {
+			fmt.Fprint(w, `{"messages":[]}`)
+			return
+		}
+		if fid == "13" {
+			http.Error(w, "boom", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		fmt.Fprintf(w, `{"messages":[{"data":{"userDataBody":{"type":"USER_DATA_TYPE_USERNAME","value":"user%s"}}}]}`, fid)
+	}))
+	defer srv.Close()
+	c := NewHubClient(srv.URL, "")
+	c.Retries = 0
+
+	fids := make([]uint64, 3*hubUserWorkers)
+	for i := range fids {
+		fids[i] = uint64(100 + i)
+	}
+	users, err := c.GetUsers(context.Background(), fids...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != len(fids) {
+		t.Fatalf("got %d users, want %d", len(users), len(fids))
+	}
+	for i, u := range users {
+		if u.FID != fids[i] || u.Username != fmt.Sprintf("user%d", fids[i]) {
+			t.Errorf("users[%d] = %d %q, want fid %d in order", i, u.FID, u.Username, fids[i])
+		}
+	}
+	if maxInFlight < 2 || maxInFlight > hubUserWorkers {
+		t.Errorf("%d users fetched at once, want between 2 and %d", maxInFlight, hubUserWorkers)
+	}
+
+	if _, err := c.GetUsers(context.Background(), 12, 13, 14); err == nil {
+		t.Error("GetUsers succeeded with a user failing to load")
+	}
+}
@@ -0,0 +1,224 @@
+package farcaster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/treethought/impression-frame/internal/httpretry"
+)
+
+const NEYNAR_URL = "https://api.neynar.com"
+
+// NeynarClient reads Farcaster data through the Neynar v2 API.
+type NeynarClient struct {
+	BaseURL string
+	APIKey  string
+	// HTTPClient defaults to DefaultHTTPClient.
+	HTTPClient *http.Client
+	// Retries is how many times failed reads are retried.
+	Retries int
+}
+
+func NewNeynarClient(apiKey string) *NeynarClient {
+	return &NeynarClient{BaseURL: NEYNAR_URL, APIKey: apiKey, Retries: defaultRetries}
+}
+
+func (c *NeynarClient) get(ctx context.Context, path string, out interface{}) error {
+	return c.requester().Do(ctx, httpretry.Request{
+		Method:     http.MethodGet,
+		URL:        c.BaseURL + path,
+		Idempotent: true,
+	}, out)
+}
+
+func (c *NeynarClient) requester() *httpretry.Requester {
+	header := http.Header{}
+	header.Set("api_key", c.APIKey)
+	return newRequester(c.HTTPClient, c.Retries, header)
+}
+
+type Users struct {
+	Users []User `json:"users"`
+}
+
+func (c *NeynarClient) GetUser(ctx context.Context, fid uint64) (*User, error) {
+	users, err := c.GetUsers(ctx, fid)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("fid %d: %w", fid, ErrNotFound)
+	}
+	return &users[0], nil
+}
+
+func (c *NeynarClient) GetUsers(ctx context.Context, fids ...uint64) ([]User, error) {
+	ids := make([]string, len(fids))
+	for i, fid := range fids {
+		ids[i] = fmt.Sprint(fid)
+	}
+	var resp Users
+	path := fmt.Sprintf("/v2/farcaster/user/bulk?fids=%s", strings.Join(ids, ","))
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Users, nil
+}
+
+type SearchResult struct {
+	Result struct {
+		Users []User
+	}
+}
+
+func (c *NeynarClient) SearchUsers(ctx context.Context, query string, viewer uint64) ([]User, error) {
+	var resp SearchResult
+	path := fmt.Sprintf("/v2/farcaster/user/search?q=%s&viewer_fid=%d", url.QueryEscape(query), viewer)
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Result.Users, nil
+}
+
+func (c *NeynarClient) GetVerifications(ctx context.Context, fid uint64) ([]string, error) {
+	user, err := c.GetUser(ctx, fid)
+	if err != nil {
+		return nil, err
+	}
+	return user.Verfications, nil
+}
+
+// Follow is an entry in a followers or following list.
+type Follow struct {
+	User User `json:"user"`
+}
+
+type Follows struct {
+	Users []Follow `json:"users"`
+}
+
+// GetFollowers returns up to limit users following fid, ordered by
+// Neynar's relevance ranking so the most engaged accounts come first.
+func (c *NeynarClient) GetFollowers(ctx context.Context, fid uint64, limit int) ([]User, error) {
+	return c.getFollows(ctx, "followers", fid, limit)
+}
+
+// GetFollowing returns up to limit users that fid follows, ordered by
+// relevance.
+func (c *NeynarClient) GetFollowing(ctx context.Context, fid uint64, limit int) ([]User, error) {
+	return c.getFollows(ctx, "following", fid, limit)
+}
+
+func (c *NeynarClient) getFollows(ctx context.Context, kind string, fid uint64, limit int) ([]User, error) {
+	var resp Follows
+	path := fmt.Sprintf("/v2/farcaster/%s?fid=%d&sort_type=algorithmic&limit=%d", kind, fid, limit)
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+	users := make([]User, 0, len(resp.Users))
+	for _, f := range resp.Users {
+		users = append(users, f.User)
+	}
+	return users, nil
+}
+
+// IsFollowing asks for target as seen by fid.
+func (c *NeynarClient) IsFollowing(ctx context.Context, fid, target uint64) (bool, error) {
+	var resp struct {
+		Users []struct {
+			ViewerContext struct {
+				Following bool `json:"following"`
+			} `json:"viewer_context"`
+		} `json:"users"`
+	}
+	path := fmt.Sprintf("/v2/farcaster/user/bulk?fids=%d&viewer_fid=%d", target, fid)
+	if err := c.get(ctx, path, &resp); err != nil {
+		return false, err
+	}
+	if len(resp.Users) == 0 {
+		return false, fmt.Errorf("fid %d: %w", target, ErrNotFound)
+	}
+	return resp.Users[0].ViewerContext.Following, nil
+}
+
+func (c *NeynarClient) GetCast(ctx context.Context, fid uint64, hash string) (*Cast, error) {
+	var resp struct {
+		Cast struct {
+			Hash   string `json:"hash"`
+			Author struct {
+				FID uint64 `json:"fid"`
+			} `json:"author"`
+			ParentURL string `json:"parent_url"`
+			Channel   *struct {
+				ID string `json:"id"`
+			} `json:"channel"`
+		} `json:"cast"`
+	}
+	path := fmt.Sprintf("/v2/farcaster/cast?identifier=%s&type=hash", url.QueryEscape(hash))
+	if err := c.get(ctx, path, &resp); err != nil {
+		return nil, err
+	}
+	cast := &Cast{FID: resp.Cast.Author.FID, Hash: resp.Cast.Hash, ParentURL: resp.Cast.ParentURL}
+	if resp.Cast.Channel != nil {
+		cast.Channel = resp.Cast.Channel.ID
+	}
+	return cast, nil
+}
+
+type neynarValidation struct {
+	Valid  bool `json:"valid"`
+	Action struct {
+		URL        string `json:"url"`
+		Interactor struct {
+			FID uint64 `json:"fid"`
+		} `json:"interactor"`
+		TappedButton struct {
+			Index int `json:"index"`
+		} `json:"tapped_button"`
+		Input struct {
+			Text string `json:"text"`
+		} `json:"input"`
+		Cast struct {
+			FID  uint64 `json:"fid"`
+			Hash string `json:"hash"`
+		} `json:"cast"`
+		MessageHash string    `json:"message_hash"`
+		Timestamp   time.Time `json:"timestamp"`
+	} `json:"action"`
+}
+
+// ValidateMessage verifies the hex encoded messageBytes of a frame action.
+func (c *NeynarClient) ValidateMessage(ctx context.Context, messageBytes string) (*ValidatedMessage, error) {
+	body, err := json.Marshal(map[string]string{"message_bytes_in_hex": messageBytes})
+	if err != nil {
+		return nil, err
+	}
+	// validation has no side effects, so it is safe to retry
+	var resp neynarValidation
+	err = c.requester().Do(ctx, httpretry.Request{
+		Method:      http.MethodPost,
+		URL:         c.BaseURL + "/v2/farcaster/frame/validate",
+		Body:        body,
+		ContentType: "application/json",
+		Idempotent:  true,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &ValidatedMessage{
+		Valid:       resp.Valid,
+		FID:         resp.Action.Interactor.FID,
+		URL:         resp.Action.URL,
+		ButtonIndex: resp.Action.TappedButton.Index,
+		InputText:   resp.Action.Input.Text,
+		CastFID:     resp.Action.Cast.FID,
+		CastHash:    resp.Action.Cast.Hash,
+		MessageHash: resp.Action.MessageHash,
+		Timestamp:   resp.Action.Timestamp,
+	}, nil
+}
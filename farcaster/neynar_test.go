@@ -0,0 +1,161 @@
+package farcaster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// neynarStandIn serves /v2/farcaster/user/bulk with handler, counting
+// the requests it gets.
+func neynarStandIn(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, hit int)) (*NeynarClient, *int32) {
+	t.Helper()
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/farcaster/user/bulk" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("api_key") != "key" {
+			t.Errorf("api_key header = %q", r.Header.Get("api_key"))
+		}
+		handler(w, r, int(atomic.AddInt32(&hits, 1)))
+	}))
+	t.Cleanup(srv.Close)
+	c := NewNeynarClient("key")
+	c.BaseURL = srv.URL
+	return c, &hits
+}
+
+func writeUser(w http.ResponseWriter, fid uint64) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"users":[{"fid":%d,"username":"user%d"}]}`, fid, fid)
+}
+
+func TestNeynarRetriesServerErrors(t *testing.T) {
+	c, hits := neynarStandIn(t, func(w http.ResponseWriter, r *http.Request, hit int) {
+		if hit < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		writeUser(w, 3)
+	})
+	user, err := c.GetUser(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "user3" {
+		t.Errorf("username = %q", user.Username)
+	}
+	if *hits != 3 {
+		t.Errorf("got %d requests, want 3", *hits)
+	}
+}
+
+func TestNeynarGivesUpAfterRetries(t *testing.T) {
+	c, hits := neynarStandIn(t, func(w http.ResponseWriter, r *http.Request, hit int) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	})
+	c.Retries = 2
+	_, err := c.GetUser(context.Background(), 3)
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("err = %v, want a 500 StatusError", err)
+	}
+	if *hits != 3 {
+		t.Errorf("got %d requests, want 3", *hits)
+	}
+}
+
+func TestNeynarNotFound(t *testing.T) {
+	c, hits := neynarStandIn(t, func(w http.ResponseWriter, r *http.Request, hit int) {
+		http.Error(w, "no such user", http.StatusNotFound)
+	})
+	_, err := c.GetUser(context.Background(), 3)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if errors.Is(err, ErrRateLimited) {
+		t.Error("404 matched ErrRateLimited")
+	}
+	if *hits != 1 {
+		t.Errorf("got %d requests, want no retries", *hits)
+	}
+}
+
+func TestNeynarRetryAfter(t *testing.T) {
+	c, hits := neynarStandIn(t, func(w http.ResponseWriter, r *http.Request, hit int) {
+		if hit == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		writeUser(w, 3)
+	})
+	start := time.Now()
+	if _, err := c.GetUser(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("retried after %s, want at least the 1s asked for", waited)
+	}
+	if *hits != 2 {
+		t.Errorf("got %d requests, want 2", *hits)
+	}
+}
+
+func TestNeynarRateLimitedTooLong(t *testing.T) {
+	c, hits := neynarStandIn(t, func(w http.ResponseWriter, r *http.Request, hit int) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	})
+	_, err := c.GetUser(context.Background(), 3)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	var se *StatusError
+	if !errors.As(err, &se) || se.RetryAfter != time.Minute {
+		t.Errorf("RetryAfter = %v, want 1m", se.RetryAfter)
+	}
+	if *hits != 1 {
+		t.Errorf("got %d requests, want no retry past the max delay", *hits)
+	}
+}
+
+func TestNeynarRetriesNetworkErrors(t *testing.T) {
+	c, hits := neynarStandIn(t, func(w http.ResponseWriter, r *http.Request, hit int) {
+		if hit == 1 {
+			// drop the connection without a response
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			return
+		}
+		writeUser(w, 3)
+	})
+	if _, err := c.GetUser(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	if *hits != 2 {
+		t.Errorf("got %d requests, want 2", *hits)
+	}
+}
+
+func TestNeynarUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	c := NewNeynarClient("key")
+	c.BaseURL = srv.URL
+	c.Retries = 1
+	_, err := c.GetUser(context.Background(), 3)
+	var se *StatusError
+	if err == nil || errors.As(err, &se) {
+		t.Fatalf("err = %v, want a network error", err)
+	}
+}
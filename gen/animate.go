@@ -0,0 +1,68 @@
+package gen
+
+import (
+	"image"
+	"sync"
+
+	"github.com/treethought/impression-frame/util"
+)
+
+// Op transforms a single frame. seed should drive any randomness so that
+// frames can be chopped consistently.
+type Op func(frame image.Image, index int, seed int64) image.Image
+
+// SeedMode controls how the seed passed to an Op changes between frames.
+type SeedMode int
+
+const (
+	// SeedConsistent gives every frame the same seed, so the chop follows
+	// the animation.
+	SeedConsistent SeedMode = iota
+	// SeedVarying gives each frame its own seed, so the chop flickers.
+	SeedVarying
+)
+
+// Animate applies op to img. Animated images have op applied to every
+// frame in parallel and keep their frame delays and loop count.
+func Animate(img image.Image, seed int64, mode SeedMode, op Op) image.Image {
+	a, ok := img.(*util.Animated)
+	if !ok {
+		return op(img, 0, seed)
+	}
+
+	out := &util.Animated{
+		Frames:    make([]image.Image, len(a.Frames)),
+		Delay:     append([]int(nil), a.Delay...),
+		LoopCount: a.LoopCount,
+	}
+	var wg sync.WaitGroup
+	for i, frame := range a.Frames {
+		frameSeed := seed
+		if mode == SeedVarying {
+			frameSeed += int64(i)
+		}
+		wg.Add(1)
+		go func(i int, frame image.Image, seed int64) {
+			defer wg.Done()
+			out.Frames[i] = op(frame, i, seed)
+		}(i, frame, frameSeed)
+	}
+	wg.Wait()
+	return out
+}
+
+// FrameAt returns frame i of img, looping shorter animations. Still images
+// are returned as is.
+func FrameAt(img image.Image, i int) image.Image {
+	a, ok := img.(*util.Animated)
+	if !ok {
+		return img
+	}
+	return a.Frames[i%len(a.Frames)]
+}
+
+// IsAnimated reports whether img has more than one frame.
+func IsAnimated(img image.Image) bool {
+	_, ok := img.(*util.Animated)
+	return ok
+}
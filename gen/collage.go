@@ -0,0 +1,211 @@
+package gen
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	draw2 "golang.org/x/image/draw"
+)
+
+// Layout selects how a collage arranges its tiles.
+type Layout int
+
+const (
+	LayoutGrid Layout = iota
+	LayoutSpiral
+	LayoutCircles
+	LayoutWeighted
+)
+
+func (l Layout) String() string {
+	switch l {
+	case LayoutGrid:
+		return "grid"
+	case LayoutSpiral:
+		return "spiral"
+	case LayoutCircles:
+		return "circles"
+	case LayoutWeighted:
+		return "weighted"
+	}
+	return "unknown"
+}
+
+// ParseLayout returns the layout named by s, defaulting to a grid.
+func ParseLayout(s string) Layout {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "spiral":
+		return LayoutSpiral
+	case "circle", "circles":
+		return LayoutCircles
+	case "weighted", "weight":
+		return LayoutWeighted
+	}
+	return LayoutGrid
+}
+
+// Tile is an image placed in a collage. Weight is relative to the other
+// tiles and only affects the weighted layout.
+type Tile struct {
+	Image  image.Image
+	Weight float64
+}
+
+// Collage arranges tiles around center on a size x size canvas.
+// The center image is always drawn last, in the middle.
+func Collage(center image.Image, tiles []Tile, layout Layout, size int) image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.RGBA{20, 20, 20, 255}), image.Point{}, draw.Src)
+
+	switch layout {
+	case LayoutSpiral:
+		collageSpiral(canvas, tiles)
+	case LayoutCircles:
+		collagePacked(canvas, tiles, false)
+	case LayoutWeighted:
+		collagePacked(canvas, tiles, true)
+	default:
+		collageGrid(canvas, tiles)
+	}
+
+	if center != nil {
+		c := size / 2
+		r := size / 6
+		if layout == LayoutGrid || layout == LayoutSpiral {
+			drawTile(canvas, center, image.Rect(c-r, c-r, c+r, c+r))
+		} else {
+			drawCircle(canvas, center, image.Pt(c, c), r)
+		}
+	}
+	return canvas
+}
+
+func drawTile(dst draw.Image, img image.Image, r image.Rectangle) {
+	draw2.ApproxBiLinear.Scale(dst, r, img, img.Bounds(), draw2.Src, nil)
+}
+
+// drawCircle draws img scaled into a circle of radius r around p.
+func drawCircle(dst draw.Image, img image.Image, p image.Point, r int) {
+	rect := image.Rect(p.X-r, p.Y-r, p.X+r, p.Y+r)
+	scaled := image.NewRGBA(rect)
+	draw2.ApproxBiLinear.Scale(scaled, rect, img, img.Bounds(), draw2.Src, nil)
+	draw.DrawMask(dst, rect, scaled, rect.Min, &circle{p, r}, rect.Min, draw.Over)
+}
+
+// circle is an alpha mask for a disc.
+type circle struct {
+	p image.Point
+	r int
+}
+
+func (c *circle) ColorModel() color.Model {
+	return color.AlphaModel
+}
+
+func (c *circle) Bounds() image.Rectangle {
+	return image.Rect(c.p.X-c.r, c.p.Y-c.r, c.p.X+c.r, c.p.Y+c.r)
+}
+
+func (c *circle) At(x, y int) color.Color {
+	xx, yy, rr := float64(x-c.p.X)+0.5, float64(y-c.p.Y)+0.5, float64(c.r)
+	if xx*xx+yy*yy < rr*rr {
+		return color.Alpha{255}
+	}
+	return color.Alpha{0}
+}
+
+// collageGrid fills the canvas with equally sized square cells.
+func collageGrid(canvas *image.RGBA, tiles []Tile) {
+	if len(tiles) == 0 {
+		return
+	}
+	size := canvas.Bounds().Dx()
+	cols := int(math.Ceil(math.Sqrt(float64(len(tiles)))))
+	cell := size / cols
+	offset := (size - cell*cols) / 2
+	for i, t := range tiles {
+		x := offset + (i%cols)*cell
+		y := offset + (i/cols)*cell
+		drawTile(canvas, t.Image, image.Rect(x, y, x+cell, y+cell))
+	}
+}
+
+// goldenAngle spaces points on a spiral so that they never line up.
+var goldenAngle = math.Pi * (3 - math.Sqrt(5))
+
+// collageSpiral places tiles along a phyllotaxis spiral, starting at the
+// center and shrinking as they move outwards.
+func collageSpiral(canvas *image.RGBA, tiles []Tile) {
+	if len(tiles) == 0 {
+		return
+	}
+	size := float64(canvas.Bounds().Dx())
+	c := size / 2
+	inner := size / 6
+	spacing := (c - inner) / math.Sqrt(float64(len(tiles)))
+
+	// draw the outermost first so inner tiles overlap them
+	for i := len(tiles) - 1; i >= 0; i-- {
+		dist := inner + spacing*math.Sqrt(float64(i)+0.5)
+		angle := float64(i) * goldenAngle
+		x := c + dist*math.Cos(angle)
+		y := c + dist*math.Sin(angle)
+		half := spacing * (1.2 - 0.5*float64(i)/float64(len(tiles)))
+		r := image.Rect(int(x-half), int(y-half), int(x+half), int(y+half))
+		drawTile(canvas, tiles[i].Image, r)
+	}
+}
+
+// collagePacked packs tiles as circles, greedily placing each one at the
+// first free spot along a spiral out from the center. When weighted, the
+// radius of each circle grows with its weight.
+func collagePacked(canvas *image.RGBA, tiles []Tile, weighted bool) {
+	if len(tiles) == 0 {
+		return
+	}
+	size := float64(canvas.Bounds().Dx())
+	c := size / 2
+	base := size / 2 / math.Sqrt(float64(len(tiles))+9) * 0.8
+
+	maxW := 0.0
+	for _, t := range tiles {
+		maxW = math.Max(maxW, t.Weight)
+	}
+
+	type disc struct{ x, y, r float64 }
+	// reserve the middle for the center image
+	placed := []disc{{c, c, size / 6}}
+
+	for _, t := range tiles {
+		r := base
+		if weighted && maxW > 0 {
+			r = base * (0.4 + 1.1*math.Sqrt(t.Weight/maxW))
+		}
+		for a := 0.0; a < 400; a += 0.05 {
+			dist := size / 6 * a / (2 * math.Pi)
+			x := c + dist*math.Cos(a)
+			y := c + dist*math.Sin(a)
+			if x-r < 0 || y-r < 0 || x+r > size || y+r > size {
+				if dist > c*math.Sqrt2 {
+					break
+				}
+				continue
+			}
+			free := true
+			for _, d := range placed {
+				if math.Hypot(x-d.x, y-d.y) < r+d.r+1 {
+					free = false
+					break
+				}
+			}
+			if free {
+				placed = append(placed, disc{x, y, r})
+				drawCircle(canvas, t.Image, image.Pt(int(x), int(y)), int(r))
+				break
+			}
+		}
+	}
+}
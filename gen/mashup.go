@@ -0,0 +1,73 @@
+package gen
+
+import (
+	"image"
+
+	"github.com/phrozen/blend"
+	draw2 "golang.org/x/image/draw"
+
+	"github.com/treethought/impression-frame/util"
+)
+
+// MashFunc combines two images into one. The result takes the bounds of a.
+type MashFunc func(a, b image.Image) (image.Image, error)
+
+// fitTo scales img to fill bounds so two PFPs of different sizes
+// can be combined pixel for pixel.
+func fitTo(img image.Image, bounds image.Rectangle) image.Image {
+	if img.Bounds() == bounds {
+		return img
+	}
+	scaled := image.NewRGBA(bounds)
+	draw2.CatmullRom.Scale(scaled, bounds, img, img.Bounds(), draw2.Src, nil)
+	return scaled
+}
+
+// MashRecombine nests each image inside the other at shrinking scales.
+func MashRecombine(a, b image.Image) (image.Image, error) {
+	b = fitTo(b, a.Bounds())
+	result := WriteWithin(a, b, 80)
+	img := CombineImages(a, result)
+	result = WriteWithin(img, a, 60)
+	img = CombineImages(img, result)
+	result = WriteWithin(b, img, 40)
+	img = CombineImages(img, result)
+	return WriteWithin(img, b, 20), nil
+}
+
+// MashBlend layers b over a using a screen blend.
+func MashBlend(a, b image.Image) (image.Image, error) {
+	b = fitTo(b, a.Bounds())
+	return blend.BlendNewImage(a, b, blend.Screen), nil
+}
+
+// MashInterleave alternates diagonal bands of each image.
+func MashInterleave(a, b image.Image) (image.Image, error) {
+	b = fitTo(b, a.Bounds())
+	bounds := a.Bounds()
+	band := bounds.Dx() / 16
+	if band < 1 {
+		band = 1
+	}
+
+	finImage := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if ((x+y)/band)%2 == 0 {
+				finImage.Set(x, y, a.At(x, y))
+			} else {
+				finImage.Set(x, y, b.At(x, y))
+			}
+		}
+	}
+	return finImage, nil
+}
+
+// MashPaletteSwap repaints a using the dominant colors of b.
+func MashPaletteSwap(a, b image.Image) (image.Image, error) {
+	palette, err := util.GetPalette(b)
+	if err != nil {
+		return nil, err
+	}
+	return applyPallate(a, palette)
+}
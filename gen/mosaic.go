@@ -0,0 +1,193 @@
+package gen
+
+import (
+	"image"
+	"image/draw"
+	"math/rand"
+	"sort"
+
+	draw2 "golang.org/x/image/draw"
+)
+
+// TileIndex is a nearest-neighbour index of mosaic tiles by average color.
+type TileIndex struct {
+	size  int
+	tiles []mosaicTile
+	root  *kdNode
+}
+
+type mosaicTile struct {
+	img image.Image
+	avg [3]float64
+}
+
+// kdNode is a node of a 3-d tree over RGB space.
+type kdNode struct {
+	tile        int
+	axis        int
+	left, right *kdNode
+}
+
+// NewTileIndex scales each image to a tileSize square and indexes it by
+// its average color.
+func NewTileIndex(imgs []image.Image, tileSize int) *TileIndex {
+	b := NewTileIndexBuilder(tileSize)
+	for _, img := range imgs {
+		b.Add(img)
+	}
+	return b.Build()
+}
+
+// TileIndexBuilder builds a TileIndex from images added one at a time,
+// keeping only their tiles, so large sets can be indexed without holding
+// every image.
+type TileIndexBuilder struct {
+	idx *TileIndex
+}
+
+func NewTileIndexBuilder(tileSize int) *TileIndexBuilder {
+	return &TileIndexBuilder{idx: &TileIndex{size: tileSize}}
+}
+
+// Add scales img to a tile.
+func (b *TileIndexBuilder) Add(img image.Image) {
+	size := b.idx.size
+	scaled := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw2.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw2.Src, nil)
+	b.idx.tiles = append(b.idx.tiles, mosaicTile{
+		img: scaled,
+		avg: averageColor(scaled, scaled.Bounds()),
+	})
+}
+
+// Build indexes the tiles added so far. The builder must not be used
+// afterwards.
+func (b *TileIndexBuilder) Build() *TileIndex {
+	idx := b.idx
+	order := make([]int, len(idx.tiles))
+	for i := range order {
+		order[i] = i
+	}
+	idx.root = idx.build(order, 0)
+	return idx
+}
+
+// Len returns the number of tiles in the index.
+func (idx *TileIndex) Len() int {
+	return len(idx.tiles)
+}
+
+func (idx *TileIndex) build(order []int, depth int) *kdNode {
+	if len(order) == 0 {
+		return nil
+	}
+	axis := depth % 3
+	sort.Slice(order, func(i, j int) bool {
+		return idx.tiles[order[i]].avg[axis] < idx.tiles[order[j]].avg[axis]
+	})
+	mid := len(order) / 2
+	return &kdNode{
+		tile:  order[mid],
+		axis:  axis,
+		left:  idx.build(order[:mid], depth+1),
+		right: idx.build(order[mid+1:], depth+1),
+	}
+}
+
+// nearest returns the tile closest to c for which ok returns true,
+// or -1 when no tile is allowed.
+func (idx *TileIndex) nearest(c [3]float64, ok func(int) bool) int {
+	best, bestDist := -1, 0.0
+	var search func(n *kdNode)
+	search = func(n *kdNode) {
+		if n == nil {
+			return
+		}
+		avg := idx.tiles[n.tile].avg
+		if ok(n.tile) {
+			if d := colorDist(c, avg); best < 0 || d < bestDist {
+				best, bestDist = n.tile, d
+			}
+		}
+		diff := c[n.axis] - avg[n.axis]
+		near, far := n.left, n.right
+		if diff > 0 {
+			near, far = n.right, n.left
+		}
+		search(near)
+		if best < 0 || diff*diff < bestDist {
+			search(far)
+		}
+	}
+	search(idx.root)
+	return best
+}
+
+func colorDist(a, b [3]float64) float64 {
+	dr, dg, db := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dr*dr + dg*dg + db*db
+}
+
+func averageColor(img image.Image, r image.Rectangle) [3]float64 {
+	var sum [3]float64
+	n := 0.0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			sum[0] += float64(cr >> 8)
+			sum[1] += float64(cg >> 8)
+			sum[2] += float64(cb >> 8)
+			n++
+		}
+	}
+	if n == 0 {
+		return sum
+	}
+	return [3]float64{sum[0] / n, sum[1] / n, sum[2] / n}
+}
+
+// Photomosaic rebuilds img as a cells x cells grid of tiles from idx, each
+// chosen by closest average color. A tile is used at most maxReuse times
+// (0 for no limit); once every tile is used up the limit is lifted.
+// Cells are filled in an order drawn from seed so the limit doesn't favour
+// the top rows.
+func Photomosaic(img image.Image, idx *TileIndex, cells int, maxReuse int, seed int64) image.Image {
+	size := cells * idx.size
+	finImage := image.NewRGBA(image.Rect(0, 0, size, size))
+	if idx.Len() == 0 {
+		draw2.ApproxBiLinear.Scale(finImage, finImage.Bounds(), img, img.Bounds(), draw2.Src, nil)
+		return finImage
+	}
+
+	bounds := img.Bounds()
+	cellW := float64(bounds.Dx()) / float64(cells)
+	cellH := float64(bounds.Dy()) / float64(cells)
+
+	uses := make([]int, idx.Len())
+	available := func(t int) bool {
+		return maxReuse <= 0 || uses[t] < maxReuse
+	}
+	anyTile := func(int) bool { return true }
+
+	r := rand.New(rand.NewSource(seed))
+	for _, cell := range r.Perm(cells * cells) {
+		cx, cy := cell%cells, cell/cells
+		src := image.Rect(
+			bounds.Min.X+int(float64(cx)*cellW),
+			bounds.Min.Y+int(float64(cy)*cellH),
+			bounds.Min.X+int(float64(cx+1)*cellW),
+			bounds.Min.Y+int(float64(cy+1)*cellH),
+		)
+		avg := averageColor(img, src)
+
+		t := idx.nearest(avg, available)
+		if t < 0 {
+			t = idx.nearest(avg, anyTile)
+		}
+		uses[t]++
+
+		dst := image.Rect(cx*idx.size, cy*idx.size, (cx+1)*idx.size, (cy+1)*idx.size)
+		draw.Draw(finImage, dst, idx.tiles[t].img, image.Point{}, draw.Src)
+	}
+	return finImage
+}
@@ -0,0 +1,140 @@
+package gen
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// randomIndex indexes n tiles of random average colors, some repeated.
+func randomIndex(r *rand.Rand, n int) *TileIndex {
+	idx := &TileIndex{size: 1}
+	for i := 0; i < n; i++ {
+		var avg [3]float64
+		if i > 0 && r.Intn(10) == 0 {
+			avg = idx.tiles[r.Intn(i)].avg
+		} else {
+			avg = [3]float64{r.Float64() * 255, r.Float64() * 255, r.Float64() * 255}
+		}
+		idx.tiles = append(idx.tiles, mosaicTile{avg: avg})
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	idx.root = idx.build(order, 0)
+	return idx
+}
+
+func TestTileIndexNearestMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 3, 10, 100, 500} {
+		idx := randomIndex(r, n)
+		for q := 0; q < 200; q++ {
+			c := [3]float64{r.Float64() * 255, r.Float64() * 255, r.Float64() * 255}
+			// exclude a random set of tiles, sometimes all of them
+			excluded := make([]bool, n)
+			density := r.Float64()
+			for i := range excluded {
+				excluded[i] = r.Float64() < density
+			}
+			ok := func(i int) bool { return !excluded[i] }
+
+			want, wantDist := -1, 0.0
+			for i, tile := range idx.tiles {
+				if d := colorDist(c, tile.avg); ok(i) && (want < 0 || d < wantDist) {
+					want, wantDist = i, d
+				}
+			}
+			got := idx.nearest(c, ok)
+			switch {
+			case want < 0 && got >= 0:
+				t.Fatalf("%d tiles: nearest(%v) = %d, want none allowed", n, c, got)
+			case want < 0:
+			case got < 0 || !ok(got):
+				t.Fatalf("%d tiles: nearest(%v) = %d, want allowed tile %d", n, c, got, want)
+			case colorDist(c, idx.tiles[got].avg) != wantDist:
+				t.Fatalf("%d tiles: nearest(%v) = %d at %v, want %d at %v", n, c, got, colorDist(c, idx.tiles[got].avg), want, wantDist)
+			}
+		}
+	}
+}
+
+// solidTiles returns n tiles of distinct solid colors.
+func solidTiles(n int) []image.Image {
+	imgs := make([]image.Image, n)
+	for i := range imgs {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{uint8(100 + 10*i), 0, 0, 255}}, image.Point{}, draw.Src)
+		imgs[i] = img
+	}
+	return imgs
+}
+
+// tileUses counts the cells of a mosaic made from solidTiles by the red
+// of their top left pixel.
+func tileUses(t *testing.T, mosaic image.Image, cells, tileSize, tiles int) []int {
+	t.Helper()
+	uses := make([]int, tiles)
+	for cy := 0; cy < cells; cy++ {
+		for cx := 0; cx < cells; cx++ {
+			r, _, _, _ := mosaic.At(cx*tileSize, cy*tileSize).RGBA()
+			i := (int(r>>8) - 100) / 10
+			if i < 0 || i >= tiles {
+				t.Fatalf("cell %d,%d isn't a tile", cx, cy)
+			}
+			uses[i]++
+		}
+	}
+	return uses
+}
+
+func TestPhotomosaicLimitsReuse(t *testing.T) {
+	// every cell is closest to the first tile
+	src := image.NewRGBA(image.Rect(0, 0, 30, 30))
+	draw.Draw(src, src.Bounds(), &image.Uniform{color.RGBA{90, 0, 0, 255}}, image.Point{}, draw.Src)
+	const tileSize = 4
+
+	for _, tc := range []struct {
+		cells, tiles, maxReuse int
+	}{
+		{cells: 2, tiles: 3, maxReuse: 2},
+		{cells: 3, tiles: 5, maxReuse: 2},
+		{cells: 4, tiles: 16, maxReuse: 1},
+		{cells: 5, tiles: 9, maxReuse: 3},
+	} {
+		idx := NewTileIndex(solidTiles(tc.tiles), tileSize)
+		for seed := int64(0); seed < 5; seed++ {
+			uses := tileUses(t, Photomosaic(src, idx, tc.cells, tc.maxReuse, seed), tc.cells, tileSize, tc.tiles)
+			for i, n := range uses {
+				if n > tc.maxReuse {
+					t.Errorf("%+v seed %d: tile %d used %d times, over the limit of %d", tc, seed, i, n, tc.maxReuse)
+				}
+			}
+			// the closest tiles are used up first
+			if uses[0] != tc.maxReuse {
+				t.Errorf("%+v seed %d: closest tile used %d times, want %d", tc, seed, uses[0], tc.maxReuse)
+			}
+		}
+	}
+}
+
+func TestPhotomosaicLiftsLimitOnceUsedUp(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 30, 30))
+	draw.Draw(src, src.Bounds(), &image.Uniform{color.RGBA{90, 0, 0, 255}}, image.Point{}, draw.Src)
+	const cells, tiles, tileSize = 3, 2, 4
+
+	// 9 cells but only 2 tiles used at most twice each
+	uses := tileUses(t, Photomosaic(src, NewTileIndex(solidTiles(tiles), tileSize), cells, 2, 1), cells, tileSize, tiles)
+	if uses[0]+uses[1] != cells*cells || uses[1] != 2 {
+		t.Errorf("uses = %v, want the closest tile to fill every cell past the limit", uses)
+	}
+
+	// without a limit the closest tile fills every cell
+	uses = tileUses(t, Photomosaic(src, NewTileIndex(solidTiles(tiles), tileSize), cells, 0, 1), cells, tileSize, tiles)
+	if uses[0] != cells*cells {
+		t.Errorf("uses = %v, want the closest tile everywhere", uses)
+	}
+}
@@ -0,0 +1,258 @@
+package gen
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Direction is the direction a tile slides into the empty slot.
+type Direction int
+
+const (
+	Up Direction = iota
+	Down
+	Left
+	Right
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Up:
+		return "U"
+	case Down:
+		return "D"
+	case Left:
+		return "L"
+	case Right:
+		return "R"
+	}
+	return "?"
+}
+
+// ParseMoves parses a move sequence such as "UULDR" or "up left".
+// Whitespace and commas are ignored, arrows are accepted.
+func ParseMoves(s string) ([]Direction, error) {
+	moves := []Direction{}
+	for _, word := range strings.FieldsFunc(strings.ToUpper(s), func(r rune) bool {
+		return r == ' ' || r == ','
+	}) {
+		switch word {
+		case "UP":
+			moves = append(moves, Up)
+			continue
+		case "DOWN":
+			moves = append(moves, Down)
+			continue
+		case "LEFT":
+			moves = append(moves, Left)
+			continue
+		case "RIGHT":
+			moves = append(moves, Right)
+			continue
+		}
+		for _, r := range word {
+			switch r {
+			case 'U', '↑':
+				moves = append(moves, Up)
+			case 'D', '↓':
+				moves = append(moves, Down)
+			case 'L', '←':
+				moves = append(moves, Left)
+			case 'R', '→':
+				moves = append(moves, Right)
+			default:
+				return nil, fmt.Errorf("invalid move %q", r)
+			}
+		}
+	}
+	return moves, nil
+}
+
+// Puzzle is the state of a sliding tile puzzle.
+// Tiles holds, for each board position, the index of the source tile
+// shown there. The tile with the highest index is the empty slot.
+type Puzzle struct {
+	Size  int
+	Tiles []int
+	Moves int
+}
+
+// NewPuzzle returns a scrambled size x size puzzle. The board is shuffled
+// by playing random legal moves from the solved state so it is always
+// solvable.
+func NewPuzzle(size int) (*Puzzle, error) {
+	if size < 2 || size > 8 {
+		return nil, fmt.Errorf("unsupported puzzle size: %d", size)
+	}
+	p := &Puzzle{Size: size, Tiles: make([]int, size*size)}
+	for i := range p.Tiles {
+		p.Tiles[i] = i
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	last := Direction(-1)
+	for i := 0; i < size*size*20 || p.Solved(); i++ {
+		d := Direction(r.Intn(4))
+		if d == opposite(last) {
+			continue
+		}
+		if p.move(d) {
+			last = d
+		}
+	}
+	return p, nil
+}
+
+func opposite(d Direction) Direction {
+	switch d {
+	case Up:
+		return Down
+	case Down:
+		return Up
+	case Left:
+		return Right
+	case Right:
+		return Left
+	}
+	return -1
+}
+
+func (p *Puzzle) empty() int {
+	blank := len(p.Tiles) - 1
+	for pos, t := range p.Tiles {
+		if t == blank {
+			return pos
+		}
+	}
+	return -1
+}
+
+// move slides the neighbouring tile in direction d into the empty slot.
+// For Up, the tile below the empty slot moves up.
+func (p *Puzzle) move(d Direction) bool {
+	e := p.empty()
+	x, y := e%p.Size, e/p.Size
+	switch d {
+	case Up:
+		y++
+	case Down:
+		y--
+	case Left:
+		x++
+	case Right:
+		x--
+	default:
+		return false
+	}
+	if x < 0 || y < 0 || x >= p.Size || y >= p.Size {
+		return false
+	}
+	n := y*p.Size + x
+	p.Tiles[e], p.Tiles[n] = p.Tiles[n], p.Tiles[e]
+	return true
+}
+
+// Move applies the moves in order, skipping any that would push a tile
+// off the board. It returns the number of moves that were applied.
+func (p *Puzzle) Move(moves ...Direction) int {
+	applied := 0
+	for _, d := range moves {
+		if p.Solved() {
+			break
+		}
+		if p.move(d) {
+			p.Moves++
+			applied++
+		}
+	}
+	return applied
+}
+
+func (p *Puzzle) Solved() bool {
+	for pos, t := range p.Tiles {
+		if pos != t {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode serializes the puzzle so it can be carried between frame posts,
+// e.g. "3-12-8.1.2.0.4.5.3.6.7" for size, move count and tiles.
+func (p *Puzzle) Encode() string {
+	tiles := make([]string, len(p.Tiles))
+	for i, t := range p.Tiles {
+		tiles[i] = strconv.Itoa(t)
+	}
+	return fmt.Sprintf("%d-%d-%s", p.Size, p.Moves, strings.Join(tiles, "."))
+}
+
+// DecodePuzzle parses a state produced by Encode and checks that it
+// describes a valid board.
+func DecodePuzzle(s string) (*Puzzle, error) {
+	parts := strings.SplitN(s, "-", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid puzzle state: %q", s)
+	}
+	size, err := strconv.Atoi(parts[0])
+	if err != nil || size < 2 || size > 8 {
+		return nil, fmt.Errorf("invalid puzzle size: %q", parts[0])
+	}
+	moves, err := strconv.Atoi(parts[1])
+	if err != nil || moves < 0 {
+		return nil, fmt.Errorf("invalid puzzle moves: %q", parts[1])
+	}
+	fields := strings.Split(parts[2], ".")
+	if len(fields) != size*size {
+		return nil, fmt.Errorf("invalid puzzle tiles: %q", parts[2])
+	}
+	seen := make([]bool, size*size)
+	tiles := make([]int, size*size)
+	for i, f := range fields {
+		t, err := strconv.Atoi(f)
+		if err != nil || t < 0 || t >= len(seen) || seen[t] {
+			return nil, fmt.Errorf("invalid puzzle tiles: %q", parts[2])
+		}
+		seen[t] = true
+		tiles[i] = t
+	}
+	return &Puzzle{Size: size, Tiles: tiles, Moves: moves}, nil
+}
+
+// Render draws the board using tiles cut from img. The empty slot is
+// left dark and tiles are separated by a thin gap.
+func (p *Puzzle) Render(img image.Image) image.Image {
+	bounds := img.Bounds()
+	finImage := image.NewRGBA(bounds)
+	draw.Draw(finImage, bounds, image.NewUniform(color.RGBA{20, 20, 20, 255}), image.Point{}, draw.Src)
+
+	tileW := bounds.Dx() / p.Size
+	tileH := bounds.Dy() / p.Size
+	gap := tileW / 50
+	if gap < 1 {
+		gap = 1
+	}
+
+	blank := len(p.Tiles) - 1
+	for pos, t := range p.Tiles {
+		if t == blank && !p.Solved() {
+			continue
+		}
+		src := image.Pt(bounds.Min.X+(t%p.Size)*tileW, bounds.Min.Y+(t/p.Size)*tileH)
+		dst := image.Rect(
+			bounds.Min.X+(pos%p.Size)*tileW+gap,
+			bounds.Min.Y+(pos/p.Size)*tileH+gap,
+			bounds.Min.X+(pos%p.Size+1)*tileW-gap,
+			bounds.Min.Y+(pos/p.Size+1)*tileH-gap,
+		)
+		draw.Draw(finImage, dst, img, src.Add(image.Pt(gap, gap)), draw.Src)
+	}
+	return finImage
+}
@@ -0,0 +1,118 @@
+package gen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPuzzleEncodeDecode(t *testing.T) {
+	p := &Puzzle{Size: 3, Tiles: []int{8, 1, 2, 0, 4, 5, 3, 6, 7}, Moves: 12}
+	state := p.Encode()
+	if state != "3-12-8.1.2.0.4.5.3.6.7" {
+		t.Errorf("Encode = %q", state)
+	}
+	got, err := DecodePuzzle(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("DecodePuzzle = %+v, want %+v", got, p)
+	}
+
+	for _, bad := range []string{
+		"",
+		"3-12",
+		"x-0-0.1.2.3.4.5.6.7.8",
+		"1-0-0",
+		"9-0-0",
+		"3--1-0.1.2.3.4.5.6.7.8",
+		"3-0-0.1.2.3.4.5.6.7",
+		"3-0-0.1.2.3.4.5.6.7.8.9",
+		"3-0-0.1.2.3.4.5.6.7.7",
+		"3-0-0.1.2.3.4.5.6.7.9",
+		"3-0-0.1.2.3.4.5.6.7.-1",
+		"3-0-0.1.2.3.4.5.6.7.x",
+	} {
+		if _, err := DecodePuzzle(bad); err == nil {
+			t.Errorf("DecodePuzzle(%q) accepted an invalid state", bad)
+		}
+	}
+}
+
+func TestPuzzleMove(t *testing.T) {
+	// the empty slot, tile 8, starts in the middle
+	p := &Puzzle{Size: 3, Tiles: []int{0, 1, 2, 3, 8, 5, 6, 4, 7}}
+	if p.Solved() {
+		t.Fatal("scrambled puzzle reported solved")
+	}
+
+	// Up slides the tile below the empty slot up into it
+	if n := p.Move(Up); n != 1 {
+		t.Fatalf("Move(Up) applied %d moves", n)
+	}
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 8, 7}; !reflect.DeepEqual(p.Tiles, want) {
+		t.Errorf("after Up tiles = %v, want %v", p.Tiles, want)
+	}
+	// nothing is below the bottom row
+	if n := p.Move(Up); n != 0 {
+		t.Errorf("Move(Up) off the board applied %d moves", n)
+	}
+	if n := p.Move(Left); n != 1 {
+		t.Fatalf("Move(Left) applied %d moves", n)
+	}
+	if !p.Solved() {
+		t.Fatalf("tiles = %v, want solved", p.Tiles)
+	}
+	if p.Moves != 2 {
+		t.Errorf("Moves = %d, want 2", p.Moves)
+	}
+	// moves after solving are ignored
+	if n := p.Move(Right, Down); n != 0 || !p.Solved() || p.Moves != 2 {
+		t.Errorf("moved a solved puzzle: %d applied, tiles %v", n, p.Tiles)
+	}
+}
+
+func TestNewPuzzle(t *testing.T) {
+	for _, size := range []int{3, 4} {
+		p, err := NewPuzzle(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Solved() {
+			t.Errorf("new %dx%d puzzle is already solved", size, size)
+		}
+		if _, err := DecodePuzzle(p.Encode()); err != nil {
+			t.Errorf("new %dx%d puzzle isn't a valid board: %v", size, size, err)
+		}
+	}
+	if _, err := NewPuzzle(1); err == nil {
+		t.Error("created a 1x1 puzzle")
+	}
+}
+
+func TestParseMoves(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []Direction
+	}{
+		{"UULDR", []Direction{Up, Up, Left, Down, Right}},
+		{"up, left down", []Direction{Up, Left, Down}},
+		{"↑→ r", []Direction{Up, Right, Right}},
+		{"", []Direction{}},
+	}
+	for _, c := range cases {
+		got, err := ParseMoves(c.in)
+		if err != nil {
+			t.Errorf("ParseMoves(%q): %v", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseMoves(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+	for _, bad := range []string{"UX", "upp", "hello"} {
+		if _, err := ParseMoves(bad); err == nil {
+			t.Errorf("ParseMoves(%q) accepted invalid moves", bad)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package gen
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	draw2 "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Caption darkens img and writes the given lines centered over it.
+// The text is drawn with the basic bitmap font and scaled up so it
+// stays legible once the frame image is shrunk by the client.
+func Caption(img image.Image, lines ...string) image.Image {
+	bounds := img.Bounds()
+	finImage := image.NewRGBA(bounds)
+	draw.Draw(finImage, bounds, img, bounds.Min, draw.Src)
+	shade := image.NewUniform(color.RGBA{0, 0, 0, 160})
+	draw.Draw(finImage, bounds, shade, image.Point{}, draw.Over)
+
+	face := basicfont.Face7x13
+	lineHeight := face.Metrics().Height.Ceil()
+
+	textW := 0
+	for _, l := range lines {
+		if w := font.MeasureString(face, l).Ceil(); w > textW {
+			textW = w
+		}
+	}
+	textH := lineHeight * len(lines)
+	if textW == 0 || textH == 0 {
+		return finImage
+	}
+
+	text := image.NewRGBA(image.Rect(0, 0, textW, textH))
+	d := &font.Drawer{
+		Dst:  text,
+		Src:  image.White,
+		Face: face,
+	}
+	for i, l := range lines {
+		w := font.MeasureString(face, l).Ceil()
+		d.Dot = fixed.P((textW-w)/2, i*lineHeight+face.Metrics().Ascent.Ceil())
+		d.DrawString(l)
+	}
+
+	// fit the text block within 80% of the image width
+	scale := bounds.Dx() * 8 / 10 / textW
+	if s := bounds.Dy() * 8 / 10 / textH; s < scale {
+		scale = s
+	}
+	if scale < 1 {
+		scale = 1
+	}
+	w, h := textW*scale, textH*scale
+	x := bounds.Min.X + (bounds.Dx()-w)/2
+	y := bounds.Min.Y + (bounds.Dy()-h)/2
+	draw2.NearestNeighbor.Scale(finImage, image.Rect(x, y, x+w, y+h), text, text.Bounds(), draw2.Over, nil)
+
+	return finImage
+}
+
+// TextImage renders the lines on a plain dark square of the given size.
+func TextImage(size int, lines ...string) image.Image {
+	bg := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(bg, bg.Bounds(), image.NewUniform(color.RGBA{30, 30, 40, 255}), image.Point{}, draw.Src)
+	return Caption(bg, lines...)
+}
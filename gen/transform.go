@@ -82,7 +82,9 @@ func animate(img image.Image, outDir string) ([]image.Image, error) {
 
 		out := filepath.Join(outDir, fmt.Sprintf("%d.png", i))
 
-		util.WriteImage(out, newImg)
+		if err := util.WriteImage(out, newImg); err != nil {
+			return nil, err
+		}
 		imgs = append(imgs, newImg)
 	}
 	return imgs, nil
@@ -90,6 +92,11 @@ func animate(img image.Image, outDir string) ([]image.Image, error) {
 }
 
 func ShuffleImageColumns(img image.Image) (image.Image, error) {
+	return ShuffleImageColumnsSeed(img, time.Now().Unix())
+}
+
+// ShuffleImageColumnsSeed shuffles columns in an order fixed by seed.
+func ShuffleImageColumnsSeed(img image.Image, seed int64) (image.Image, error) {
 	pixelCols, err := getPixelsByCol(img)
 	if err != nil {
 		log.Fatal(err)
@@ -100,7 +107,7 @@ func ShuffleImageColumns(img image.Image) (image.Image, error) {
 	}
 	finImage := image.NewRGBA(newRect)
 
-	r := rand.New(rand.NewSource(time.Now().Unix()))
+	r := rand.New(rand.NewSource(seed))
 
 	idx := 0
 	for _, i := range r.Perm(len(pixelCols)) {
@@ -124,6 +131,11 @@ func ShuffleImageColumns(img image.Image) (image.Image, error) {
 }
 
 func ShuffleImageRows(img image.Image) (image.Image, error) {
+	return ShuffleImageRowsSeed(img, time.Now().Unix())
+}
+
+// ShuffleImageRowsSeed shuffles rows in an order fixed by seed.
+func ShuffleImageRowsSeed(img image.Image, seed int64) (image.Image, error) {
 
 	pixelRows, err := getPixels(img)
 	if err != nil {
@@ -136,7 +148,7 @@ func ShuffleImageRows(img image.Image) (image.Image, error) {
 	}
 	finImage := image.NewRGBA(newRect)
 
-	r := rand.New(rand.NewSource(time.Now().Unix()))
+	r := rand.New(rand.NewSource(seed))
 
 	// idx := 0
 	for idx, i := range r.Perm(len(pixelRows)) {
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/store"
+)
+
+// handleHistory shows the session being chopped with buttons to move
+// through its history.
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	session, err := s.getSession(packet.UntrustedData.FID, r.URL.Query().Get("session"))
+	if err != nil {
+		log.Println("failed to get session: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.renderHistory(w, session)
+}
+
+// handleHistoryNav handles the buttons of the history frame. Undo steps
+// back to the image a session was made from, Branch cycles through the
+// other results made from that same image, and Chop resumes chopping from
+// the current one.
+func (s *server) handleHistoryNav(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	fid := packet.UntrustedData.FID
+	session, err := s.getSession(fid, r.URL.Query().Get("session"))
+	if err != nil {
+		log.Println("failed to get session: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch packet.UntrustedData.ButtonIndex {
+	case 1:
+		if parent, err := s.sessions.Get(fid, session.Parent); err == nil {
+			session = parent
+		}
+	case 2:
+		session = s.nextBranch(fid, session)
+	case 3:
+		renderChop(w, session.ID, s.store.URL(session.Key))
+		return
+	}
+	s.renderHistory(w, session)
+}
+
+// nextBranch returns the sibling made from the same parent after session,
+// wrapping around to the first.
+func (s *server) nextBranch(fid uint64, session store.Session) store.Session {
+	if session.Parent == "" {
+		return session
+	}
+	siblings := s.sessions.Children(fid, session.Parent)
+	for i, sibling := range siblings {
+		if sibling.ID == session.ID {
+			return siblings[(i+1)%len(siblings)]
+		}
+	}
+	return session
+}
+
+func (s *server) renderHistory(w http.ResponseWriter, session store.Session) {
+	buttons := []fc.Button{
+		{
+			Label:  []byte("Undo"),
+			Action: fc.ActionPOST,
+		},
+		{
+			Label:  []byte("Branch"),
+			Action: fc.ActionPOST,
+		},
+		{
+			Label:  []byte("Chop"),
+			Action: fc.ActionPOST,
+		},
+	}
+	if !s.chains.Empty() {
+		buttons = append(buttons, fc.Button{
+			Label:  []byte("Mint"),
+			Action: fc.ActionPOST,
+			Target: []byte(fmt.Sprintf("%s/mint?session=%s", BASE_URL, session.ID)),
+		})
+	}
+	frame := fc.Frame{
+		FrameV:  "vNext",
+		Image:   s.store.URL(session.Key),
+		PostURL: fmt.Sprintf("%s/history/nav?session=%s", BASE_URL, session.ID),
+		Buttons: buttons,
+	}
+	frame.Render(w)
+}
@@ -0,0 +1,212 @@
+// Package httpretry sends JSON API requests, retrying those that fail with
+// network errors, 429s or 5xxs.
+package httpretry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNotFound is matched by errors for 404 responses.
+	ErrNotFound = errors.New("not found")
+
+	// ErrRateLimited is matched by errors for 429 responses. The
+	// *StatusError carries how long the server asked us to wait.
+	ErrRateLimited = errors.New("rate limited")
+)
+
+const (
+	DefaultRetries   = 3
+	DefaultBaseDelay = 200 * time.Millisecond
+	DefaultMaxDelay  = 5 * time.Second
+	maxErrorBody     = 512
+)
+
+// StatusError is returned when an API responds with a non-2xx status.
+// It matches ErrNotFound for 404s and ErrRateLimited for 429s.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+func (e *StatusError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Requester sends requests with a shared client and headers. Zero delays
+// use the defaults.
+type Requester struct {
+	// Client defaults to http.DefaultClient.
+	Client    *http.Client
+	Retries   int
+	Header    http.Header
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff between attempts. A 429 asking to wait
+	// longer is returned rather than retried.
+	MaxDelay time.Duration
+}
+
+// Request describes a single API call. Idempotent requests may be retried.
+type Request struct {
+	Method      string
+	URL         string
+	Body        []byte
+	ContentType string
+	Idempotent  bool
+	// Last decodes every value of a stream of JSON values into out, so it
+	// holds the last, such as the directory Kubo's add reports after the
+	// files in it.
+	Last bool
+}
+
+// Do sends req, decoding a 2xx JSON response into out.
+func (r *Requester) Do(ctx context.Context, req Request, out interface{}) error {
+	attempts := 1
+	if req.Idempotent {
+		attempts += r.Retries
+	}
+	maxDelay := r.MaxDelay
+	if maxDelay == 0 {
+		maxDelay = DefaultMaxDelay
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := r.backoff(attempt)
+			var se *StatusError
+			if errors.As(err, &se) && se.RetryAfter > delay {
+				if se.RetryAfter > maxDelay {
+					return err
+				}
+				delay = se.RetryAfter
+			}
+			log.Printf("retrying %s %s in %s: %v", req.Method, req.URL, delay, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		err = r.once(ctx, req, out)
+		if err == nil {
+			return nil
+		}
+		var se *StatusError
+		if errors.As(err, &se) && !se.retryable() {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func (r *Requester) once(ctx context.Context, req Request, out interface{}) error {
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewReader(req.Body)
+	}
+	hr, err := http.NewRequestWithContext(ctx, req.Method, req.URL, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range r.Header {
+		hr.Header[k] = v
+	}
+	hr.Header.Set("Accept", "application/json")
+	if req.ContentType != "" {
+		hr.Header.Set("Content-Type", req.ContentType)
+	}
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(hr)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+		return &StatusError{
+			Method:     req.Method,
+			URL:        req.URL,
+			StatusCode: res.StatusCode,
+			Body:       string(msg),
+			RetryAfter: ParseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+
+	dec := json.NewDecoder(res.Body)
+	if err := dec.Decode(out); err != nil {
+		return fmt.Errorf("decode %s: %w", req.URL, err)
+	}
+	for req.Last && dec.More() {
+		if err := dec.Decode(out); err != nil {
+			return fmt.Errorf("decode %s: %w", req.URL, err)
+		}
+	}
+	return nil
+}
+
+// backoff returns a full-jitter exponential delay for the given attempt.
+func (r *Requester) backoff(attempt int) time.Duration {
+	base, max := r.BaseDelay, r.MaxDelay
+	if base == 0 {
+		base = DefaultBaseDelay
+	}
+	if max == 0 {
+		max = DefaultMaxDelay
+	}
+	if d := base << (attempt - 1); d > 0 && d < max {
+		max = d
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// ParseRetryAfter reads a Retry-After header given either in seconds or
+// as an HTTP date.
+func ParseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
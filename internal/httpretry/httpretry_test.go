@@ -0,0 +1,93 @@
+package httpretry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func standIn(t *testing.T, handler func(w http.ResponseWriter, hit int)) (string, *int32) {
+	t.Helper()
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, int(atomic.AddInt32(&hits, 1)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &hits
+}
+
+func TestDoRetriesIdempotent(t *testing.T) {
+	url, hits := standIn(t, func(w http.ResponseWriter, hit int) {
+		if hit == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	})
+	r := &Requester{Retries: 2, BaseDelay: time.Millisecond}
+	var out struct{ OK bool }
+	if err := r.Do(context.Background(), Request{Method: http.MethodGet, URL: url, Idempotent: true}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.OK || *hits != 2 {
+		t.Errorf("ok = %v after %d requests, want true after 2", out.OK, *hits)
+	}
+}
+
+func TestDoDoesNotRetryNonIdempotent(t *testing.T) {
+	url, hits := standIn(t, func(w http.ResponseWriter, hit int) {
+		http.Error(w, "broken", http.StatusBadGateway)
+	})
+	r := &Requester{Retries: 3}
+	err := r.Do(context.Background(), Request{Method: http.MethodPost, URL: url}, &struct{}{})
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusBadGateway || se.Method != http.MethodPost {
+		t.Fatalf("err = %v, want a POST 502 StatusError", err)
+	}
+	if *hits != 1 {
+		t.Errorf("got %d requests, want 1", *hits)
+	}
+}
+
+func TestStatusErrorIs(t *testing.T) {
+	cases := []struct {
+		code              int
+		notFound, limited bool
+	}{
+		{http.StatusNotFound, true, false},
+		{http.StatusTooManyRequests, false, true},
+		{http.StatusInternalServerError, false, false},
+	}
+	for _, c := range cases {
+		err := error(&StatusError{StatusCode: c.code})
+		if errors.Is(err, ErrNotFound) != c.notFound || errors.Is(err, ErrRateLimited) != c.limited {
+			t.Errorf("%d: ErrNotFound %v, ErrRateLimited %v", c.code, errors.Is(err, ErrNotFound), errors.Is(err, ErrRateLimited))
+		}
+	}
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	r := &Requester{BaseDelay: time.Second, MaxDelay: 2 * time.Second}
+	for attempt := 1; attempt < 70; attempt++ {
+		if d := r.backoff(attempt); d < 0 || d >= 2*time.Second {
+			t.Fatalf("attempt %d: backoff %s outside [0, 2s)", attempt, d)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := ParseRetryAfter("7"); d != 7*time.Second {
+		t.Errorf("seconds: got %s", d)
+	}
+	date := time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)
+	if d := ParseRetryAfter(date); d < 25*time.Second || d > 30*time.Second {
+		t.Errorf("date: got %s", d)
+	}
+	if d := ParseRetryAfter("soon"); d != 0 {
+		t.Errorf("invalid: got %s", d)
+	}
+}
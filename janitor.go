@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/store"
+)
+
+var (
+	// durations such as 720h; 0 disables
+	JANITOR_INTERVAL = os.Getenv("JANITOR_INTERVAL")
+	RESULTS_MAX_AGE  = os.Getenv("RESULTS_MAX_AGE")
+	CACHE_MAX_AGE    = os.Getenv("CACHE_MAX_AGE")
+	// byte budgets; 0 disables
+	RESULTS_MAX_BYTES_PER_FID = os.Getenv("RESULTS_MAX_BYTES_PER_FID")
+	RESULTS_MAX_BYTES         = os.Getenv("RESULTS_MAX_BYTES")
+	// set to false to let the limits delete minted images
+	JANITOR_KEEP_MINTED = os.Getenv("JANITOR_KEEP_MINTED")
+	// log what would be deleted without deleting it
+	JANITOR_DRY_RUN = os.Getenv("JANITOR_DRY_RUN") != ""
+)
+
+const (
+	defaultJanitorInterval = time.Hour
+	defaultResultsMaxAge   = 30 * 24 * time.Hour
+	defaultCacheMaxAge     = 7 * 24 * time.Hour
+)
+
+type janitorConfig struct {
+	interval    time.Duration
+	cacheMaxAge time.Duration
+	policy      store.Policy
+}
+
+func loadJanitorConfig() janitorConfig {
+	cfg := janitorConfig{
+		interval:    envDuration("JANITOR_INTERVAL", JANITOR_INTERVAL, defaultJanitorInterval),
+		cacheMaxAge: envDuration("CACHE_MAX_AGE", CACHE_MAX_AGE, defaultCacheMaxAge),
+		policy: store.Policy{
+			MaxAge:         envDuration("RESULTS_MAX_AGE", RESULTS_MAX_AGE, defaultResultsMaxAge),
+			MaxBytesPerFID: envInt("RESULTS_MAX_BYTES_PER_FID", RESULTS_MAX_BYTES_PER_FID),
+			MaxBytes:       envInt("RESULTS_MAX_BYTES", RESULTS_MAX_BYTES),
+			KeepMinted:     true,
+			DryRun:         JANITOR_DRY_RUN,
+		},
+	}
+	if JANITOR_KEEP_MINTED != "" {
+		keep, err := strconv.ParseBool(JANITOR_KEEP_MINTED)
+		if err != nil {
+			log.Fatal("invalid JANITOR_KEEP_MINTED: ", err)
+		}
+		cfg.policy.KeepMinted = keep
+	}
+	return cfg
+}
+
+func envDuration(name, value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	return d
+}
+
+func envInt(name, value string) int64 {
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", name, err)
+	}
+	return n
+}
+
+// runJanitor collects old results and prunes the PFP cache every
+// interval until ctx is done.
+func (s *server) runJanitor(ctx context.Context, cfg janitorConfig) {
+	if cfg.interval <= 0 {
+		log.Println("janitor disabled")
+		return
+	}
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+	for {
+		s.cleanup(ctx, cfg)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *server) cleanup(ctx context.Context, cfg janitorConfig) {
+	report, err := store.Collect(ctx, s.store, s.sessions, cfg.policy)
+	if err != nil {
+		log.Println("failed to collect results: ", err)
+	} else {
+		log.Println("janitor results: ", report)
+	}
+
+	if cfg.cacheMaxAge > 0 && fc.Disk != nil {
+		removed, freed, err := fc.Disk.Prune(cfg.cacheMaxAge, cfg.policy.DryRun)
+		if err != nil {
+			log.Println("failed to prune pfp cache: ", err)
+			return
+		}
+		log.Printf("janitor pfp cache: %d entries, %d bytes (dry run: %t)", removed, freed, cfg.policy.DryRun)
+	}
+}
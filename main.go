@@ -3,31 +3,170 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/treethought/impression-frame/contract"
 	fc "github.com/treethought/impression-frame/farcaster"
 	"github.com/treethought/impression-frame/gen"
+	"github.com/treethought/impression-frame/store"
 	"github.com/treethought/impression-frame/util"
 )
 
 var (
 	BASE_URL = os.Getenv("BASE_URL")
+
+	API_KEY   = os.Getenv("API_KEY")
+	FC_CLIENT = os.Getenv("FC_CLIENT")
+	HUB_URL   = os.Getenv("HUB_URL")
+	// VALIDATE_MESSAGES checks frame actions with FC_CLIENT. Mints are
+	// refused while it is unset, as the fid and message hash they are
+	// limited by would be untrusted.
+	VALIDATE_MESSAGES = os.Getenv("VALIDATE_MESSAGES") != ""
+
+	// comma separated gateway base URLs, e.g. https://ipfs.io
+	IPFS_GATEWAYS    = os.Getenv("IPFS_GATEWAYS")
+	ARWEAVE_GATEWAYS = os.Getenv("ARWEAVE_GATEWAYS")
+
+	// STORE is one of local (default), memory or s3
+	STORE         = os.Getenv("STORE")
+	RESULTS_DIR   = os.Getenv("RESULTS_DIR")
+	S3_ENDPOINT   = os.Getenv("S3_ENDPOINT")
+	S3_BUCKET     = os.Getenv("S3_BUCKET")
+	S3_REGION     = os.Getenv("S3_REGION")
+	S3_ACCESS_KEY = os.Getenv("S3_ACCESS_KEY")
+	S3_SECRET_KEY = os.Getenv("S3_SECRET_KEY")
+	// S3_PUBLIC_URL serves results straight from the bucket; when unset
+	// they are proxied through /results/
+	S3_PUBLIC_URL = os.Getenv("S3_PUBLIC_URL")
+	SESSION_INDEX = os.Getenv("SESSION_INDEX")
 )
 
+const maxSessions = 50000
+
+// server holds the dependencies shared by the frame handlers.
+type server struct {
+	fc fc.Client
+	// validate checks each frame action with the client and replaces the
+	// untrusted data with the validated message.
+	validate bool
+	store    store.ImageStore
+	sessions *store.SessionIndex
+	mints    *store.MintQueue
+	resolver contract.Resolver
+	policy   mintPolicy
+	chains   *contract.Registry
+
+	// contracts are created by chain name on the first mint unless set, so
+	// tests can mint with a simulated minter and fake pinner.
+	contractMu sync.Mutex
+	contracts  map[string]*contract.Contract
+}
+
+func newFarcasterClient() fc.Client {
+	switch FC_CLIENT {
+	case "hub":
+		return fc.NewHubClient(HUB_URL, API_KEY)
+	default:
+		return fc.NewNeynarClient(API_KEY)
+	}
+}
+
+func newImageStore() store.ImageStore {
+	resultsURL := BASE_URL + "/results"
+	switch STORE {
+	case "memory":
+		return store.NewMemoryStore(resultsURL)
+	case "s3":
+		publicURL := S3_PUBLIC_URL
+		if publicURL == "" {
+			publicURL = resultsURL
+		}
+		return store.NewS3Store(S3_ENDPOINT, S3_BUCKET, S3_REGION, S3_ACCESS_KEY, S3_SECRET_KEY, publicURL)
+	default:
+		dir := RESULTS_DIR
+		if dir == "" {
+			dir = "results"
+		}
+		return store.NewLocalStore(dir, resultsURL)
+	}
+}
+
+func newSessionIndex() *store.SessionIndex {
+	path := SESSION_INDEX
+	if path == "" {
+		path = "tmp/sessions.json"
+	}
+	if STORE == "memory" {
+		path = ""
+	}
+	idx, err := store.NewSessionIndex(path, maxSessions)
+	if err != nil {
+		log.Fatal("failed to load session index: ", err)
+	}
+	return idx
+}
+
 func main() {
+	if IPFS_GATEWAYS != "" {
+		util.DefaultFetcher.IPFSGateways = strings.Split(IPFS_GATEWAYS, ",")
+	}
+	if ARWEAVE_GATEWAYS != "" {
+		util.DefaultFetcher.ArweaveGateways = strings.Split(ARWEAVE_GATEWAYS, ",")
+	}
+
+	if !VALIDATE_MESSAGES {
+		log.Println("VALIDATE_MESSAGES is unset, so mints will be refused")
+	}
+	if err := fc.OpenDisk(fc.DefaultCacheDir); err != nil {
+		log.Fatal("failed to open pfp cache: ", err)
+	}
+
+	s := &server{
+		fc:       newFarcasterClient(),
+		validate: VALIDATE_MESSAGES,
+		store:    newImageStore(),
+		sessions: newSessionIndex(),
+		mints:    newMintQueue(),
+		resolver: newResolver(),
+		policy:   loadMintPolicy(),
+		chains:   loadChains(),
+	}
+	go s.runJanitor(context.Background(), loadJanitorConfig())
+	s.runMintWorkers(context.Background(), mintWorkers())
+	go runMosaicIndexer(context.Background())
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", handleIndex)
 	mux.HandleFunc("/images/", serveImage)
-	mux.HandleFunc("/results/", serveImage)
-	mux.HandleFunc("/start", handleStart)
-	mux.HandleFunc("/generate", handleGenerate)
+	mux.HandleFunc("/results/", s.serveResult)
+	mux.HandleFunc("/stats", handleStats)
+	mux.HandleFunc("/start", s.handleStart)
+	mux.HandleFunc("/generate", s.handleGenerate)
+	mux.HandleFunc("/puzzle", s.handlePuzzle)
+	mux.HandleFunc("/puzzle/new", s.handlePuzzleNew)
+	mux.HandleFunc("/puzzle/move", s.handlePuzzleMove)
+	mux.HandleFunc("/puzzle/board", s.handlePuzzleBoard)
+	mux.HandleFunc("/mashup", s.handleMashup)
+	mux.HandleFunc("/mashup/generate", s.handleMashupGenerate)
+	mux.HandleFunc("/network", s.handleNetwork)
+	mux.HandleFunc("/network/generate", s.handleNetworkGenerate)
+	mux.HandleFunc("/history", s.handleHistory)
+	mux.HandleFunc("/history/nav", s.handleHistoryNav)
+	mux.HandleFunc("/mint", s.handleMint)
+	mux.HandleFunc("/mint/to", s.handleMintTo)
+	mux.HandleFunc("/mint/status", s.handleMintStatus)
+	mux.HandleFunc("/edition", s.handleEdition)
+	mux.HandleFunc("/edition/mint", s.handleEditionMint)
 
 	log.Println("starting server on port 8080")
 	if err := http.ListenAndServe("0.0.0.0:8080", mux); err != nil {
@@ -46,26 +185,91 @@ func handleIndex(w http.ResponseWriter, r *http.Request) {
 				Label:  []byte("Start"),
 				Action: fc.ActionPOST,
 			},
+			{
+				Label:  []byte("Puzzle"),
+				Action: fc.ActionPOST,
+				Target: []byte(fmt.Sprintf("%s/puzzle", BASE_URL)),
+			},
+			{
+				Label:  []byte("Mashup"),
+				Action: fc.ActionPOST,
+				Target: []byte(fmt.Sprintf("%s/mashup", BASE_URL)),
+			},
+			{
+				Label:  []byte("Network"),
+				Action: fc.ActionPOST,
+				Target: []byte(fmt.Sprintf("%s/network", BASE_URL)),
+			},
 		},
 	}
 	start.Render(w)
 }
 
+// handleStats reports the PFP cache counters.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pfp_cache": fc.Cache.Stats(),
+	})
+}
+
 func serveImage(w http.ResponseWriter, r *http.Request) {
 	log.Println("serving image: ", r.URL.Path[1:])
 	http.ServeFile(w, r, r.URL.Path[1:])
 }
 
-func handleStart(w http.ResponseWriter, r *http.Request) {
+// serveResult serves a generated image from the image store. Stored
+// images never change, so they are cached indefinitely.
+func (s *server) serveResult(w http.ResponseWriter, r *http.Request) {
+	key, err := store.ParsePath(strings.TrimPrefix(r.URL.Path, "/results/"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	// the etag alone can't answer a revalidation, as the image may have
+	// been deleted since
+	if r.Header.Get("If-None-Match") == key.ETag() {
+		ok, err := s.store.Has(r.Context(), key)
+		if err != nil {
+			log.Println("failed to check result: ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Cache-Control", store.CacheControl)
+		w.Header().Set("ETag", key.ETag())
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	data, err := s.store.Get(r.Context(), key)
+	if errors.Is(err, store.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("failed to read result: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", store.CacheControl)
+	w.Header().Set("ETag", key.ETag())
+	w.Header().Set("Content-Type", key.ContentType())
+	w.Write(data)
+}
+
+func (s *server) handleStart(w http.ResponseWriter, r *http.Request) {
 	log.Println("start request received")
-	packet, err := getSignaturePacket(r)
+	packet, err := s.getSignaturePacket(r)
 	if err != nil {
 		log.Println("failed to get signature packet: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	fid := packet.UntrustedData.FID
-	user, err := fc.GetUser(fid)
+	user, err := s.fc.GetUser(r.Context(), fid)
 	if err != nil {
 		log.Println("failed to get pfp: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -74,12 +278,12 @@ func handleStart(w http.ResponseWriter, r *http.Request) {
 	pfpUrl := user.PfpUrl
 
 	// get image from pfp url to cache
-	_, err = fc.GetOrLoadPFP(fid)
+	_, err = fc.GetOrLoadPFP(r.Context(), s.fc, fid)
 
 	frame := fc.Frame{
 		FrameV:  "vNext",
 		Image:   pfpUrl,
-		PostURL: "https://frame.seaborne.cloud/generate",
+		PostURL: fmt.Sprintf("%s/generate", BASE_URL),
 		Buttons: []fc.Button{
 			{
 				Label:  []byte("Slice and dice"),
@@ -103,97 +307,151 @@ func handleStart(w http.ResponseWriter, r *http.Request) {
 	frame.Render(w)
 }
 
-func getSignaturePacket(r *http.Request) (fc.SignaturePacket, error) {
+func (s *server) getSignaturePacket(r *http.Request) (fc.SignaturePacket, error) {
 	var packet fc.SignaturePacket
 	if err := json.NewDecoder(r.Body).Decode(&packet); err != nil {
 		log.Println("failed to decode packet: ", err)
 		return packet, err
 	}
+	if !s.validate {
+		return packet, nil
+	}
+
+	msg, err := s.fc.ValidateMessage(r.Context(), packet.TrustedData.MessageBytes)
+	if err != nil {
+		return packet, err
+	}
+	if !msg.Valid {
+		return packet, fmt.Errorf("invalid frame message")
+	}
+	if err := checkFrameURL(msg.URL); err != nil {
+		return packet, err
+	}
+	packet.UntrustedData.FID = msg.FID
+	packet.UntrustedData.ButtonIndex = msg.ButtonIndex
+	packet.UntrustedData.InputText = msg.InputText
+	packet.UntrustedData.CastId.FID = msg.CastFID
+	packet.UntrustedData.CastId.Hash = msg.CastHash
+	// without a validated hash, mints are refused rather than trusting
+	// the untrusted one
+	packet.UntrustedData.MessageHash = msg.MessageHash
+	packet.UntrustedData.Timestamp = 0
+	if !msg.Timestamp.IsZero() {
+		packet.UntrustedData.Timestamp = uint64(msg.Timestamp.UnixMilli())
+	}
 	return packet, nil
 }
 
-func getSessionImg(r *http.Request, packet fc.SignaturePacket, fid uint64) (image.Image, error) {
-
-	var img image.Image
-	var err error
+// checkFrameURL returns an error unless the signed url of a frame action
+// is a frame served from BASE_URL, so actions signed for another app's
+// frames can't be replayed here. The signed url is the frame that was
+// clicked rather than the endpoint posted to, as buttons may post
+// elsewhere.
+func checkFrameURL(signed string) error {
+	u, err := url.Parse(signed)
+	if err != nil {
+		return fmt.Errorf("invalid frame url %q: %w", signed, err)
+	}
+	base, err := url.Parse(BASE_URL)
+	if err != nil {
+		return fmt.Errorf("invalid BASE_URL: %w", err)
+	}
+	if !strings.EqualFold(u.Scheme, base.Scheme) || !strings.EqualFold(u.Host, base.Host) {
+		return fmt.Errorf("frame action for %s isn't for %s", signed, BASE_URL)
+	}
+	return nil
+}
 
+// getSessionImg loads the image of the session being continued, or the
+// PFP of fid when starting out. The PFP is recorded as the root of a new
+// session tree. It returns the image and its session id.
+func (s *server) getSessionImg(r *http.Request, fid uint64) (image.Image, string, error) {
 	if r.Method == "POST" && r.URL.Query().Get("session") != "" {
-		id := r.URL.Query().Get("session")
-		path := fmt.Sprintf("results/%d/%s.png", fid, id)
 		log.Println("continue session")
-		img, _, err = util.LoadImage(path)
+		session, err := s.getSession(fid, r.URL.Query().Get("session"))
+		if err != nil {
+			return nil, "", err
+		}
+		img, err := store.GetImage(r.Context(), s.store, session.Key)
 		if err != nil {
 			log.Println("failed to read image: ", err)
-			return nil, err
+			return nil, "", err
 		}
-	} else {
-		img, err = fc.GetOrLoadPFP(fid)
+		return img, session.ID, nil
 	}
-	return img, err
 
+	img, err := fc.GetOrLoadPFP(r.Context(), s.fc, fid)
+	if err != nil {
+		return nil, "", err
+	}
+	id, _, err := s.saveResult(r.Context(), fid, img, store.Session{Transform: "pfp"})
+	if err != nil {
+		return nil, "", err
+	}
+	return img, id, nil
 }
 
-func handleGenerate(w http.ResponseWriter, r *http.Request) {
-	packet, err := getSignaturePacket(r)
+func (s *server) handleGenerate(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
 	if err != nil {
 		log.Println("failed to get signature packet: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	img, err := getSessionImg(r, packet, packet.UntrustedData.FID)
+	img, parent, err := s.getSessionImg(r, packet.UntrustedData.FID)
 	if err != nil {
 		log.Println("failed to get session image: ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	outDir := fmt.Sprintf("results/%d", packet.UntrustedData.FID)
-	if err := os.MkdirAll(outDir, 0755); err != nil {
-		log.Println("failed to create output dir: ", err)
-		w.WriteHeader(http.StatusInternalServerError)
-	}
+	node := store.Session{Parent: parent, Seed: time.Now().UnixNano()}
 
 	var result image.Image
 	switch packet.UntrustedData.ButtonIndex {
 	case 1:
-		result = runSliceAndDice(img, outDir)
+		node.Transform = "slice"
+		node.Params = map[string]string{"seed_mode": "consistent"}
+		result = runSliceAndDice(img, node.Seed)
 	case 2:
-		result = runShuffle(img, outDir)
+		node.Transform = "shuffle"
+		node.Params = map[string]string{"seed_mode": "varying"}
+		result = runShuffle(img, node.Seed)
 	case 3:
+		// recombining isn't random, so there is no seed to record
+		node.Transform = "recombine"
+		node.Params = map[string]string{"with": "pfp"}
+		node.Seed = 0
 		og := img
 		if url := fc.Cache.GetPfpUrl(packet.UntrustedData.FID); url != "" {
-			cached, err := fc.GetOrLoadPFP(packet.UntrustedData.FID)
+			cached, err := fc.GetOrLoadPFP(r.Context(), s.fc, packet.UntrustedData.FID)
 			if err == nil {
 				og = cached
 			}
 		}
-		result = runRecombine(img, og, outDir)
-	case 4:
-		frame, err := handleMint(r.Context(), packet, img)
-		if err != nil {
-			log.Println("failed to mint: ", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		frame.Render(w)
-		return
+		result = runRecombine(img, og)
 	default:
-		result = runTransform(img, outDir)
+		node.Transform = "transform"
+		node.Params = map[string]string{"seed_mode": "consistent"}
+		result = runTransform(img, node.Seed)
 	}
 
-	id := uuid.New().String()
-	out := fmt.Sprintf("%s/%s.png", outDir, id)
-	util.WriteImage(out, result)
-	log.Println("wrote image to: ", out)
-
-	imgUrl := fmt.Sprintf("%s/%s", BASE_URL, out)
-
-	postURL := fmt.Sprintf("%s/generate?session=%s", BASE_URL, id)
+	id, imgUrl, err := s.saveResult(r.Context(), packet.UntrustedData.FID, result, node)
+	if err != nil {
+		log.Println("failed to save result: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	renderChop(w, id, imgUrl)
+}
 
+// renderChop renders the chop frame for session id, whose image is at
+// imgUrl.
+func renderChop(w http.ResponseWriter, id, imgUrl string) {
 	frame := fc.Frame{
 		FrameV:  "vNext",
 		Image:   imgUrl,
-		PostURL: postURL,
+		PostURL: fmt.Sprintf("%s/generate?session=%s", BASE_URL, id),
 		Buttons: []fc.Button{
 			{
 				Label:  []byte("Slice"),
@@ -208,86 +466,175 @@ func handleGenerate(w http.ResponseWriter, r *http.Request) {
 				Action: fc.ActionPOST,
 			},
 			{
-				Label:  []byte("Mint"),
+				Label:  []byte("History"),
 				Action: fc.ActionPOST,
-				Target: []byte(fmt.Sprintf("%s/mint", BASE_URL)),
+				Target: []byte(fmt.Sprintf("%s/history?session=%s", BASE_URL, id)),
 			},
 		},
 	}
 
 	frame.Render(w)
-
 }
 
-func handleMint(ctx context.Context, packet fc.SignaturePacket, img image.Image) (*fc.Frame, error) {
-	user, err := fc.GetUser(packet.UntrustedData.FID)
+// saveResult stores img under its content hash, unless an identical
+// result is already stored, and records it as a new node of the session
+// tree. node describes how img was made; its ID and Key are filled in. It
+// returns the new session id and the URL the image is served from.
+func (s *server) saveResult(ctx context.Context, fid uint64, img image.Image, node store.Session) (string, string, error) {
+	key := store.ContentKey(fid, img)
+	exists, err := s.store.Has(ctx, key)
 	if err != nil {
-		log.Println("failed to get user: ", err)
-		return nil, err
+		return "", "", err
 	}
-	c, err := contract.NewContract()
-	if err != nil {
-		log.Println("failed to create contract: ", err)
-		return nil, err
+	if exists {
+		log.Println("reusing stored result: ", key.Path())
+	} else {
+		if err := store.PutImage(ctx, s.store, key, img); err != nil {
+			return "", "", err
+		}
+		log.Println("stored result: ", key.Path())
 	}
 
-	tx, err := c.Mint(ctx, img, user)
-	if err != nil {
-		log.Println("failed to mint: ", err)
-		return nil, err
+	node.ID = uuid.New().String()
+	node.Key = key
+	if node.SourcePFP == "" {
+		// roots are made just after loading the PFP, so it is cached
+		node.SourcePFP = fc.Cache.GetPfpUrl(fid)
+		if node.Parent != "" {
+			if parent, err := s.sessions.Get(fid, node.Parent); err == nil {
+				node.SourcePFP = parent.SourcePFP
+			}
+		}
+	}
+	if err := s.sessions.Add(node); err != nil {
+		return "", "", err
 	}
-	result, _ := json.Marshal(&tx)
-	fmt.Println(string(result))
+	if exists {
+		// the janitor may have collected the image before the session
+		// referencing it was added
+		if ok, err := s.store.Has(ctx, key); err == nil && !ok {
+			if err := store.PutImage(ctx, s.store, key, img); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	return node.ID, s.store.URL(key), nil
+}
 
-	explorerUrl := fmt.Sprintf("https://sepolia.explorer.zora.energy/tx/%s", tx.Hash().Hex())
+// getSession returns session id of fid. Sessions from before the index
+// are names of the stored image itself and have no history.
+func (s *server) getSession(fid uint64, id string) (store.Session, error) {
+	session, err := s.sessions.Get(fid, id)
+	if errors.Is(err, store.ErrNotFound) {
+		key, err := store.ParseKey(fid, id)
+		return store.Session{ID: id, Key: key}, err
+	}
+	return session, err
+}
 
-	frame := &fc.Frame{
+// renderError renders a frame explaining what went wrong, with a single
+// button posting to retryURL.
+func (s *server) renderError(w http.ResponseWriter, r *http.Request, fid uint64, retryURL string, lines ...string) {
+	imgUrl, err := s.textImage(r.Context(), fid, lines...)
+	if err != nil {
+		log.Println("failed to save error image: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	frame := fc.Frame{
 		FrameV:  "vNext",
-		Image:   fmt.Sprintf("%s/images/cover.png", BASE_URL),
-		PostURL: fmt.Sprintf("%s/start", BASE_URL),
+		Image:   imgUrl,
+		PostURL: retryURL,
 		Buttons: []fc.Button{
 			{
-				Label:  []byte("View"),
-				Action: fc.ActionLink,
-				Target: []byte(explorerUrl),
+				Label:  []byte("Try again"),
+				Action: fc.ActionPOST,
 			},
 		},
 	}
+	frame.Render(w)
+}
 
-	return frame, nil
+// provenance describes how session was made from the PFP of user, for
+// the metadata of its token. Sessions from before source PFPs were
+// recorded fall back to the user's current PFP.
+func (s *server) provenance(user *fc.User, session string) contract.Provenance {
+	prov := contract.Provenance{
+		SourceFID:   user.FID,
+		SourcePFP:   user.PfpUrl,
+		GeneratedAt: time.Now(),
+		Session:     session,
+		Transforms:  []contract.Step{},
+	}
 
+	chain := s.sessions.Ancestry(user.FID, session)
+	if len(chain) > 0 && chain[len(chain)-1].SourcePFP != "" {
+		prov.SourcePFP = chain[len(chain)-1].SourcePFP
+	}
+	// the index only keeps the newest sessions
+	prov.Truncated = len(chain) == 0 || chain[0].Parent != ""
+	if len(chain) > 0 && chain[0].Transform == "pfp" {
+		prov.SourceHash = chain[0].Key.ID
+		chain = chain[1:]
+	}
+	for i, node := range chain {
+		prov.Transforms = append(prov.Transforms, contract.Step{
+			Transform: node.Transform,
+			Seed:      node.Seed,
+			Params:    node.Params,
+		})
+		if i == len(chain)-1 {
+			prov.GeneratedAt = node.Created
+		} else if node.Mint != nil {
+			// the nearest minted ancestor is the token being remixed
+			prov.ParentToken = &contract.TokenRef{
+				ChainID:     node.Mint.ChainID,
+				Contract:    node.Mint.Contract,
+				TokenID:     node.Mint.TokenID,
+				TxHash:      node.Mint.TxHash,
+				MetadataURI: node.Mint.MetadataURI,
+			}
+		}
+	}
+	return prov
 }
 
-func runSliceAndDice(img image.Image, outdir string) image.Image {
+func runSliceAndDice(img image.Image, seed int64) image.Image {
 	log.Println("running slice and dice")
-	sr, _ := gen.ShuffleImageRows(img)
-	sc, _ := gen.ShuffleImageColumns(img)
-	result := gen.CombineImages(sc, sr)
-	return result
+	return gen.Animate(img, seed, gen.SeedConsistent, func(frame image.Image, _ int, seed int64) image.Image {
+		sr, _ := gen.ShuffleImageRowsSeed(frame, seed)
+		sc, _ := gen.ShuffleImageColumnsSeed(frame, seed)
+		return gen.CombineImages(sc, sr)
+	})
 }
 
-func runShuffle(img image.Image, outdir string) image.Image {
+func runShuffle(img image.Image, seed int64) image.Image {
 	log.Println("running shuffle")
-	for i := 0; i < 2; i++ {
-		sr, _ := gen.ShuffleImageRows(img)
-		sc, _ := gen.ShuffleImageColumns(img)
-		img = gen.CombineImages(sr, sc)
-	}
-	return img
+	return gen.Animate(img, seed, gen.SeedVarying, func(frame image.Image, _ int, seed int64) image.Image {
+		for i := int64(0); i < 2; i++ {
+			sr, _ := gen.ShuffleImageRowsSeed(frame, seed+i)
+			sc, _ := gen.ShuffleImageColumnsSeed(frame, seed+i)
+			frame = gen.CombineImages(sr, sc)
+		}
+		return frame
+	})
 }
-func runRecombine(img image.Image, og image.Image, outdir string) image.Image {
+
+func runRecombine(img image.Image, og image.Image) image.Image {
 	log.Println("running recombine")
-	result := gen.WriteWithin(og, img, 80)
-	img = gen.CombineImages(img, result)
-	result = gen.WriteWithin(img, og, 60)
-	img = gen.CombineImages(img, result)
-	result = gen.WriteWithin(og, img, 40)
-	img = gen.CombineImages(img, result)
-	result = gen.WriteWithin(img, og, 20)
-	return result
+	return gen.Animate(img, 0, gen.SeedConsistent, func(img image.Image, i int, _ int64) image.Image {
+		base := gen.FrameAt(og, i)
+		result := gen.WriteWithin(base, img, 80)
+		img = gen.CombineImages(img, result)
+		result = gen.WriteWithin(img, base, 60)
+		img = gen.CombineImages(img, result)
+		result = gen.WriteWithin(base, img, 40)
+		img = gen.CombineImages(img, result)
+		return gen.WriteWithin(img, base, 20)
+	})
 }
 
-func runRecombine2(img image.Image, outdir string) image.Image {
+func runRecombine2(img image.Image) image.Image {
 	log.Println("running recombine")
 	result := gen.WriteWithin(img, img, 80)
 	img = gen.CombineImages(img, result)
@@ -299,18 +646,15 @@ func runRecombine2(img image.Image, outdir string) image.Image {
 	return result
 }
 
-func runTransform(img image.Image, outdir string) image.Image {
+func runTransform(img image.Image, seed int64) image.Image {
 	log.Println("runTransform")
-	// return img
-	sr, _ := gen.ShuffleImageRows(img)
-	sc, _ := gen.ShuffleImageColumns(img)
-	result := gen.WriteWithin(sc, sr, 80)
-	result = gen.CombineImages(result, sr)
-	result = gen.WriteWithin(result, sc, 60)
-	result = gen.WriteWithin(result, sr, 40)
-	result = gen.WriteWithin(result, sc, 30)
-	return result
-
-	// bb := gen.CombineImages(sr, sc)
-	// return gen.WriteWithin(bb, bb, 20)
+	return gen.Animate(img, seed, gen.SeedConsistent, func(frame image.Image, _ int, seed int64) image.Image {
+		sr, _ := gen.ShuffleImageRowsSeed(frame, seed)
+		sc, _ := gen.ShuffleImageColumnsSeed(frame, seed)
+		result := gen.WriteWithin(sc, sr, 80)
+		result = gen.CombineImages(result, sr)
+		result = gen.WriteWithin(result, sc, 60)
+		result = gen.WriteWithin(result, sr, 40)
+		return gen.WriteWithin(result, sc, 30)
+	})
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/treethought/impression-frame/contract"
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/store"
+)
+
+func TestCheckFrameURL(t *testing.T) {
+	BASE_URL = testBaseURL
+	cases := []struct {
+		signed string
+		ok     bool
+	}{
+		{testBaseURL, true},
+		{testBaseURL + "/", true},
+		{testBaseURL + "/generate?session=abc", true},
+		// buttons with a target post somewhere other than the frame
+		{testBaseURL + "/mint?session=abc&chain=base", true},
+		{"HTTPS://FRAME.EXAMPLE.COM/start", true},
+		{"http://frame.example.com/start", false},
+		{"https://frame.example.com:8443/start", false},
+		{"https://evil.example.com/start", false},
+		{"https://frame.example.com.evil.com/start", false},
+		{"https://evil.com/https://frame.example.com", false},
+		{"/start", false},
+		{"", false},
+		{"https://frame.example.com/%zz", false},
+	}
+	for _, c := range cases {
+		err := checkFrameURL(c.signed)
+		if c.ok && err != nil {
+			t.Errorf("checkFrameURL(%q) = %v, want ok", c.signed, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("checkFrameURL(%q) passed", c.signed)
+		}
+	}
+}
+
+func TestGetSignaturePacketChecksFrameURL(t *testing.T) {
+	ts := newTestServer(t, contract.Chain{Name: "sim"})
+	cases := []struct {
+		signed string
+		ok     bool
+	}{
+		// the chop frame's History button posts to /history
+		{testBaseURL + "/generate?session=abc", true},
+		{"https://other-frame.example.com/generate", false},
+	}
+	for i, c := range cases {
+		messageBytes := fmt.Sprintf("signed-%d", i)
+		ts.client.AddMessage(messageBytes, fc.ValidatedMessage{
+			Valid:       true,
+			FID:         testFID,
+			URL:         c.signed,
+			ButtonIndex: 4,
+			MessageHash: fmt.Sprintf("0x%040x", i),
+		})
+		var packet fc.SignaturePacket
+		packet.TrustedData.MessageBytes = messageBytes
+		body, err := json.Marshal(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/history?session=abc", bytes.NewReader(body))
+		got, err := ts.getSignaturePacket(req)
+		if c.ok && (err != nil || got.UntrustedData.FID != testFID) {
+			t.Errorf("action signed for %s: %+v, %v", c.signed, got.UntrustedData, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("accepted an action signed for %s", c.signed)
+		}
+	}
+}
+
+func TestNoChainsHidesMinting(t *testing.T) {
+	ts := newTestServer(t, contract.Chain{Name: "sim"})
+	chains, err := contract.NewRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.chains = chains
+	session, _, err := ts.saveResult(context.Background(), testFID, testImage(), store.Session{Transform: "pfp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	ts.renderHistory(rec, store.Session{ID: session})
+	if strings.Contains(rec.Body.String(), "/mint?") {
+		t.Error("history offers minting without any chains")
+	}
+
+	next := ts.post(t, ts.handleMint, fmt.Sprintf("%s/mint?session=%s", testBaseURL, session), testFID, 4)
+	if want := fmt.Sprintf("%s/history?session=%s", testBaseURL, session); next != want {
+		t.Errorf("/mint posts to %s, want %s", next, want)
+	}
+}
+
+func TestGenerateDoesNotMint(t *testing.T) {
+	ts := newTestServer(t, contract.Chain{Name: "sim"})
+	session, _, err := ts.saveResult(context.Background(), testFID, testImage(), store.Session{Transform: "pfp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fourth button of the start frame is Fractal
+	next := ts.post(t, ts.handleGenerate, fmt.Sprintf("%s/generate?session=%s", testBaseURL, session), testFID, 4)
+	if !strings.HasPrefix(next, testBaseURL+"/generate?session=") {
+		t.Errorf("/generate posts to %s, want the chop frame", next)
+	}
+	if _, ok := ts.mints.Claim(); ok {
+		t.Error("/generate queued a mint")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	ts := newTestServer(t, contract.Chain{Name: "sim"})
+	const fid = 4343
+	ctx := context.Background()
+	fc.Cache.Set(fid, "https://example.com/old.png", testImage())
+	root, _, err := ts.saveResult(ctx, fid, testImage(), store.Session{Transform: "pfp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _, err := ts.saveResult(ctx, fid, testImage(), store.Session{Parent: root, Transform: "slice", Seed: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the PFP changing before the mint doesn't change the source
+	fc.Cache.Set(fid, "https://example.com/new.png", testImage())
+	user := &fc.User{FID: fid, PfpUrl: "https://example.com/new.png"}
+	prov := ts.provenance(user, session)
+	if prov.SourcePFP != "https://example.com/old.png" {
+		t.Errorf("SourcePFP = %s, want the PFP at generation", prov.SourcePFP)
+	}
+	if prov.Truncated || prov.SourceHash == "" || len(prov.Transforms) != 1 || prov.Transforms[0].Seed != 7 {
+		t.Errorf("provenance = %+v, want the whole chain from the PFP", prov)
+	}
+
+	// evicting the root truncates the ancestry
+	sessions, err := store.NewSessionIndex("", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.sessions = sessions
+	root, _, err = ts.saveResult(ctx, fid, testImage(), store.Session{Transform: "pfp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _, err = ts.saveResult(ctx, fid, testImage(), store.Session{Parent: root, Transform: "slice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.sessions.Get(fid, root); err == nil {
+		t.Fatal("root wasn't evicted")
+	}
+	prov = ts.provenance(user, session)
+	if !prov.Truncated || prov.SourceHash != "" || len(prov.Transforms) != 1 {
+		t.Errorf("provenance = %+v, want the surviving transform marked truncated", prov)
+	}
+	if prov.SourcePFP != "https://example.com/new.png" {
+		t.Errorf("SourcePFP = %s, want the PFP at generation", prov.SourcePFP)
+	}
+	if prov := ts.provenance(user, "evicted"); !prov.Truncated {
+		t.Error("provenance of an unindexed session isn't marked truncated")
+	}
+}
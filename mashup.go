@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/gen"
+	"github.com/treethought/impression-frame/store"
+)
+
+var mashups = []gen.MashFunc{
+	gen.MashRecombine,
+	gen.MashBlend,
+	gen.MashInterleave,
+	gen.MashPaletteSwap,
+}
+
+// handleMashup asks for the username to mash the user's PFP with.
+func (s *server) handleMashup(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	user, err := s.fc.GetUser(r.Context(), packet.UntrustedData.FID)
+	if err != nil {
+		log.Println("failed to get user: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	frame := fc.Frame{
+		FrameV:         "vNext",
+		Image:          user.PfpUrl,
+		PostURL:        fmt.Sprintf("%s/mashup/generate", BASE_URL),
+		InputTextLabel: "Username to mash with",
+		Buttons:        mashupButtons(),
+	}
+	frame.Render(w)
+}
+
+func mashupButtons() []fc.Button {
+	return []fc.Button{
+		{
+			Label:  []byte("Recombine"),
+			Action: fc.ActionPOST,
+		},
+		{
+			Label:  []byte("Blend"),
+			Action: fc.ActionPOST,
+		},
+		{
+			Label:  []byte("Interleave"),
+			Action: fc.ActionPOST,
+		},
+		{
+			Label:  []byte("Palette swap"),
+			Action: fc.ActionPOST,
+		},
+	}
+}
+
+// handleMashupGenerate resolves the typed username and combines both PFPs.
+func (s *server) handleMashupGenerate(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	fid := packet.UntrustedData.FID
+	retry := fmt.Sprintf("%s/mashup", BASE_URL)
+
+	name := strings.TrimPrefix(strings.TrimSpace(packet.UntrustedData.InputText), "@")
+	if name == "" {
+		s.renderError(w, r, fid, retry, "Type a username", "to mash with")
+		return
+	}
+
+	other, err := fc.GetUserName(r.Context(), s.fc, name, fid)
+	if errors.Is(err, fc.ErrNotFound) {
+		s.renderError(w, r, fid, retry, fmt.Sprintf("@%s", name), "not found")
+		return
+	}
+	if errors.Is(err, fc.ErrRateLimited) {
+		s.renderError(w, r, fid, retry, "Too many lookups", "try again soon")
+		return
+	}
+	if err != nil {
+		log.Println("failed to look up user: ", err)
+		s.renderError(w, r, fid, retry, "Lookup failed", "try again")
+		return
+	}
+
+	img, err := fc.GetOrLoadPFP(r.Context(), s.fc, fid)
+	if err != nil {
+		log.Println("failed to load pfp: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	otherImg, err := fc.GetOrLoadPFP(r.Context(), s.fc, other.FID)
+	if err != nil {
+		log.Println("failed to load pfp: ", err)
+		s.renderError(w, r, fid, retry, fmt.Sprintf("@%s's PFP", other.Username), "could not be loaded")
+		return
+	}
+
+	mode := 0
+	if idx := packet.UntrustedData.ButtonIndex; idx >= 1 && idx <= len(mashups) {
+		mode = idx - 1
+	}
+	mash := mashups[mode]
+	log.Printf("mashing fid %d with @%s", fid, other.Username)
+	result, err := mash(img, otherImg)
+	if err != nil {
+		log.Println("failed to mash: ", err)
+		s.renderError(w, r, fid, retry, fmt.Sprintf("Can't mash with @%s's PFP", other.Username), "try another mode")
+		return
+	}
+
+	id, imgUrl, err := s.saveResult(r.Context(), fid, result, store.Session{
+		Transform: "mashup",
+		Params: map[string]string{
+			"mode":     string(mashupButtons()[mode].Label),
+			"with":     other.Username,
+			"with_fid": fmt.Sprint(other.FID),
+		},
+	})
+	if err != nil {
+		log.Println("failed to save result: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	frame := fc.Frame{
+		FrameV:  "vNext",
+		Image:   imgUrl,
+		PostURL: retry,
+		Buttons: []fc.Button{
+			{
+				Label:  []byte("New mashup"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("Keep chopping"),
+				Action: fc.ActionPOST,
+				Target: []byte(fmt.Sprintf("%s/generate?session=%s", BASE_URL, id)),
+			},
+		},
+	}
+	frame.Render(w)
+}
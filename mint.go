@@ -0,0 +1,686 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+	"github.com/treethought/impression-frame/contract"
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/gen"
+	"github.com/treethought/impression-frame/store"
+)
+
+var (
+	MINT_QUEUE   = os.Getenv("MINT_QUEUE")
+	MINT_WORKERS = os.Getenv("MINT_WORKERS")
+)
+
+const (
+	defaultMintWorkers = 2
+	maxMintAttempts    = 5
+	mintRetryDelay     = 10 * time.Second
+	// mintPollInterval is how often workers look for due jobs and check
+	// submitted transactions.
+	mintPollInterval = 2 * time.Second
+)
+
+func newMintQueue() *store.MintQueue {
+	path := MINT_QUEUE
+	if path == "" {
+		path = "tmp/mint_jobs.json"
+	}
+	if STORE == "memory" {
+		path = ""
+	}
+	q, err := store.NewMintQueue(path)
+	if err != nil {
+		log.Fatal("failed to load mint queue: ", err)
+	}
+	return q
+}
+
+func newResolver() contract.Resolver {
+	r, err := contract.NewResolver()
+	if err != nil {
+		log.Fatal("failed to create ENS resolver: ", err)
+	}
+	return r
+}
+
+// maxChainButtons is how many chains a frame can offer.
+const maxChainButtons = 4
+
+func loadChains() *contract.Registry {
+	chains, err := contract.LoadRegistry()
+	if err != nil {
+		log.Fatal("failed to load chains: ", err)
+	}
+	if chains.Empty() {
+		log.Println("no chains configured, so minting is disabled")
+	}
+	if n := len(chains.Chains()); n > maxChainButtons {
+		log.Fatalf("%d chains configured, but at most %d can be offered", n, maxChainButtons)
+	}
+	return chains
+}
+
+func mintWorkers() int {
+	if MINT_WORKERS == "" {
+		return defaultMintWorkers
+	}
+	n, err := strconv.Atoi(MINT_WORKERS)
+	if err != nil {
+		log.Fatal("invalid MINT_WORKERS: ", err)
+	}
+	return n
+}
+
+// errUnknownChain is returned for jobs on a chain no longer configured.
+var errUnknownChain = errors.New("unknown chain")
+
+func (s *server) getContract(ctx context.Context, name string) (*contract.Contract, error) {
+	chain, ok := s.chains.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", errUnknownChain, name)
+	}
+	s.contractMu.Lock()
+	defer s.contractMu.Unlock()
+	if c := s.contracts[chain.Name]; c != nil {
+		return c, nil
+	}
+	c, err := contract.NewContract(ctx, chain)
+	if err != nil {
+		return nil, err
+	}
+	if s.contracts == nil {
+		s.contracts = make(map[string]*contract.Contract)
+	}
+	s.contracts[chain.Name] = c
+	return c, nil
+}
+
+// handleMint asks which chain, if there is a choice, and then which
+// address to mint the session in the query to.
+func (s *server) handleMint(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	session, err := s.getSession(packet.UntrustedData.FID, r.URL.Query().Get("session"))
+	if err != nil {
+		log.Println("failed to get session: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.chains.Empty() {
+		retry := fmt.Sprintf("%s/history?session=%s", BASE_URL, session.ID)
+		s.renderError(w, r, packet.UntrustedData.FID, retry, "Minting is unavailable")
+		return
+	}
+	chain := r.URL.Query().Get("chain")
+	if _, ok := s.chains.Get(chain); !ok {
+		log.Println("unknown chain: ", chain)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.renderMint(w, r, packet, session.ID, chain)
+}
+
+// renderMint starts minting session on chain, offering a choice of chains
+// first if chain is empty and there is more than one.
+func (s *server) renderMint(w http.ResponseWriter, r *http.Request, packet fc.SignaturePacket, session, chain string) {
+	fid := packet.UntrustedData.FID
+	if err := s.checkAllowed(r.Context(), packet); err != nil {
+		s.renderMintRefused(w, r, fid, fmt.Sprintf("%s/history?session=%s", BASE_URL, session), err)
+		return
+	}
+	chains := s.chains.Chains()
+	if chain != "" || len(chains) == 1 {
+		c, _ := s.chains.Get(chain)
+		s.renderMintTo(w, r, packet, session, c.Name)
+		return
+	}
+
+	var buttons []fc.Button
+	for _, c := range chains {
+		buttons = append(buttons, fc.Button{
+			Label:  []byte(c.Label),
+			Action: fc.ActionPOST,
+			Target: []byte(fmt.Sprintf("%s/mint?session=%s&chain=%s", BASE_URL, session, url.QueryEscape(c.Name))),
+		})
+	}
+	imgUrl, err := s.textImage(r.Context(), fid, "Mint on which chain?")
+	if err != nil {
+		log.Println("failed to save chain image: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	frame := fc.Frame{
+		FrameV:  "vNext",
+		Image:   imgUrl,
+		PostURL: fmt.Sprintf("%s/mint?session=%s", BASE_URL, session),
+		Buttons: buttons,
+	}
+	frame.Render(w)
+}
+
+// maxAddressButtons leaves a button for minting to a typed address.
+const maxAddressButtons = 3
+
+// mintAddresses returns the verified EVM addresses of fid offered as
+// buttons.
+func (s *server) mintAddresses(ctx context.Context, fid uint64) ([]common.Address, error) {
+	user, err := s.fc.GetUser(ctx, fid)
+	if err != nil {
+		return nil, err
+	}
+	addrs := contract.EVMAddresses(user.Verfications)
+	if len(addrs) > maxAddressButtons {
+		addrs = addrs[:maxAddressButtons]
+	}
+	return addrs, nil
+}
+
+// renderMintTo renders a button for each of the user's verified addresses
+// and one to mint to a typed address or ENS name. Users without any are
+// asked to verify one.
+func (s *server) renderMintTo(w http.ResponseWriter, r *http.Request, packet fc.SignaturePacket, session, chain string) {
+	fid := packet.UntrustedData.FID
+	addrs, err := s.mintAddresses(r.Context(), fid)
+	if err != nil {
+		log.Println("failed to get user: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	query := fmt.Sprintf("session=%s&chain=%s", session, url.QueryEscape(chain))
+	postURL := fmt.Sprintf("%s/mint/to?%s", BASE_URL, query)
+	var lines []string
+	var buttons []fc.Button
+	if len(addrs) == 0 {
+		lines = []string{
+			"Verify an address to mint",
+			"Add an Ethereum address to your",
+			"Farcaster profile, or type one below",
+		}
+		buttons = []fc.Button{
+			{
+				Label:  []byte("Mint to typed"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("I've verified one"),
+				Action: fc.ActionPOST,
+				Target: []byte(fmt.Sprintf("%s/mint?%s", BASE_URL, query)),
+			},
+			{
+				Label:  []byte("History"),
+				Action: fc.ActionPOST,
+				Target: []byte(fmt.Sprintf("%s/history?session=%s", BASE_URL, session)),
+			},
+		}
+	} else {
+		lines = []string{"Mint to which address?"}
+		if len(s.chains.Chains()) > 1 {
+			c, _ := s.chains.Get(chain)
+			lines[0] = fmt.Sprintf("Mint on %s to which address?", c.Label)
+		}
+		for _, addr := range addrs {
+			lines = append(lines, addr.Hex())
+			buttons = append(buttons, fc.Button{
+				Label:  []byte(contract.ShortAddress(addr)),
+				Action: fc.ActionPOST,
+			})
+		}
+		buttons = append(buttons, fc.Button{
+			Label:  []byte("Mint to typed"),
+			Action: fc.ActionPOST,
+		})
+	}
+
+	imgUrl, err := s.textImage(r.Context(), fid, lines...)
+	if err != nil {
+		log.Println("failed to save address image: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	frame := fc.Frame{
+		FrameV:         "vNext",
+		Image:          imgUrl,
+		PostURL:        postURL,
+		Buttons:        buttons,
+		InputTextLabel: "0x address or ENS name",
+	}
+	frame.Render(w)
+}
+
+// handleMintTo queues a mint of the session in the query to the address
+// whose button was pressed, or to the typed address.
+func (s *server) handleMintTo(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	fid := packet.UntrustedData.FID
+	session, err := s.getSession(fid, r.URL.Query().Get("session"))
+	if err != nil {
+		log.Println("failed to get session: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	chain, ok := s.chains.Get(r.URL.Query().Get("chain"))
+	if !ok {
+		log.Println("unknown chain: ", r.URL.Query().Get("chain"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	retry := fmt.Sprintf("%s/mint?session=%s&chain=%s", BASE_URL, session.ID, url.QueryEscape(chain.Name))
+	if err := s.checkAllowed(r.Context(), packet); err != nil {
+		s.renderMintRefused(w, r, fid, fmt.Sprintf("%s/history?session=%s", BASE_URL, session.ID), err)
+		return
+	}
+
+	addrs, err := s.mintAddresses(r.Context(), fid)
+	if err != nil {
+		log.Println("failed to get user: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if i := packet.UntrustedData.ButtonIndex; i >= 1 && i <= len(addrs) {
+		s.enqueueMint(w, r, packet, session, chain.Name, addrs[i-1])
+		return
+	}
+
+	text := strings.TrimSpace(packet.UntrustedData.InputText)
+	if text == "" {
+		s.renderError(w, r, fid, retry, "Type an address", "or ENS name to mint to")
+		return
+	}
+	to, err := contract.ParseRecipient(r.Context(), s.resolver, text)
+	switch {
+	case errors.Is(err, contract.ErrInvalidAddress):
+		s.renderError(w, r, fid, retry, fmt.Sprintf("%q is not an", text), "address or ENS name")
+		return
+	case errors.Is(err, contract.ErrBadChecksum):
+		s.renderError(w, r, fid, retry, "That address has a typo", "Check it and try again")
+		return
+	case errors.Is(err, contract.ErrUnresolved):
+		s.renderError(w, r, fid, retry, fmt.Sprintf("%s has no address", text))
+		return
+	case errors.Is(err, contract.ErrNoResolver):
+		s.renderError(w, r, fid, retry, "ENS names aren't supported", "Type an 0x address")
+		return
+	case err != nil:
+		log.Println("failed to resolve recipient: ", err)
+		s.renderError(w, r, fid, retry, fmt.Sprintf("Couldn't resolve %s", text))
+		return
+	}
+	s.enqueueMint(w, r, packet, session, chain.Name, to)
+}
+
+// enqueueMint queues a mint of session on chain to to and renders its
+// status, so the frame responds before anything is pinned or sent.
+func (s *server) enqueueMint(w http.ResponseWriter, r *http.Request, packet fc.SignaturePacket, session store.Session, chain string, to common.Address) {
+	fid := packet.UntrustedData.FID
+	job, err := s.mints.Enqueue(store.MintJob{
+		ID:          uuid.New().String(),
+		FID:         fid,
+		Session:     session.ID,
+		Chain:       chain,
+		Image:       session.Key.ID,
+		MessageHash: packet.UntrustedData.MessageHash,
+		To:          to.Hex(),
+	}, s.policy.quota)
+	if err != nil {
+		s.renderMintRefused(w, r, fid, fmt.Sprintf("%s/history?session=%s", BASE_URL, session.ID), err)
+		return
+	}
+	log.Printf("queued mint %s of session %s on %s to %s", job.ID, session.ID, chain, job.To)
+	s.renderMintStatus(w, r, job)
+}
+
+// renderMintRefused explains why the policy refused a mint, with a button
+// posting to retryURL.
+func (s *server) renderMintRefused(w http.ResponseWriter, r *http.Request, fid uint64, retryURL string, err error) {
+	lines := policyMessage(err)
+	if lines == nil {
+		log.Println("failed to queue mint: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("refused mint by %d: %v", fid, err)
+	s.renderError(w, r, fid, retryURL, lines...)
+}
+
+// handleMintStatus renders the status of the mint job in the query.
+func (s *server) handleMintStatus(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	job, err := s.mints.Get(packet.UntrustedData.FID, r.URL.Query().Get("job"))
+	if err != nil {
+		log.Println("failed to get mint job: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.renderMintStatus(w, r, job)
+}
+
+func (s *server) renderMintStatus(w http.ResponseWriter, r *http.Request, job store.MintJob) {
+	var lines []string
+	var buttons []fc.Button
+	switch job.Status {
+	case store.MintConfirmed:
+		chain, _ := s.chains.Get(job.Chain)
+		lines = []string{"Minted!"}
+		if chain.Label != "" {
+			lines[0] = fmt.Sprintf("Minted on %s!", chain.Label)
+		}
+		if job.Mint.TokenID != "" {
+			lines = append(lines, fmt.Sprintf("Token #%s", job.Mint.TokenID))
+		}
+		if link := chain.TxURL(job.TxHash); link != "" {
+			buttons = append(buttons, fc.Button{
+				Label:  []byte("View"),
+				Action: fc.ActionLink,
+				Target: []byte(link),
+			})
+		}
+		if chain.Editions() {
+			buttons = append(buttons, fc.Button{
+				Label:  []byte("Share"),
+				Action: fc.ActionLink,
+				Target: []byte(shareURL(job)),
+			})
+		}
+		buttons = append(buttons, fc.Button{
+			Label:  []byte("Start over"),
+			Action: fc.ActionPOST,
+			Target: []byte(fmt.Sprintf("%s/start", BASE_URL)),
+		})
+	case store.MintFailed:
+		retry := fmt.Sprintf("%s/mint?session=%s&chain=%s", BASE_URL, job.Session, url.QueryEscape(job.Chain))
+		back := fc.Button{
+			Label:  []byte("History"),
+			Action: fc.ActionPOST,
+			Target: []byte(fmt.Sprintf("%s/history?session=%s", BASE_URL, job.Session)),
+		}
+		if job.CopyOf != "" {
+			// the session of a copy is the original minter's
+			retry = fmt.Sprintf("%s/edition/mint?job=%s", BASE_URL, job.CopyOf)
+			back = fc.Button{
+				Label:  []byte("Back"),
+				Action: fc.ActionPOST,
+				Target: []byte(fmt.Sprintf("%s/edition?job=%s", BASE_URL, job.CopyOf)),
+			}
+		}
+		lines = []string{"Mint failed", job.Error}
+		buttons = []fc.Button{
+			{
+				Label:  []byte("Try again"),
+				Action: fc.ActionPOST,
+				Target: []byte(retry),
+			},
+			back,
+		}
+	default:
+		lines = []string{"Minting...", mintStage(job)}
+		if job.Error != "" {
+			lines = append(lines, fmt.Sprintf("retrying after: %s", job.Error))
+		}
+		buttons = []fc.Button{
+			{
+				Label:  []byte("Refresh"),
+				Action: fc.ActionPOST,
+			},
+		}
+	}
+
+	imgUrl, err := s.textImage(r.Context(), job.FID, lines...)
+	if err != nil {
+		log.Println("failed to save mint status image: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	frame := fc.Frame{
+		FrameV:  "vNext",
+		Image:   imgUrl,
+		PostURL: fmt.Sprintf("%s/mint/status?job=%s", BASE_URL, job.ID),
+		Buttons: buttons,
+	}
+	frame.Render(w)
+}
+
+func mintStage(job store.MintJob) string {
+	switch job.Status {
+	case store.MintPinned:
+		return "pinned to IPFS, sending transaction"
+	case store.MintSubmitted:
+		return "waiting for confirmation"
+	default:
+		return "pinning to IPFS"
+	}
+}
+
+// textImage stores an image of lines without adding a session, so polling
+// the same status reuses one image.
+func (s *server) textImage(ctx context.Context, fid uint64, lines ...string) (string, error) {
+	return s.storeImage(ctx, fid, gen.TextImage(600, lines...))
+}
+
+// storeImage stores img by its content hash without adding a session, for
+// frames that aren't results to chop, and returns its URL.
+func (s *server) storeImage(ctx context.Context, fid uint64, img image.Image) (string, error) {
+	key := store.ContentKey(fid, img)
+	if ok, err := s.store.Has(ctx, key); err != nil || !ok {
+		if err := store.PutImage(ctx, s.store, key, img); err != nil {
+			return "", err
+		}
+	}
+	return s.store.URL(key), nil
+}
+
+// runMintWorkers starts n workers processing the mint queue until ctx is
+// done. Jobs left unfinished by a restart are picked up again.
+func (s *server) runMintWorkers(ctx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		go s.mintWorker(ctx)
+	}
+}
+
+func (s *server) mintWorker(ctx context.Context) {
+	ticker := time.NewTicker(mintPollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			job, ok := s.mints.Claim()
+			if !ok {
+				break
+			}
+			s.processMint(ctx, job)
+			s.mints.Release(job.ID)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.mints.Ready():
+		case <-ticker.C:
+		}
+	}
+}
+
+// processMint advances job until it is done, has to wait for its
+// transaction or fails a stage.
+func (s *server) processMint(ctx context.Context, job store.MintJob) {
+	for !job.Status.Done() {
+		err := s.advanceMint(ctx, &job)
+		if errors.Is(err, contract.ErrPending) {
+			job.NextAttempt = time.Now().Add(mintPollInterval)
+		} else if err != nil {
+			job.Attempts++
+			job.Error = err.Error()
+			log.Printf("mint %s failed while %s (attempt %d): %v", job.ID, job.Status, job.Attempts, err)
+			if job.Attempts >= maxMintAttempts || permanent(err) {
+				job.Status = store.MintFailed
+			} else {
+				job.NextAttempt = time.Now().Add(mintRetryDelay * time.Duration(job.Attempts))
+			}
+		} else {
+			job.Error = ""
+			job.NextAttempt = time.Time{}
+		}
+		if err := s.mints.Update(job); err != nil {
+			log.Println("failed to save mint job: ", err)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// advanceMint runs the next stage of job.
+func (s *server) advanceMint(ctx context.Context, job *store.MintJob) error {
+	c, err := s.getContract(ctx, job.Chain)
+	if err != nil {
+		return err
+	}
+
+	switch job.Status {
+	case store.MintPending:
+		if job.CopyOf != "" {
+			// copies mint the original's metadata
+			job.Status = store.MintPinned
+			break
+		}
+		user, err := s.fc.GetUser(ctx, job.FID)
+		if err != nil {
+			return err
+		}
+		session, err := s.getSession(job.FID, job.Session)
+		if err != nil {
+			return err
+		}
+		img, err := store.GetImage(ctx, s.store, session.Key)
+		if err != nil {
+			return err
+		}
+		if job.To == "" {
+			addrs := contract.EVMAddresses(user.Verfications)
+			if len(addrs) == 0 {
+				return contract.ErrNoAddress
+			}
+			job.To = addrs[0].Hex()
+		}
+		pinned, err := c.Pin(ctx, img, user, s.provenance(user, job.Session))
+		if err != nil {
+			return err
+		}
+		job.MetadataURI = pinned.MetadataURI
+		job.Metadata = pinned.Data
+		job.Status = store.MintPinned
+
+	case store.MintPinned:
+		req := contract.MintRequest{To: common.HexToAddress(job.To), URI: job.MetadataURI, Metadata: job.Metadata}
+		if job.TokenID != "" {
+			id, ok := new(big.Int).SetString(job.TokenID, 10)
+			if !ok {
+				return fmt.Errorf("invalid token id %q", job.TokenID)
+			}
+			req.TokenID = id
+		}
+		tx, err := c.Minter.Submit(ctx, req)
+		if err != nil {
+			return err
+		}
+		log.Printf("mint %s sent %s", job.ID, tx.Latest().Hex())
+		setPendingTx(job, tx)
+		job.Status = store.MintSubmitted
+
+	case store.MintSubmitted:
+		tx := pendingTx(job)
+		minted, err := c.Minter.Confirm(ctx, tx)
+		// a stuck transaction may have been replaced
+		setPendingTx(job, tx)
+		if err != nil {
+			return err
+		}
+		job.TxHash = minted.TxHash.Hex()
+		if minted.URI != "" {
+			job.MetadataURI = minted.URI
+		}
+		log.Printf("minted token %v of %s in %s: %s", minted.TokenID, minted.Contract, job.TxHash, job.MetadataURI)
+		record := &store.MintRecord{
+			ChainID:     minted.ChainID,
+			Contract:    minted.Contract,
+			TxHash:      job.TxHash,
+			MetadataURI: job.MetadataURI,
+		}
+		if minted.TokenID != nil {
+			record.TokenID = minted.TokenID.String()
+		}
+		// the session of a copy is the original's
+		if job.CopyOf == "" {
+			if err := s.sessions.SetMinted(job.FID, job.Session, record); err != nil {
+				log.Println("failed to record mint: ", err)
+			}
+		}
+		job.Mint = record
+		job.Status = store.MintConfirmed
+	}
+	return nil
+}
+
+// permanent reports whether a mint failed in a way retrying won't fix. A
+// timeout is only returned once the transaction can no longer be mined.
+func permanent(err error) bool {
+	return errors.Is(err, contract.ErrReverted) ||
+		errors.Is(err, contract.ErrTimeout) ||
+		errors.Is(err, contract.ErrNoAddress) ||
+		errors.Is(err, contract.ErrNoCopies) ||
+		errors.Is(err, errUnknownChain)
+}
+
+// pendingTx returns the mint transaction of a submitted job.
+func pendingTx(job *store.MintJob) *contract.PendingTx {
+	tx := &contract.PendingTx{SentAt: job.SentAt, BumpedAt: job.BumpedAt}
+	for _, hash := range append(job.Replaced, job.TxHash) {
+		tx.Hashes = append(tx.Hashes, common.HexToHash(hash))
+	}
+	for _, hash := range job.Cancels {
+		tx.Cancels = append(tx.Cancels, common.HexToHash(hash))
+	}
+	return tx
+}
+
+func setPendingTx(job *store.MintJob, tx *contract.PendingTx) {
+	job.Replaced = nil
+	for _, hash := range tx.Hashes[:len(tx.Hashes)-1] {
+		job.Replaced = append(job.Replaced, hash.Hex())
+	}
+	job.TxHash = tx.Latest().Hex()
+	job.Cancels = nil
+	for _, hash := range tx.Cancels {
+		job.Cancels = append(job.Cancels, hash.Hex())
+	}
+	job.SentAt = tx.SentAt
+	job.BumpedAt = tx.BumpedAt
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/treethought/impression-frame/contract"
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/pin"
+	"github.com/treethought/impression-frame/store"
+)
+
+const (
+	testBaseURL = "https://frame.example.com"
+	testFID     = 3
+	testAddress = "0x00000000000000000000000000000000000000aa"
+)
+
+// testServer is a server minting on a simulated chain, with users,
+// messages, pins and images all in memory.
+type testServer struct {
+	*server
+	client *fc.FakeClient
+	pinner *pin.FakePinner
+	minter *contract.SimulatedMinter
+	frames int
+}
+
+func newTestServer(t *testing.T, chain contract.Chain) *testServer {
+	t.Helper()
+	BASE_URL = testBaseURL
+	chain.RPC = contract.SimulatedRPC
+	chains, err := contract.NewRegistry(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, _ = chains.Get(chain.Name)
+	pinner := pin.NewFakePinner("")
+	minter, err := contract.NewSimulatedMinter(chain, pinner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { minter.Close() })
+	mints, err := store.NewMintQueue("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := store.NewSessionIndex("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := &testServer{
+		client: fc.NewFakeClient(fc.User{
+			FID:          testFID,
+			Username:     "alice",
+			Verfications: []string{testAddress},
+		}),
+		pinner: pinner,
+		minter: minter,
+	}
+	ts.server = &server{
+		fc:       ts.client,
+		validate: true,
+		store:    store.NewMemoryStore(testBaseURL + "/results"),
+		sessions: sessions,
+		mints:    mints,
+		policy:   mintPolicy{quota: store.MintQuota{PerFID: 5, Global: 10, Window: time.Hour}},
+		chains:   chains,
+		contracts: map[string]*contract.Contract{
+			chain.Name: {Chain: chain, Minter: minter, Pinner: ts.pinner},
+		},
+	}
+	return ts
+}
+
+var postURLMeta = regexp.MustCompile(`fc:frame:post_url" content="([^"]*)"`)
+
+// post sends a frame action by fid pressing button on the frame at
+// target, returning the post url of the frame rendered in response.
+func (ts *testServer) post(t *testing.T, handler http.HandlerFunc, target string, fid uint64, button int) string {
+	t.Helper()
+	ts.frames++
+	messageBytes := fmt.Sprintf("message-%d", ts.frames)
+	ts.client.AddMessage(messageBytes, fc.ValidatedMessage{
+		Valid:       true,
+		FID:         fid,
+		URL:         target,
+		ButtonIndex: button,
+		MessageHash: fmt.Sprintf("0x%040x", ts.frames),
+		Timestamp:   time.Now(),
+	})
+	return ts.postMessage(t, handler, target, messageBytes)
+}
+
+// postMessage sends the frame action registered as messageBytes to the
+// frame at target, returning the post url of the frame rendered in
+// response.
+func (ts *testServer) postMessage(t *testing.T, handler http.HandlerFunc, target, messageBytes string) string {
+	t.Helper()
+	var packet fc.SignaturePacket
+	packet.UntrustedData.URL = target
+	packet.TrustedData.MessageBytes = messageBytes
+	body, err := json.Marshal(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, u.RequestURI(), bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST %s: status %d", target, rec.Code)
+	}
+	m := postURLMeta.FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatalf("POST %s rendered no frame", target)
+	}
+	return m[1]
+}
+
+// waitForMint runs the mint workers until job id of fid is done.
+func (ts *testServer) waitForMint(t *testing.T, fid uint64, id string) store.MintJob {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts.runMintWorkers(ctx, 1)
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := ts.mints.Get(fid, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status.Done() {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("mint %s didn't finish", id)
+	return store.MintJob{}
+}
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{200, 40, 90, 255}}, image.Point{}, draw.Src)
+	return img
+}
+
+func TestMintEndToEnd(t *testing.T) {
+	for _, chain := range []contract.Chain{
+		{Name: "sim", Standard: contract.StandardERC1155},
+		{Name: "sim", Standard: contract.StandardERC721},
+		{Name: "sim", Standard: contract.StandardERC1155, Mode: contract.ModeClaim},
+	} {
+		t.Run(chain.Standard+"/"+chain.Mode, func(t *testing.T) {
+			ts := newTestServer(t, chain)
+			ctx := context.Background()
+			session, _, err := ts.saveResult(ctx, testFID, testImage(), store.Session{Transform: "pfp"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			next := ts.post(t, ts.handleMint, fmt.Sprintf("%s/mint?session=%s&chain=sim", testBaseURL, session), testFID, 1)
+			want := fmt.Sprintf("%s/mint/to?session=%s&chain=sim", testBaseURL, session)
+			if next != want {
+				t.Fatalf("/mint posts to %s, want %s", next, want)
+			}
+			// the first button mints to the first verified address
+			next = ts.post(t, ts.handleMintTo, next, testFID, 1)
+			status, err := url.Parse(next)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.Path != "/mint/status" {
+				t.Fatalf("/mint/to posts to %s, want the mint status", next)
+			}
+
+			job := ts.waitForMint(t, testFID, status.Query().Get("job"))
+			if job.Status != store.MintConfirmed {
+				t.Fatalf("mint %s: %s", job.Status, job.Error)
+			}
+			if job.To != common.HexToAddress(testAddress).Hex() {
+				t.Errorf("minted to %s, want %s", job.To, testAddress)
+			}
+
+			id, ok := new(big.Int).SetString(job.Mint.TokenID, 10)
+			if !ok {
+				t.Fatalf("invalid token id %q", job.Mint.TokenID)
+			}
+			balance, err := ts.minter.BalanceOf(ctx, common.HexToAddress(testAddress), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance.Int64() != 1 {
+				t.Errorf("balance of token %s is %s, want 1", id, balance)
+			}
+			uri, err := ts.minter.URI(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uri != job.MetadataURI || job.Mint.MetadataURI != uri {
+				t.Errorf("token URI is %s, want %s recorded", uri, job.MetadataURI)
+			}
+			if chain.Mode == contract.ModeClaim && !strings.HasSuffix(uri, "/"+id.String()) {
+				t.Errorf("claimed token URI %s doesn't end in its id", uri)
+			}
+
+			metadata, ok := ts.pinner.Get(strings.TrimPrefix(job.MetadataURI, "ipfs://"))
+			if !ok {
+				t.Fatalf("metadata %s wasn't pinned", job.MetadataURI)
+			}
+			var md contract.Metadata
+			if err := json.Unmarshal(metadata, &md); err != nil {
+				t.Fatal(err)
+			}
+			if md.Name != "alice" {
+				t.Errorf("metadata name = %q", md.Name)
+			}
+			if _, ok := ts.pinner.Get(strings.TrimPrefix(md.Image, "ipfs://")); !ok {
+				t.Errorf("image %s wasn't pinned", md.Image)
+			}
+
+			minted, err := ts.sessions.Get(testFID, session)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !minted.Minted || minted.Mint.TxHash != job.TxHash {
+				t.Errorf("session = %+v, want it marked minted in %s", minted, job.TxHash)
+			}
+
+			if chain.Mode != contract.ModeClaim {
+				return
+			}
+			// copies are claimed under the same token
+			bob := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+			ts.client.AddUser(fc.User{FID: testFID + 1, Username: "bob", Verfications: []string{bob.Hex()}})
+			next = ts.post(t, ts.handleEditionMint, fmt.Sprintf("%s/edition/mint?job=%s", testBaseURL, job.ID), testFID+1, 1)
+			status, err = url.Parse(next)
+			if err != nil {
+				t.Fatal(err)
+			}
+			copied := ts.waitForMint(t, testFID+1, status.Query().Get("job"))
+			if copied.Status != store.MintConfirmed {
+				t.Fatalf("copy %s: %s", copied.Status, copied.Error)
+			}
+			if copied.Mint.TokenID != job.Mint.TokenID || copied.MetadataURI != uri {
+				t.Errorf("copy minted token %s at %s, want %s at %s", copied.Mint.TokenID, copied.MetadataURI, id, uri)
+			}
+			balance, err = ts.minter.BalanceOf(ctx, bob, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance.Int64() != 1 {
+				t.Errorf("balance of the copy of token %s is %s, want 1", id, balance)
+			}
+		})
+	}
+}
+
+func TestMintRefusesUnvalidatedMessages(t *testing.T) {
+	ts := newTestServer(t, contract.Chain{Name: "sim"})
+	ts.validate = false
+	session, _, err := ts.saveResult(context.Background(), testFID, testImage(), store.Session{Transform: "pfp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := fmt.Sprintf("%s/mint/to?session=%s&chain=sim", testBaseURL, session)
+	next := ts.post(t, ts.handleMintTo, target, testFID, 1)
+	if strings.Contains(next, "/mint/status") {
+		t.Fatalf("queued a mint from an unvalidated message: %s", next)
+	}
+	if _, ok := ts.mints.Claim(); ok {
+		t.Error("queued a mint from an unvalidated message")
+	}
+}
+
+func TestFailedCopyLinksToEdition(t *testing.T) {
+	ts := newTestServer(t, contract.Chain{Name: "sim", Mode: contract.ModeOpenEdition})
+	job := store.MintJob{
+		ID:      "copy",
+		FID:     testFID,
+		Session: "original-session",
+		Chain:   "sim",
+		CopyOf:  "original",
+		Status:  store.MintFailed,
+		Error:   "reverted",
+	}
+	rec := httptest.NewRecorder()
+	ts.renderMintStatus(rec, httptest.NewRequest(http.MethodPost, "/mint/status?job=copy", nil), job)
+	body := rec.Body.String()
+	for _, want := range []string{
+		testBaseURL + "/edition/mint?job=original",
+		testBaseURL + "/edition?job=original",
+	} {
+		if !strings.Contains(body, `content="`+want+`"`) {
+			t.Errorf("failed copy frame doesn't link to %s", want)
+		}
+	}
+	if strings.Contains(body, "/history?session=original-session") {
+		t.Error("failed copy frame links to the original minter's history")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/gen"
+	"github.com/treethought/impression-frame/store"
+)
+
+const (
+	networkLimit   = 64
+	networkWorkers = 8
+	networkSize    = 800
+	// networkWait bounds the PFP fetches of a collage so the frame responds
+	// in time; it is built from whatever has loaded by then.
+	networkWait = 3 * time.Second
+
+	mosaicCells    = 40
+	mosaicTileSize = 20
+	mosaicIndexTTL = 10 * time.Minute
+)
+
+// mosaic holds the tile index built from the PFP disk cache. It is
+// rebuilt in the background every mosaicIndexTTL as the cache fills up,
+// so requests never wait on it.
+var mosaic struct {
+	sync.Mutex
+	idx *gen.TileIndex
+}
+
+// runMosaicIndexer rebuilds the mosaic index now and then every
+// mosaicIndexTTL until ctx is done.
+func runMosaicIndexer(ctx context.Context) {
+	ticker := time.NewTicker(mosaicIndexTTL)
+	defer ticker.Stop()
+	for {
+		if err := buildMosaicIndex(); err != nil {
+			log.Println("failed to build mosaic index: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// buildMosaicIndex scales each cached PFP to a tile as it is decoded, so
+// only the tiles are kept in memory.
+func buildMosaicIndex() error {
+	b := gen.NewTileIndexBuilder(mosaicTileSize)
+	if err := fc.EachCachedPFP(b.Add); err != nil {
+		return err
+	}
+	idx := b.Build()
+	log.Printf("built mosaic index from %d cached pfps", idx.Len())
+	mosaic.Lock()
+	mosaic.idx = idx
+	mosaic.Unlock()
+	return nil
+}
+
+// mosaicIndex returns the latest mosaic index, or nil before the first is
+// built.
+func mosaicIndex() *gen.TileIndex {
+	mosaic.Lock()
+	defer mosaic.Unlock()
+	return mosaic.idx
+}
+
+// handleNetwork lets the user pick whose PFPs to build a collage from.
+func (s *server) handleNetwork(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	user, err := s.fc.GetUser(r.Context(), packet.UntrustedData.FID)
+	if err != nil {
+		log.Println("failed to get user: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	frame := fc.Frame{
+		FrameV:         "vNext",
+		Image:          user.PfpUrl,
+		PostURL:        fmt.Sprintf("%s/network/generate", BASE_URL),
+		InputTextLabel: "Layout: grid, spiral, circles, weighted",
+		Buttons: []fc.Button{
+			{
+				Label:  []byte("Followers"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("Following"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("Mosaic"),
+				Action: fc.ActionPOST,
+			},
+		},
+	}
+	frame.Render(w)
+}
+
+// handleNetworkGenerate builds a collage of the user's followers or
+// following around their own PFP, or rebuilds their PFP as a photomosaic
+// of cached PFPs.
+func (s *server) handleNetworkGenerate(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	fid := packet.UntrustedData.FID
+	retry := fmt.Sprintf("%s/network", BASE_URL)
+
+	var users []fc.User
+	params := map[string]string{}
+	switch packet.UntrustedData.ButtonIndex {
+	case 2:
+		params["source"] = "following"
+		users, err = s.fc.GetFollowing(r.Context(), fid, networkLimit)
+	default:
+		params["source"] = "followers"
+		users, err = s.fc.GetFollowers(r.Context(), fid, networkLimit)
+	}
+	if err != nil {
+		log.Println("failed to get network: ", err)
+		s.renderError(w, r, fid, retry, "Could not load", "your network")
+		return
+	}
+
+	imgs := fc.LoadPFPs(r.Context(), users, networkWorkers, networkWait)
+	if len(imgs) == 0 {
+		s.renderError(w, r, fid, retry, "No PFPs found", "in your network")
+		return
+	}
+
+	// users are ranked by interaction, so weight by position
+	tiles := make([]gen.Tile, len(imgs))
+	for i, img := range imgs {
+		tiles[i] = gen.Tile{
+			Image:  img,
+			Weight: float64(len(imgs)-i) / float64(len(imgs)),
+		}
+	}
+
+	pfp, err := fc.GetOrLoadPFP(r.Context(), s.fc, fid)
+	if err != nil {
+		log.Println("failed to load pfp: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	node := store.Session{Transform: "network", Params: params}
+	var result image.Image
+	if packet.UntrustedData.ButtonIndex == 3 {
+		// loading followers above warms the cache for later rebuilds
+		idx := mosaicIndex()
+		if idx == nil {
+			s.renderError(w, r, fid, retry, "Mosaic tiles are loading", "try again soon")
+			return
+		}
+		if idx.Len() == 0 {
+			s.renderError(w, r, fid, retry, "No cached PFPs", "to build from")
+			return
+		}
+		maxReuse := 2 * int(math.Ceil(float64(mosaicCells*mosaicCells)/float64(idx.Len())))
+		log.Printf("building mosaic from %d tiles for fid %d", idx.Len(), fid)
+		params["layout"] = "mosaic"
+		params["tiles"] = fmt.Sprint(idx.Len())
+		node.Seed = time.Now().UnixNano()
+		result = gen.Photomosaic(pfp, idx, mosaicCells, maxReuse, node.Seed)
+	} else {
+		layout := gen.ParseLayout(packet.UntrustedData.InputText)
+		log.Printf("building %s collage of %d pfps for fid %d", layout, len(tiles), fid)
+		params["layout"] = layout.String()
+		params["tiles"] = fmt.Sprint(len(tiles))
+		result = gen.Collage(pfp, tiles, layout, networkSize)
+	}
+
+	id, imgUrl, err := s.saveResult(r.Context(), fid, result, node)
+	if err != nil {
+		log.Println("failed to save result: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	frame := fc.Frame{
+		FrameV:  "vNext",
+		Image:   imgUrl,
+		PostURL: retry,
+		Buttons: []fc.Button{
+			{
+				Label:  []byte("New collage"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("Keep chopping"),
+				Action: fc.ActionPOST,
+				Target: []byte(fmt.Sprintf("%s/generate?session=%s", BASE_URL, id)),
+			},
+		},
+	}
+	frame.Render(w)
+}
@@ -0,0 +1,59 @@
+package pin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakePinner keeps pinned content in memory, for running the mint path
+// offline.
+type FakePinner struct {
+	Gateway string
+
+	mu     sync.Mutex
+	pinned map[string][]byte
+}
+
+func NewFakePinner(gateway string) *FakePinner {
+	return &FakePinner{Gateway: gateway, pinned: make(map[string][]byte)}
+}
+
+func (f *FakePinner) Pin(ctx context.Context, name string, data []byte) (*Pinned, error) {
+	cid := RawCID(data)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pinned[cid] = append([]byte(nil), data...)
+	return &Pinned{CID: cid, URL: gatewayURL(f.Gateway, cid)}, nil
+}
+
+// Get returns the content pinned under cid, or a file of a directory
+// under its CID followed by "/" and its name.
+func (f *FakePinner) Get(cid string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.pinned[cid]
+	return data, ok
+}
+
+// PinDir pins each file under the directory's CID followed by "/" and its
+// name. The directory's CID is that of a listing of the files; it isn't
+// the CID IPFS would give it.
+func (f *FakePinner) PinDir(ctx context.Context, name string, files []File) (*Pinned, error) {
+	if err := checkDir(files); err != nil {
+		return nil, err
+	}
+	var listing bytes.Buffer
+	for _, file := range files {
+		fmt.Fprintf(&listing, "%s %s\n", RawCID(file.Data), file.Name)
+	}
+	dir := RawCID(listing.Bytes())
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pinned[dir] = listing.Bytes()
+	for _, file := range files {
+		f.pinned[dir+"/"+file.Name] = append([]byte(nil), file.Data...)
+	}
+	return &Pinned{CID: dir, URL: gatewayURL(f.Gateway, dir)}, nil
+}
@@ -0,0 +1,62 @@
+package pin
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Kubo adds and pins files through the RPC API of a Kubo (go-ipfs) node,
+// usually http://127.0.0.1:5001.
+type Kubo struct {
+	APIURL string
+	// Gateway defaults to DefaultGateway; set it to the node's own gateway
+	// until the content has propagated.
+	Gateway    string
+	HTTPClient *http.Client
+	Retries    int
+}
+
+func NewKubo(apiURL, gateway string) *Kubo {
+	if apiURL == "" {
+		apiURL = "http://127.0.0.1:5001"
+	}
+	return &Kubo{APIURL: strings.TrimSuffix(apiURL, "/"), Gateway: gateway, Retries: defaultRetries}
+}
+
+func (k *Kubo) Pin(ctx context.Context, name string, data []byte) (*Pinned, error) {
+	return k.add(ctx, "", []File{{Name: name, Data: data}})
+}
+
+// PinDir adds files wrapped in a directory. Kubo doesn't name the
+// directory, so name is unused.
+func (k *Kubo) PinDir(ctx context.Context, name string, files []File) (*Pinned, error) {
+	if err := checkDir(files); err != nil {
+		return nil, err
+	}
+	return k.add(ctx, "&wrap-with-directory=true", files)
+}
+
+// add adds files with the extra query options, returning the last entry
+// Kubo reports, which is the wrapping directory if there is one.
+func (k *Kubo) add(ctx context.Context, options string, files []File) (*Pinned, error) {
+	body, contentType, err := multipartFiles("file", files)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Hash string `json:"Hash"`
+	}
+	err = (&upload{
+		client:      k.HTTPClient,
+		retries:     k.Retries,
+		url:         k.APIURL + "/api/v0/add?pin=true&cid-version=1&raw-leaves=true" + options,
+		body:        body,
+		contentType: contentType,
+		last:        true,
+	}).do(ctx, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &Pinned{CID: resp.Hash, URL: gatewayURL(k.Gateway, resp.Hash)}, nil
+}
@@ -0,0 +1,81 @@
+package pin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+const NFT_STORAGE_URL = "https://api.nft.storage"
+
+// NFTStorage pins files through the upload API shared by nft.storage and
+// the classic web3.storage service. Set BaseURL to use the latter.
+type NFTStorage struct {
+	BaseURL    string
+	Token      string
+	Gateway    string
+	HTTPClient *http.Client
+	Retries    int
+}
+
+func NewNFTStorage(baseURL, token, gateway string) *NFTStorage {
+	if baseURL == "" {
+		baseURL = NFT_STORAGE_URL
+	}
+	return &NFTStorage{BaseURL: baseURL, Token: token, Gateway: gateway, Retries: defaultRetries}
+}
+
+func (n *NFTStorage) Pin(ctx context.Context, name string, data []byte) (*Pinned, error) {
+	return n.upload(ctx, name, data, "application/octet-stream")
+}
+
+// PinDir uploads files as multipart form data, which the service stores
+// as a directory.
+func (n *NFTStorage) PinDir(ctx context.Context, name string, files []File) (*Pinned, error) {
+	if err := checkDir(files); err != nil {
+		return nil, err
+	}
+	body, contentType, err := multipartFiles("file", files)
+	if err != nil {
+		return nil, err
+	}
+	return n.upload(ctx, name, body, contentType)
+}
+
+func (n *NFTStorage) upload(ctx context.Context, name string, body []byte, contentType string) (*Pinned, error) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+n.Token)
+	header.Set("X-Name", name)
+
+	var resp struct {
+		OK    bool `json:"ok"`
+		Value struct {
+			CID string `json:"cid"`
+		} `json:"value"`
+		// web3.storage returns the cid at the top level
+		CID   string `json:"cid"`
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err := (&upload{
+		client:      n.HTTPClient,
+		retries:     n.Retries,
+		url:         n.BaseURL + "/upload",
+		header:      header,
+		body:        body,
+		contentType: contentType,
+	}).do(ctx, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	cid := resp.Value.CID
+	if cid == "" {
+		cid = resp.CID
+	}
+	if cid == "" {
+		return nil, errors.New("upload returned no cid: " + resp.Error.Message)
+	}
+	return &Pinned{CID: cid, URL: gatewayURL(n.Gateway, cid)}, nil
+}
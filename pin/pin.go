@@ -0,0 +1,156 @@
+// Package pin uploads and pins content to IPFS.
+package pin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/treethought/impression-frame/internal/httpretry"
+)
+
+var (
+	_ Pinner = (*Pinata)(nil)
+	_ Pinner = (*NFTStorage)(nil)
+	_ Pinner = (*Kubo)(nil)
+	_ Pinner = (*FakePinner)(nil)
+)
+
+// DefaultGateway serves pinned content when a pinner has no gateway set.
+const DefaultGateway = "https://ipfs.io"
+
+const (
+	defaultRetries = httpretry.DefaultRetries
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 10 * time.Second
+)
+
+// DefaultHTTPClient is used by pinners created without one. Uploads can be
+// slow, so the timeout is generous.
+var DefaultHTTPClient = &http.Client{Timeout: 2 * time.Minute}
+
+// Pinned is content stored on IPFS.
+type Pinned struct {
+	CID string
+	// URL serves the content through an HTTP gateway.
+	URL string
+}
+
+// URI is the ipfs:// URI of the content.
+func (p *Pinned) URI() string {
+	return "ipfs://" + p.CID
+}
+
+// File is a file of a directory pinned with PinDir.
+type File struct {
+	Name string
+	Data []byte
+}
+
+// Pinner uploads data to IPFS and pins it. name is a filename hint used
+// by services that keep one.
+type Pinner interface {
+	Pin(ctx context.Context, name string, data []byte) (*Pinned, error)
+	// PinDir pins files as a directory named name, returning the
+	// directory. Each file is at its URI followed by "/" and its name.
+	PinDir(ctx context.Context, name string, files []File) (*Pinned, error)
+}
+
+// StatusError is returned when a pinning service responds with a non-2xx
+// status.
+type StatusError = httpretry.StatusError
+
+func gatewayURL(gateway, cid string) string {
+	if gateway == "" {
+		gateway = DefaultGateway
+	}
+	return strings.TrimSuffix(gateway, "/") + "/ipfs/" + cid
+}
+
+// upload is a pinning request. Pinning is content addressed, so repeating
+// an upload is harmless and failed ones are always retried.
+type upload struct {
+	client      *http.Client
+	retries     int
+	url         string
+	header      http.Header
+	body        []byte
+	contentType string
+	// last decodes the last of a stream of JSON values.
+	last bool
+}
+
+func (u *upload) do(ctx context.Context, out interface{}) error {
+	client := u.client
+	if client == nil {
+		client = DefaultHTTPClient
+	}
+	r := &httpretry.Requester{
+		Client:    client,
+		Retries:   u.retries,
+		Header:    u.header,
+		BaseDelay: retryBaseDelay,
+		MaxDelay:  retryMaxDelay,
+	}
+	return r.Do(ctx, httpretry.Request{
+		Method:      http.MethodPost,
+		URL:         u.url,
+		Body:        u.body,
+		ContentType: u.contentType,
+		Idempotent:  true,
+		Last:        u.last,
+	}, out)
+}
+
+// multipartFile encodes data as a single file form field.
+func multipartFile(field, name string, data []byte) ([]byte, string, error) {
+	return multipartFiles(field, []File{{Name: name, Data: data}})
+}
+
+// multipartFiles encodes files as repeated file form fields.
+func multipartFiles(field string, files []File) ([]byte, string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, f := range files {
+		part, err := w.CreateFormFile(field, f.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(f.Data); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// checkDir rejects file names that aren't a single path element.
+func checkDir(files []File) error {
+	if len(files) == 0 {
+		return errors.New("no files to pin")
+	}
+	for _, f := range files {
+		if f.Name == "" || f.Name == "." || f.Name == ".." || strings.Contains(f.Name, "/") {
+			return fmt.Errorf("invalid file name %q", f.Name)
+		}
+	}
+	return nil
+}
+
+// RawCID returns the CIDv1 of data stored as a single raw block, the CID
+// Kubo gives small files added with --cid-version=1 --raw-leaves.
+func RawCID(data []byte) string {
+	h := sha256.Sum256(data)
+	// version 1, raw codec, sha2-256 multihash of 32 bytes
+	b := append([]byte{0x01, 0x55, 0x12, 0x20}, h[:]...)
+	return "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
@@ -0,0 +1,225 @@
+package pin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRawCID(t *testing.T) {
+	cases := map[string]string{
+		"":            "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku",
+		"hello world": "bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e",
+	}
+	for data, want := range cases {
+		if got := RawCID([]byte(data)); got != want {
+			t.Errorf("RawCID(%q) = %s, want %s", data, got, want)
+		}
+	}
+}
+
+func TestFakePinner(t *testing.T) {
+	f := NewFakePinner("https://gw.example.com/")
+	p, err := f.Pin(context.Background(), "a.png", []byte("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CID != RawCID([]byte("hello world")) {
+		t.Errorf("CID = %s", p.CID)
+	}
+	if p.URL != "https://gw.example.com/ipfs/"+p.CID || p.URI() != "ipfs://"+p.CID {
+		t.Errorf("URL = %s, URI = %s", p.URL, p.URI())
+	}
+	if data, ok := f.Get(p.CID); !ok || string(data) != "hello world" {
+		t.Errorf("Get = %q, %v", data, ok)
+	}
+}
+
+// pinService stands in for a pinning API, failing the first fail
+// requests with a 503.
+func pinService(t *testing.T, fail int32, check func(r *http.Request, body []byte), resp string) (string, *int32) {
+	t.Helper()
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) <= fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s", r.Method)
+		}
+		body, _ := io.ReadAll(r.Body)
+		check(r, body)
+		w.Write([]byte(resp))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &hits
+}
+
+func TestKuboRetries(t *testing.T) {
+	url, hits := pinService(t, 1, func(r *http.Request, body []byte) {
+		if r.URL.Path != "/api/v0/add" || r.URL.Query().Get("pin") != "true" {
+			t.Errorf("url = %s", r.URL)
+		}
+		if !strings.Contains(string(body), `filename="a.png"`) {
+			t.Errorf("body is missing the file part: %q", body)
+		}
+	}, `{"Hash":"bafyfile"}`)
+	k := NewKubo(url, "https://gw.example.com")
+	p, err := k.Pin(context.Background(), "a.png", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CID != "bafyfile" || p.URL != "https://gw.example.com/ipfs/bafyfile" {
+		t.Errorf("pinned = %+v", p)
+	}
+	if *hits != 2 {
+		t.Errorf("got %d requests, want 2", *hits)
+	}
+}
+
+func TestPinataAuth(t *testing.T) {
+	url, _ := pinService(t, 0, func(r *http.Request, body []byte) {
+		if got := r.Header.Get("Authorization"); got != "Bearer jwt" {
+			t.Errorf("Authorization = %q", got)
+		}
+	}, `{"IpfsHash":"bafypinata"}`)
+	p := NewPinata("", "", "jwt", "")
+	p.BaseURL = url
+	pinned, err := p.Pin(context.Background(), "a.png", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned.URL != DefaultGateway+"/ipfs/bafypinata" {
+		t.Errorf("URL = %s", pinned.URL)
+	}
+}
+
+func TestNFTStorage(t *testing.T) {
+	url, _ := pinService(t, 0, func(r *http.Request, body []byte) {
+		if string(body) != "data" || r.Header.Get("X-Name") != "a.png" {
+			t.Errorf("body %q, X-Name %q", body, r.Header.Get("X-Name"))
+		}
+	}, `{"ok":true,"value":{"cid":"bafynft"}}`)
+	n := NewNFTStorage(url, "token", "")
+	pinned, err := n.Pin(context.Background(), "a.png", []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned.CID != "bafynft" {
+		t.Errorf("CID = %s", pinned.CID)
+	}
+}
+
+func TestPinDoesNotRetryClientErrors(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+	_, err := NewKubo(srv.URL, "").Pin(context.Background(), "a.png", []byte("data"))
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("err = %v, want a 401 StatusError", err)
+	}
+	if hits != 1 {
+		t.Errorf("got %d requests, want 1", hits)
+	}
+}
+
+func TestFakePinnerDir(t *testing.T) {
+	f := NewFakePinner("")
+	dir, err := f.PinDir(context.Background(), "metadata", []File{{Name: "1", Data: []byte("one")}, {Name: "2", Data: []byte("two")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"1": "one", "2": "two"} {
+		if data, ok := f.Get(dir.CID + "/" + name); !ok || string(data) != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", name, data, ok, want)
+		}
+	}
+	other, err := f.PinDir(context.Background(), "metadata", []File{{Name: "1", Data: []byte("uno")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.CID == dir.CID {
+		t.Error("directories of different files share a CID")
+	}
+}
+
+func TestPinDirRejectsPaths(t *testing.T) {
+	for _, files := range [][]File{
+		nil,
+		{{Name: "", Data: []byte("x")}},
+		{{Name: "..", Data: []byte("x")}},
+		{{Name: "a/b", Data: []byte("x")}},
+	} {
+		if _, err := NewFakePinner("").PinDir(context.Background(), "dir", files); err == nil {
+			t.Errorf("PinDir(%v) pinned invalid names", files)
+		}
+	}
+}
+
+func TestKuboPinDir(t *testing.T) {
+	url, _ := pinService(t, 0, func(r *http.Request, body []byte) {
+		if r.URL.Query().Get("wrap-with-directory") != "true" {
+			t.Errorf("url = %s, want the files wrapped", r.URL)
+		}
+		if !strings.Contains(string(body), `filename="1"`) {
+			t.Errorf("body is missing the file part: %q", body)
+		}
+	}, `{"Name":"1","Hash":"bafyfile"}
+{"Name":"","Hash":"bafydir"}
+`)
+	p, err := NewKubo(url, "").PinDir(context.Background(), "metadata", []File{{Name: "1", Data: []byte("data")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CID != "bafydir" {
+		t.Errorf("CID = %s, want the directory's", p.CID)
+	}
+}
+
+func TestPinataPinDir(t *testing.T) {
+	url, _ := pinService(t, 0, func(r *http.Request, body []byte) {
+		if !strings.Contains(string(body), `filename="metadata/1"`) {
+			t.Errorf("body doesn't upload a folder: %q", body)
+		}
+	}, `{"IpfsHash":"bafydir"}`)
+	p := NewPinata("", "", "jwt", "")
+	p.BaseURL = url
+	pinned, err := p.PinDir(context.Background(), "metadata", []File{{Name: "1", Data: []byte("data")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned.CID != "bafydir" {
+		t.Errorf("CID = %s", pinned.CID)
+	}
+}
+
+func TestNFTStoragePinDir(t *testing.T) {
+	url, _ := pinService(t, 0, func(r *http.Request, body []byte) {
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			t.Errorf("Content-Type = %s", r.Header.Get("Content-Type"))
+		}
+		if !strings.Contains(string(body), `filename="1"`) || !strings.Contains(string(body), `filename="2"`) {
+			t.Errorf("body is missing a file part: %q", body)
+		}
+	}, `{"ok":true,"value":{"cid":"bafydir"}}`)
+	pinned, err := NewNFTStorage(url, "token", "").PinDir(context.Background(), "metadata", []File{
+		{Name: "1", Data: []byte("one")},
+		{Name: "2", Data: []byte("two")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned.CID != "bafydir" {
+		t.Errorf("CID = %s", pinned.CID)
+	}
+}
@@ -0,0 +1,78 @@
+package pin
+
+import (
+	"context"
+	"net/http"
+)
+
+const PINATA_URL = "https://api.pinata.cloud"
+
+// Pinata pins files through the Pinata API. It authenticates with JWT
+// when set, and with the API key and secret otherwise.
+type Pinata struct {
+	BaseURL   string
+	APIKey    string
+	APISecret string
+	JWT       string
+	// Gateway serves pinned content, e.g. a dedicated Pinata gateway.
+	Gateway    string
+	HTTPClient *http.Client
+	Retries    int
+}
+
+func NewPinata(apiKey, apiSecret, jwt, gateway string) *Pinata {
+	return &Pinata{
+		BaseURL:   PINATA_URL,
+		APIKey:    apiKey,
+		APISecret: apiSecret,
+		JWT:       jwt,
+		Gateway:   gateway,
+		Retries:   defaultRetries,
+	}
+}
+
+func (p *Pinata) Pin(ctx context.Context, name string, data []byte) (*Pinned, error) {
+	return p.pin(ctx, []File{{Name: name, Data: data}})
+}
+
+// PinDir uploads files as a folder, which Pinata pins as a directory.
+func (p *Pinata) PinDir(ctx context.Context, name string, files []File) (*Pinned, error) {
+	if err := checkDir(files); err != nil {
+		return nil, err
+	}
+	folder := make([]File, len(files))
+	for i, f := range files {
+		folder[i] = File{Name: name + "/" + f.Name, Data: f.Data}
+	}
+	return p.pin(ctx, folder)
+}
+
+func (p *Pinata) pin(ctx context.Context, files []File) (*Pinned, error) {
+	body, contentType, err := multipartFiles("file", files)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if p.JWT != "" {
+		header.Set("Authorization", "Bearer "+p.JWT)
+	} else {
+		header.Set("pinata_api_key", p.APIKey)
+		header.Set("pinata_secret_api_key", p.APISecret)
+	}
+
+	var resp struct {
+		IpfsHash string `json:"IpfsHash"`
+	}
+	err = (&upload{
+		client:      p.HTTPClient,
+		retries:     p.Retries,
+		url:         p.BaseURL + "/pinning/pinFileToIPFS",
+		header:      header,
+		body:        body,
+		contentType: contentType,
+	}).do(ctx, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &Pinned{CID: resp.IpfsHash, URL: gatewayURL(p.Gateway, resp.IpfsHash)}, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/store"
+)
+
+var (
+	// mints queued per day; 0 disables
+	MINT_DAILY_LIMIT_PER_FID = os.Getenv("MINT_DAILY_LIMIT_PER_FID")
+	MINT_DAILY_LIMIT         = os.Getenv("MINT_DAILY_LIMIT")
+	// comma separated; when any is set, only matching users may mint
+	MINT_ALLOW_FIDS         = os.Getenv("MINT_ALLOW_FIDS")
+	MINT_ALLOW_CHANNELS     = os.Getenv("MINT_ALLOW_CHANNELS")
+	MINT_ALLOW_FOLLOWERS_OF = os.Getenv("MINT_ALLOW_FOLLOWERS_OF")
+)
+
+const (
+	defaultMintLimitPerFID = 5
+	defaultMintLimit       = 200
+	mintQuotaWindow        = 24 * time.Hour
+	// maxMessageAge is how long after it was signed a frame action can
+	// queue a mint. Replays are only caught while the jobs they queued are
+	// kept, which is far longer.
+	maxMessageAge = 10 * time.Minute
+)
+
+var (
+	// errNotAllowed is returned for users the allowlists don't match.
+	errNotAllowed = errors.New("not allowed to mint")
+	// errUnverified is returned for mints from frame actions that weren't
+	// validated, as the quota, allowlists and replay checks trust their
+	// fid and message hash.
+	errUnverified = errors.New("frame message not validated")
+	// errExpired is returned for frame actions signed over maxMessageAge
+	// ago, or without a timestamp.
+	errExpired = errors.New("frame message expired")
+)
+
+// mintPolicy decides who may mint and how often.
+type mintPolicy struct {
+	quota store.MintQuota
+	// a user matching any allowlist may mint; with none, everyone may
+	allowFIDs        map[uint64]bool
+	allowChannels    []string
+	allowFollowersOf []uint64
+}
+
+func loadMintPolicy() mintPolicy {
+	p := mintPolicy{
+		quota: store.MintQuota{
+			PerFID: envLimit("MINT_DAILY_LIMIT_PER_FID", MINT_DAILY_LIMIT_PER_FID, defaultMintLimitPerFID),
+			Global: envLimit("MINT_DAILY_LIMIT", MINT_DAILY_LIMIT, defaultMintLimit),
+			Window: mintQuotaWindow,
+		},
+		allowFIDs: make(map[uint64]bool),
+	}
+	for _, fid := range envFIDs("MINT_ALLOW_FIDS", MINT_ALLOW_FIDS) {
+		p.allowFIDs[fid] = true
+	}
+	p.allowFollowersOf = envFIDs("MINT_ALLOW_FOLLOWERS_OF", MINT_ALLOW_FOLLOWERS_OF)
+	for _, ch := range strings.Split(MINT_ALLOW_CHANNELS, ",") {
+		if ch = strings.TrimSpace(ch); ch != "" {
+			p.allowChannels = append(p.allowChannels, ch)
+		}
+	}
+	return p
+}
+
+func envLimit(name, value string, def int) int {
+	if value == "" {
+		return def
+	}
+	return int(envInt(name, value))
+}
+
+func envFIDs(name, value string) []uint64 {
+	var fids []uint64
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		fid, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid %s: %v", name, err)
+		}
+		fids = append(fids, fid)
+	}
+	return fids
+}
+
+func (p *mintPolicy) restricted() bool {
+	return len(p.allowFIDs) > 0 || len(p.allowChannels) > 0 || len(p.allowFollowersOf) > 0
+}
+
+// checkAllowed returns errUnverified unless packet was validated,
+// errExpired unless it was signed recently, and errNotAllowed unless its
+// user matches an allowlist: their fid, the channel of the cast the frame
+// is in, or following one of the accounts.
+func (s *server) checkAllowed(ctx context.Context, packet fc.SignaturePacket) error {
+	if !s.validate || packet.UntrustedData.MessageHash == "" {
+		return errUnverified
+	}
+	signed := time.UnixMilli(int64(packet.UntrustedData.Timestamp))
+	if packet.UntrustedData.Timestamp == 0 || time.Since(signed).Abs() > maxMessageAge {
+		return errExpired
+	}
+	p := &s.policy
+	if !p.restricted() {
+		return nil
+	}
+	fid := packet.UntrustedData.FID
+	if p.allowFIDs[fid] {
+		return nil
+	}
+
+	cast := packet.UntrustedData.CastId
+	if len(p.allowChannels) > 0 && cast.Hash != "" {
+		c, err := s.fc.GetCast(ctx, cast.FID, cast.Hash)
+		if err != nil && !errors.Is(err, fc.ErrNotFound) {
+			return err
+		}
+		for _, ch := range p.allowChannels {
+			if c != nil && c.InChannel(ch) {
+				return nil
+			}
+		}
+	}
+
+	for _, target := range p.allowFollowersOf {
+		following, err := s.fc.IsFollowing(ctx, fid, target)
+		if err != nil {
+			return err
+		}
+		if following {
+			return nil
+		}
+	}
+	return errNotAllowed
+}
+
+// policyMessage explains why the policy refused a mint, or returns nil
+// for other errors.
+func policyMessage(err error) []string {
+	var quota *store.QuotaError
+	switch {
+	case errors.Is(err, errUnverified), errors.Is(err, store.ErrNoMessage):
+		return []string{"Minting is unavailable", "Frame messages aren't being verified"}
+	case errors.Is(err, errExpired):
+		return []string{"This action has expired", "Press the button again"}
+	case errors.Is(err, errNotAllowed):
+		return []string{"Minting is limited", "to members of this community"}
+	case errors.As(err, &quota) && quota.Global:
+		return []string{"Today's mints are all gone", "Come back tomorrow"}
+	case errors.As(err, &quota):
+		return []string{fmt.Sprintf("You've minted %d today", quota.Limit), "Come back tomorrow"}
+	case errors.Is(err, store.ErrAlreadyMinted):
+		return []string{"This image was already minted", "Chop it some more first"}
+	case errors.Is(err, store.ErrReplayed):
+		return []string{"This action was already used"}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/treethought/impression-frame/contract"
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/store"
+)
+
+func TestCheckAllowed(t *testing.T) {
+	const (
+		castAuthor = 9
+		followed   = 10
+	)
+	cases := []struct {
+		name     string
+		policy   mintPolicy
+		validate bool
+		// edit changes the packet, which starts out validated and fresh
+		edit func(p *fc.UntrustedData)
+		want error
+	}{
+		{name: "unrestricted", validate: true},
+		{name: "not validating", validate: false, want: errUnverified},
+		{
+			name:     "no message hash",
+			validate: true,
+			edit:     func(p *fc.UntrustedData) { p.MessageHash = "" },
+			want:     errUnverified,
+		},
+		{
+			name:     "no timestamp",
+			validate: true,
+			edit:     func(p *fc.UntrustedData) { p.Timestamp = 0 },
+			want:     errExpired,
+		},
+		{
+			name:     "signed too long ago",
+			validate: true,
+			edit:     func(p *fc.UntrustedData) { p.Timestamp = millis(time.Now().Add(-maxMessageAge - time.Minute)) },
+			want:     errExpired,
+		},
+		{
+			name:     "signed in the future",
+			validate: true,
+			edit:     func(p *fc.UntrustedData) { p.Timestamp = millis(time.Now().Add(maxMessageAge + time.Minute)) },
+			want:     errExpired,
+		},
+		{
+			name:     "signed a while ago",
+			validate: true,
+			edit:     func(p *fc.UntrustedData) { p.Timestamp = millis(time.Now().Add(-maxMessageAge / 2)) },
+		},
+		{
+			name:     "allowed fid",
+			validate: true,
+			policy:   mintPolicy{allowFIDs: map[uint64]bool{testFID: true}},
+		},
+		{
+			name:     "other fid",
+			validate: true,
+			policy:   mintPolicy{allowFIDs: map[uint64]bool{testFID + 1: true}},
+			want:     errNotAllowed,
+		},
+		{
+			name:     "cast in channel",
+			validate: true,
+			policy:   mintPolicy{allowChannels: []string{"art"}},
+			edit:     inCast("0xa1"),
+		},
+		{
+			name:     "cast in channel by parent url",
+			validate: true,
+			policy:   mintPolicy{allowChannels: []string{"https://warpcast.com/~/channel/art"}},
+			edit:     inCast("0xa1"),
+		},
+		{
+			name:     "cast in another channel",
+			validate: true,
+			policy:   mintPolicy{allowChannels: []string{"art"}},
+			edit:     inCast("0xb2"),
+			want:     errNotAllowed,
+		},
+		{
+			name:     "unknown cast",
+			validate: true,
+			policy:   mintPolicy{allowChannels: []string{"art"}},
+			edit:     inCast("0xc3"),
+			want:     errNotAllowed,
+		},
+		{
+			name:     "no cast",
+			validate: true,
+			policy:   mintPolicy{allowChannels: []string{"art"}},
+			want:     errNotAllowed,
+		},
+		{
+			name:     "follower",
+			validate: true,
+			policy:   mintPolicy{allowFollowersOf: []uint64{castAuthor, followed}},
+		},
+		{
+			name:     "not a follower",
+			validate: true,
+			policy:   mintPolicy{allowFollowersOf: []uint64{castAuthor}},
+			want:     errNotAllowed,
+		},
+		{
+			name:     "follower of an account outside the channel",
+			validate: true,
+			policy: mintPolicy{
+				allowFIDs:        map[uint64]bool{testFID + 1: true},
+				allowChannels:    []string{"art"},
+				allowFollowersOf: []uint64{followed},
+			},
+			edit: inCast("0xb2"),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ts := newTestServer(t, contract.Chain{Name: "sim"})
+			ts.client.AddCast(fc.Cast{FID: castAuthor, Hash: "0xa1", Channel: "art", ParentURL: "https://warpcast.com/~/channel/art"})
+			ts.client.AddCast(fc.Cast{FID: castAuthor, Hash: "0xb2", Channel: "food", ParentURL: "https://warpcast.com/~/channel/food"})
+			ts.client.AddFollow(testFID, followed)
+			ts.validate = c.validate
+			ts.policy = c.policy
+
+			var packet fc.SignaturePacket
+			packet.UntrustedData.FID = testFID
+			packet.UntrustedData.MessageHash = "0x01"
+			packet.UntrustedData.Timestamp = millis(time.Now())
+			if c.edit != nil {
+				c.edit(&packet.UntrustedData)
+			}
+			if err := ts.checkAllowed(context.Background(), packet); !errors.Is(err, c.want) {
+				t.Errorf("checkAllowed = %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func millis(t time.Time) uint64 {
+	return uint64(t.UnixMilli())
+}
+
+func inCast(hash string) func(p *fc.UntrustedData) {
+	return func(p *fc.UntrustedData) {
+		p.CastId.FID = 9
+		p.CastId.Hash = hash
+	}
+}
+
+func TestPolicyMessage(t *testing.T) {
+	for _, err := range []error{
+		errUnverified,
+		errExpired,
+		errNotAllowed,
+		store.ErrNoMessage,
+		store.ErrAlreadyMinted,
+		store.ErrReplayed,
+		&store.QuotaError{Limit: 5, Window: time.Hour},
+		&store.QuotaError{Global: true, Limit: 10, Window: time.Hour},
+	} {
+		if policyMessage(err) == nil {
+			t.Errorf("no message for %v", err)
+		}
+	}
+	if lines := policyMessage(errors.New("rpc down")); lines != nil {
+		t.Errorf("policyMessage(rpc down) = %q, want nil", lines)
+	}
+}
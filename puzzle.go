@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/gen"
+)
+
+// handlePuzzle shows the user's PFP and lets them choose a board size.
+func (s *server) handlePuzzle(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	user, err := s.fc.GetUser(r.Context(), packet.UntrustedData.FID)
+	if err != nil {
+		log.Println("failed to get user: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	frame := fc.Frame{
+		FrameV:  "vNext",
+		Image:   user.PfpUrl,
+		PostURL: fmt.Sprintf("%s/puzzle/new", BASE_URL),
+		Buttons: []fc.Button{
+			{
+				Label:  []byte("3x3"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("4x4"),
+				Action: fc.ActionPOST,
+			},
+		},
+	}
+	frame.Render(w)
+}
+
+// handlePuzzleNew scrambles a new board of the chosen size.
+func (s *server) handlePuzzleNew(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	size := 3
+	if packet.UntrustedData.ButtonIndex == 2 {
+		size = 4
+	}
+	p, err := gen.NewPuzzle(size)
+	if err != nil {
+		log.Println("failed to create puzzle: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.renderPuzzle(w, r, packet.UntrustedData.FID, p)
+}
+
+// handlePuzzleBoard shows the board carried in the state query parameter
+// again, after a move sequence couldn't be read.
+func (s *server) handlePuzzleBoard(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	p, err := gen.DecodePuzzle(r.URL.Query().Get("state"))
+	if err != nil {
+		log.Println("failed to decode puzzle: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.renderPuzzle(w, r, packet.UntrustedData.FID, p)
+}
+
+// handlePuzzleMove applies a button press or a typed move sequence to the
+// board carried in the state query parameter.
+func (s *server) handlePuzzleMove(w http.ResponseWriter, r *http.Request) {
+	packet, err := s.getSignaturePacket(r)
+	if err != nil {
+		log.Println("failed to get signature packet: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	p, err := gen.DecodePuzzle(r.URL.Query().Get("state"))
+	if err != nil {
+		log.Println("failed to decode puzzle: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var moves []gen.Direction
+	if packet.UntrustedData.InputText != "" {
+		moves, err = gen.ParseMoves(packet.UntrustedData.InputText)
+		if err != nil {
+			retry := fmt.Sprintf("%s/puzzle/board?state=%s", BASE_URL, url.QueryEscape(p.Encode()))
+			s.renderError(w, r, packet.UntrustedData.FID, retry,
+				fmt.Sprintf("Couldn't read %q", packet.UntrustedData.InputText),
+				"Type moves like UULDR")
+			return
+		}
+	} else {
+		switch packet.UntrustedData.ButtonIndex {
+		case 1:
+			moves = []gen.Direction{gen.Up}
+		case 2:
+			moves = []gen.Direction{gen.Down}
+		case 3:
+			moves = []gen.Direction{gen.Left}
+		case 4:
+			moves = []gen.Direction{gen.Right}
+		}
+	}
+	p.Move(moves...)
+
+	s.renderPuzzle(w, r, packet.UntrustedData.FID, p)
+}
+
+func (s *server) renderPuzzle(w http.ResponseWriter, r *http.Request, fid uint64, p *gen.Puzzle) {
+	pfp, err := fc.GetOrLoadPFP(r.Context(), s.fc, fid)
+	if err != nil {
+		log.Println("failed to load pfp: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	board := p.Render(pfp)
+	if p.Solved() {
+		board = gen.Caption(board, "Solved!", fmt.Sprintf("%d moves", p.Moves))
+	}
+
+	// boards aren't results to chop, so no session is recorded for them
+	imgUrl, err := s.storeImage(r.Context(), fid, board)
+	if err != nil {
+		log.Println("failed to save puzzle: ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if p.Solved() {
+		frame := fc.Frame{
+			FrameV:  "vNext",
+			Image:   imgUrl,
+			PostURL: fmt.Sprintf("%s/puzzle", BASE_URL),
+			Buttons: []fc.Button{
+				{
+					Label:  []byte("Play again"),
+					Action: fc.ActionPOST,
+				},
+				{
+					Label:  []byte("Chop it"),
+					Action: fc.ActionPOST,
+					Target: []byte(fmt.Sprintf("%s/start", BASE_URL)),
+				},
+			},
+		}
+		frame.Render(w)
+		return
+	}
+
+	frame := fc.Frame{
+		FrameV:         "vNext",
+		Image:          imgUrl,
+		PostURL:        fmt.Sprintf("%s/puzzle/move?state=%s", BASE_URL, url.QueryEscape(p.Encode())),
+		InputTextLabel: "Moves, e.g. UULDR",
+		Buttons: []fc.Button{
+			{
+				Label:  []byte("↑"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("↓"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("←"),
+				Action: fc.ActionPOST,
+			},
+			{
+				Label:  []byte("→"),
+				Action: fc.ActionPOST,
+			},
+		},
+	}
+	frame.Render(w)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"net/url"
+	"testing"
+
+	"github.com/treethought/impression-frame/contract"
+	fc "github.com/treethought/impression-frame/farcaster"
+	"github.com/treethought/impression-frame/gen"
+)
+
+func newPuzzleServer(t *testing.T) *testServer {
+	t.Helper()
+	ts := newTestServer(t, contract.Chain{Name: "sim"})
+	// prime the PFP the boards are cut from
+	_, err := fc.Cache.Load(context.Background(), testFID, func(ctx context.Context) (string, image.Image, error) {
+		return "https://pfp.example.com/alice.png", testImage(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+func TestPuzzleMovesRecordNoSessions(t *testing.T) {
+	ts := newPuzzleServer(t)
+	p := &gen.Puzzle{Size: 3, Tiles: []int{0, 1, 2, 3, 4, 5, 6, 8, 7}}
+	target := fmt.Sprintf("%s/puzzle/move?state=%s", testBaseURL, url.QueryEscape(p.Encode()))
+	// the first button slides a tile up, but none is below the empty slot
+	next := ts.post(t, ts.handlePuzzleMove, target, testFID, 1)
+	if next != target {
+		t.Fatalf("move posts to %s, want the unchanged board %s", next, target)
+	}
+	// the third slides a tile left, solving it
+	next = ts.post(t, ts.handlePuzzleMove, target, testFID, 3)
+	if next != testBaseURL+"/puzzle" {
+		t.Errorf("solved puzzle posts to %s, want a new game", next)
+	}
+	if sessions := ts.sessions.Children(testFID, ""); len(sessions) != 0 {
+		t.Errorf("recorded %d sessions for puzzle boards", len(sessions))
+	}
+}
+
+func TestPuzzleRejectsUnreadableMoves(t *testing.T) {
+	ts := newPuzzleServer(t)
+	p := &gen.Puzzle{Size: 3, Tiles: []int{0, 1, 2, 3, 4, 5, 6, 8, 7}}
+	state := url.QueryEscape(p.Encode())
+	ts.frames++
+	messageBytes := fmt.Sprintf("message-%d", ts.frames)
+	target := fmt.Sprintf("%s/puzzle/move?state=%s", testBaseURL, state)
+	ts.client.AddMessage(messageBytes, fc.ValidatedMessage{
+		Valid:       true,
+		FID:         testFID,
+		URL:         target,
+		ButtonIndex: 1,
+		InputText:   "sideways",
+	})
+
+	next := ts.postMessage(t, ts.handlePuzzleMove, target, messageBytes)
+	if want := fmt.Sprintf("%s/puzzle/board?state=%s", testBaseURL, state); next != want {
+		t.Fatalf("unreadable moves post to %s, want %s", next, want)
+	}
+	// trying again shows the unchanged board
+	next = ts.post(t, ts.handlePuzzleBoard, next, testFID, 1)
+	if want := fmt.Sprintf("%s/puzzle/move?state=%s", testBaseURL, state); next != want {
+		t.Errorf("board posts to %s, want %s", next, want)
+	}
+}
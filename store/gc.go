@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Policy controls which stored images Collect deletes. Zero limits are
+// disabled.
+type Policy struct {
+	// MaxAge deletes images no session has used for this long.
+	MaxAge time.Duration
+	// MaxBytesPerFID and MaxBytes delete the least recently used images
+	// until each fid, and then the whole store, fits in the budget.
+	MaxBytesPerFID int64
+	MaxBytes       int64
+	// KeepMinted keeps minted images regardless of the limits above.
+	KeepMinted bool
+	// DryRun reports what would be deleted without deleting anything.
+	DryRun bool
+}
+
+// Report describes a Collect run.
+type Report struct {
+	Scanned int
+	// Deleted lists the objects deleted, or that would be in a dry run.
+	Deleted []Object
+	Freed   int64
+	// Kept counts minted images kept despite the limits.
+	Kept int
+	// Skipped counts images that were used again while collecting.
+	Skipped int
+	DryRun  bool
+}
+
+func (r *Report) String() string {
+	verb := "deleted"
+	if r.DryRun {
+		verb = "would delete"
+	}
+	return fmt.Sprintf("scanned %d images, %s %d (%d bytes), kept %d minted, skipped %d in use",
+		r.Scanned, verb, len(r.Deleted), r.Freed, r.Kept, r.Skipped)
+}
+
+type candidate struct {
+	Object
+	lastUsed  time.Time
+	protected bool
+}
+
+// Collect deletes the images in s that policy no longer allows, along with
+// the sessions of idx that reference them. Images used by a new session
+// while collecting are left alone.
+func Collect(ctx context.Context, s ImageStore, idx *SessionIndex, policy Policy) (*Report, error) {
+	start := time.Now()
+	report := &Report{DryRun: policy.DryRun}
+	usages := idx.usages()
+
+	fids, err := s.FIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var remaining []candidate
+	var doomed []candidate
+	for _, fid := range fids {
+		objs, err := s.List(ctx, fid)
+		if err != nil {
+			return nil, err
+		}
+		report.Scanned += len(objs)
+
+		var kept []candidate
+		for _, obj := range objs {
+			u := usages[obj.Key]
+			c := candidate{Object: obj, lastUsed: obj.ModTime, protected: policy.KeepMinted && u.minted}
+			if u.lastUsed.After(c.lastUsed) {
+				c.lastUsed = u.lastUsed
+			}
+			if policy.MaxAge > 0 && start.Sub(c.lastUsed) > policy.MaxAge && !c.protected {
+				doomed = append(doomed, c)
+				continue
+			}
+			if c.protected {
+				report.Kept++
+			}
+			kept = append(kept, c)
+		}
+
+		kept, over := trimToBudget(kept, policy.MaxBytesPerFID)
+		doomed = append(doomed, over...)
+		remaining = append(remaining, kept...)
+	}
+	_, over := trimToBudget(remaining, policy.MaxBytes)
+	doomed = append(doomed, over...)
+
+	for _, c := range doomed {
+		if idx.usedSince(c.Key, start) {
+			report.Skipped++
+			continue
+		}
+		if policy.DryRun {
+			log.Println("janitor would delete: ", c.Path())
+		} else {
+			if err := s.Delete(ctx, c.Key); err != nil && !errors.Is(err, ErrNotFound) {
+				log.Println("failed to delete result: ", err)
+				continue
+			}
+			if err := idx.removeKey(c.Key, start); err != nil {
+				log.Println("failed to update session index: ", err)
+			}
+		}
+		report.Deleted = append(report.Deleted, c.Object)
+		report.Freed += c.Size
+	}
+	return report, nil
+}
+
+// trimToBudget drops the least recently used unprotected candidates until
+// the rest fit in budget. It returns the candidates kept and dropped.
+func trimToBudget(cs []candidate, budget int64) ([]candidate, []candidate) {
+	if budget <= 0 {
+		return cs, nil
+	}
+	var total int64
+	for _, c := range cs {
+		total += c.Size
+	}
+	if total <= budget {
+		return cs, nil
+	}
+
+	sort.Slice(cs, func(i, j int) bool {
+		return cs[i].lastUsed.Before(cs[j].lastUsed)
+	})
+	var kept, dropped []candidate
+	for _, c := range cs {
+		if total > budget && !c.protected {
+			dropped = append(dropped, c)
+			total -= c.Size
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept, dropped
+}
@@ -0,0 +1,331 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/treethought/impression-frame/util"
+)
+
+// Session is a node of the session tree: a result, the session it was
+// made from and how it was made.
+type Session struct {
+	ID  string `json:"id"`
+	Key Key    `json:"key"`
+	// Parent is the id of the session this one was made from, or empty
+	// for a root such as a PFP.
+	Parent    string            `json:"parent,omitempty"`
+	Transform string            `json:"transform,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	Seed      int64             `json:"seed,omitempty"`
+	// SourcePFP is the URL of the PFP the tree was made from, as it was
+	// when the session was generated.
+	SourcePFP string    `json:"source_pfp,omitempty"`
+	Created   time.Time `json:"created"`
+	// Minted sessions are never evicted from the index.
+	Minted bool        `json:"minted,omitempty"`
+	Mint   *MintRecord `json:"mint,omitempty"`
+}
+
+// MintRecord is the token a session was minted as.
+type MintRecord struct {
+	ChainID     int64  `json:"chain_id,omitempty"`
+	Contract    string `json:"contract,omitempty"`
+	TokenID     string `json:"token_id,omitempty"`
+	TxHash      string `json:"tx_hash"`
+	MetadataURI string `json:"metadata_uri,omitempty"`
+}
+
+// SessionIndex is the history of every session as a tree. It maps session
+// ids to the content addressed keys of their images, so identical results
+// share storage while each click still gets its own session. It keeps the
+// newest max sessions and, when path is set, persists them to a log of
+// JSON lines that is appended to on each change and compacted once it
+// grows well past the live sessions.
+type SessionIndex struct {
+	path string
+	max  int
+
+	mu       sync.Mutex
+	sessions map[string]Session
+	// order holds unminted session ids oldest first, for eviction. Ids
+	// removed since are skipped when reached.
+	order []string
+	log   *os.File
+	// logged counts the entries in the log.
+	logged int
+}
+
+// logEntry is a line of the index log: a session added or updated, or the
+// id of a session removed.
+type logEntry struct {
+	Session *Session `json:"session,omitempty"`
+	Delete  string   `json:"delete,omitempty"`
+}
+
+// minCompact is the fewest log entries worth compacting.
+const minCompact = 1000
+
+// NewSessionIndex loads the index at path. An empty path keeps the index
+// in memory only. An index saved as a JSON array by older versions is
+// converted to a log.
+func NewSessionIndex(path string, max int) (*SessionIndex, error) {
+	idx := &SessionIndex{path: path, max: max, sessions: make(map[string]Session)}
+	if path == "" {
+		return idx, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var sessions []Session
+		if err := json.Unmarshal(data, &sessions); err != nil {
+			return nil, err
+		}
+		for _, s := range sessions {
+			idx.sessions[s.ID] = s
+		}
+	} else if err := idx.replay(data); err != nil {
+		return nil, err
+	}
+	for _, s := range idx.sorted() {
+		if !s.Minted {
+			idx.order = append(idx.order, s.ID)
+		}
+	}
+	if err := idx.compact(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// replay applies the entries of a log. A torn last line, left by a crash
+// mid write, is ignored.
+func (idx *SessionIndex) replay(data []byte) error {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e logEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			if i == len(lines)-1 {
+				break
+			}
+			return fmt.Errorf("session index line %d: %w", i+1, err)
+		}
+		idx.apply(e)
+	}
+	return nil
+}
+
+func (idx *SessionIndex) apply(e logEntry) {
+	if e.Session != nil {
+		idx.sessions[e.Session.ID] = *e.Session
+	}
+	if e.Delete != "" {
+		delete(idx.sessions, e.Delete)
+	}
+}
+
+// Get returns session id, which must belong to fid.
+func (idx *SessionIndex) Get(fid uint64, id string) (Session, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	s, ok := idx.sessions[id]
+	if !ok || s.Key.FID != fid {
+		return Session{}, ErrNotFound
+	}
+	return s, nil
+}
+
+// Children returns the sessions made from session id, oldest first.
+func (idx *SessionIndex) Children(fid uint64, id string) []Session {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	children := []Session{}
+	for _, s := range idx.sorted() {
+		if s.Parent == id && s.Key.FID == fid {
+			children = append(children, s)
+		}
+	}
+	return children
+}
+
+// Add records session s, which must have an ID and Key.
+func (idx *SessionIndex) Add(s Session) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if s.Created.IsZero() {
+		s.Created = time.Now()
+	}
+	if _, ok := idx.sessions[s.ID]; !ok && !s.Minted {
+		idx.order = append(idx.order, s.ID)
+	}
+	idx.sessions[s.ID] = s
+	entries := []logEntry{{Session: &s}}
+	for idx.max > 0 && len(idx.sessions) > idx.max && len(idx.order) > 0 {
+		id := idx.order[0]
+		idx.order = idx.order[1:]
+		// minted sessions stay, and never become unminted
+		if old, ok := idx.sessions[id]; ok && !old.Minted {
+			delete(idx.sessions, id)
+			entries = append(entries, logEntry{Delete: id})
+		}
+	}
+	return idx.save(entries...)
+}
+
+// SetMinted marks session id of fid as minted as the token in m.
+func (idx *SessionIndex) SetMinted(fid uint64, id string, m *MintRecord) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	s, ok := idx.sessions[id]
+	if !ok || s.Key.FID != fid {
+		return ErrNotFound
+	}
+	s.Minted = true
+	s.Mint = m
+	idx.sessions[id] = s
+	return idx.save(logEntry{Session: &s})
+}
+
+// Ancestry returns session id of fid and every indexed session it was
+// made from, root first.
+func (idx *SessionIndex) Ancestry(fid uint64, id string) []Session {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	var chain []Session
+	for id != "" && len(chain) <= len(idx.sessions) {
+		s, ok := idx.sessions[id]
+		if !ok || s.Key.FID != fid {
+			break
+		}
+		chain = append([]Session{s}, chain...)
+		id = s.Parent
+	}
+	return chain
+}
+
+// usage is how a key is referenced by the index.
+type usage struct {
+	lastUsed time.Time
+	minted   bool
+}
+
+// usages returns the usage of every key referenced by a session.
+func (idx *SessionIndex) usages() map[Key]usage {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	usages := make(map[Key]usage)
+	for _, s := range idx.sessions {
+		u := usages[s.Key]
+		if s.Created.After(u.lastUsed) {
+			u.lastUsed = s.Created
+		}
+		u.minted = u.minted || s.Minted
+		usages[s.Key] = u
+	}
+	return usages
+}
+
+// usedSince reports whether a session created after t references key.
+func (idx *SessionIndex) usedSince(key Key, t time.Time) bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, s := range idx.sessions {
+		if s.Key == key && s.Created.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeKey drops the sessions created before t that reference key.
+func (idx *SessionIndex) removeKey(key Key, t time.Time) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	var entries []logEntry
+	for id, s := range idx.sessions {
+		if s.Key == key && s.Created.Before(t) {
+			delete(idx.sessions, id)
+			entries = append(entries, logEntry{Delete: id})
+		}
+	}
+	return idx.save(entries...)
+}
+
+// sorted returns the sessions oldest first.
+func (idx *SessionIndex) sorted() []Session {
+	sessions := make([]Session, 0, len(idx.sessions))
+	for _, s := range idx.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Created.Before(sessions[j].Created)
+	})
+	return sessions
+}
+
+// save appends entries to the log, compacting it when most of it is
+// stale.
+func (idx *SessionIndex) save(entries ...logEntry) error {
+	if idx.path == "" || len(entries) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if _, err := idx.log.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	idx.logged += len(entries)
+	if idx.logged > minCompact && idx.logged > 2*len(idx.sessions) {
+		return idx.compact()
+	}
+	return nil
+}
+
+// compact rewrites the log with one entry per session and reopens it for
+// appending.
+func (idx *SessionIndex) compact() error {
+	sessions := idx.sorted()
+	err := util.WriteFileAtomic(idx.path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for i := range sessions {
+			if err := enc.Encode(logEntry{Session: &sessions[i]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if idx.log != nil {
+		idx.log.Close()
+	}
+	idx.log, err = os.OpenFile(idx.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	idx.logged = len(sessions)
+	return nil
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSession(i int) Session {
+	return Session{
+		ID:      fmt.Sprintf("s%d", i),
+		Key:     Key{FID: 3, ID: fmt.Sprintf("k%d", i), Format: "png"},
+		Created: time.Unix(int64(i), 0),
+	}
+}
+
+func TestSessionIndexPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	idx, err := NewSessionIndex(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := idx.Add(testSession(i)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			if err := idx.SetMinted(3, "s0", &MintRecord{TxHash: "0x1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	reloaded, err := NewSessionIndex(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, idx := range []*SessionIndex{idx, reloaded} {
+		// the minted session is kept past the limit, along with the
+		// newest unminted ones
+		for _, id := range []string{"s0", "s3", "s4"} {
+			if _, err := idx.Get(3, id); err != nil {
+				t.Errorf("Get(%s): %v", id, err)
+			}
+		}
+		for _, id := range []string{"s1", "s2"} {
+			if _, err := idx.Get(3, id); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(%s) = %v, want evicted", id, err)
+			}
+		}
+	}
+	if s, _ := reloaded.Get(3, "s0"); !s.Minted || s.Mint.TxHash != "0x1" {
+		t.Errorf("reloaded s0 = %+v, want minted", s)
+	}
+}
+
+func TestSessionIndexCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	idx, err := NewSessionIndex(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3*minCompact; i++ {
+		if err := idx.Add(testSession(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if idx.logged > 2*minCompact {
+		t.Errorf("log has %d entries, want it compacted", idx.logged)
+	}
+	reloaded, err := NewSessionIndex(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.sessions) != 10 {
+		t.Errorf("reloaded %d sessions, want 10", len(reloaded.sessions))
+	}
+}
+
+func TestSessionIndexLoadsArrayAndTornLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	data, err := json.Marshal([]Session{testSession(1), testSession(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := NewSessionIndex(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Add(testSession(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"session":{"id":"s4"`)
+	f.Close()
+
+	reloaded, err := NewSessionIndex(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"s1", "s2", "s3"} {
+		if _, err := reloaded.Get(3, id); err != nil {
+			t.Errorf("Get(%s): %v", id, err)
+		}
+	}
+	if len(reloaded.sessions) != 3 {
+		t.Errorf("reloaded %d sessions, want 3", len(reloaded.sessions))
+	}
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/treethought/impression-frame/util"
+)
+
+// LocalStore keeps images in a directory on the local filesystem.
+type LocalStore struct {
+	Dir string
+	// BaseURL is the URL that Dir is served under.
+	BaseURL string
+}
+
+func NewLocalStore(dir, baseURL string) *LocalStore {
+	return &LocalStore{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (s *LocalStore) path(key Key) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(key.Path()))
+}
+
+func (s *LocalStore) Put(ctx context.Context, key Key, data []byte) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (s *LocalStore) Get(ctx context.Context, key Key) ([]byte, error) {
+	if err := key.validate(); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s *LocalStore) Has(ctx context.Context, key Key) (bool, error) {
+	if err := key.validate(); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalStore) URL(key Key) string {
+	return s.BaseURL + "/" + key.Path()
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key Key) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *LocalStore) List(ctx context.Context, fid uint64) ([]Object, error) {
+	dir := filepath.Dir(s.path(Key{FID: fid, ID: "x", Format: "png"}))
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	objs := []Object{}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		key, err := ParseKey(fid, f.Name())
+		if err != nil {
+			// temporary files and anything else not written by Put
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		objs = append(objs, Object{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return objs, nil
+}
+
+func (s *LocalStore) FIDs(ctx context.Context) ([]uint64, error) {
+	dirs, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fids := []uint64{}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		if fid, err := strconv.ParseUint(d.Name(), 10, 64); err == nil {
+			fids = append(fids, fid)
+		}
+	}
+	return fids, nil
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps images in memory. It is meant for tests and local
+// development; nothing survives a restart.
+type MemoryStore struct {
+	BaseURL string
+
+	mu      sync.Mutex
+	objects map[Key]memoryObject
+}
+
+type memoryObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemoryStore(baseURL string) *MemoryStore {
+	return &MemoryStore{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		objects: make(map[Key]memoryObject),
+	}
+}
+
+func (s *MemoryStore) Put(ctx context.Context, key Key, data []byte) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{data: append([]byte(nil), data...), modTime: time.Now()}
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key Key) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return obj.data, nil
+}
+
+func (s *MemoryStore) Has(ctx context.Context, key Key) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[key]
+	return ok, nil
+}
+
+func (s *MemoryStore) URL(key Key) string {
+	return s.BaseURL + "/" + key.Path()
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, fid uint64) ([]Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objs := []Object{}
+	for key, obj := range s.objects {
+		if key.FID == fid {
+			objs = append(objs, Object{Key: key, Size: int64(len(obj.data)), ModTime: obj.modTime})
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].ModTime.Before(objs[j].ModTime)
+	})
+	return objs, nil
+}
+
+func (s *MemoryStore) FIDs(ctx context.Context) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := map[uint64]bool{}
+	fids := []uint64{}
+	for key := range s.objects {
+		if !seen[key.FID] {
+			seen[key.FID] = true
+			fids = append(fids, key.FID)
+		}
+	}
+	return fids, nil
+}
@@ -0,0 +1,335 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/treethought/impression-frame/util"
+)
+
+// MintStatus is how far a mint job has got.
+type MintStatus string
+
+const (
+	MintPending   MintStatus = "pending"
+	MintPinned    MintStatus = "pinned"
+	MintSubmitted MintStatus = "submitted"
+	MintConfirmed MintStatus = "confirmed"
+	MintFailed    MintStatus = "failed"
+)
+
+// Done reports whether the job has finished, successfully or not.
+func (s MintStatus) Done() bool {
+	return s == MintConfirmed || s == MintFailed
+}
+
+// mintJobRetention is how long finished jobs are kept for status polls.
+// Confirmed jobs are kept for good so images are only minted once.
+const mintJobRetention = 7 * 24 * time.Hour
+
+var (
+	// ErrAlreadyMinted is returned when another user minted, or is
+	// minting, the same image.
+	ErrAlreadyMinted = errors.New("image already minted")
+	// ErrReplayed is returned when a signed message that queued one mint
+	// is used to queue another.
+	ErrReplayed = errors.New("message already used")
+	// ErrNoMessage is returned for jobs without the hash of a validated
+	// message, which replays couldn't be checked for.
+	ErrNoMessage = errors.New("no message hash")
+)
+
+// QuotaError is returned when a mint would exceed a MintQuota limit.
+type QuotaError struct {
+	// Global is set when the limit for everyone was hit rather than the
+	// user's own.
+	Global bool
+	Limit  int
+	Window time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	scope := "per user"
+	if e.Global {
+		scope = "overall"
+	}
+	return fmt.Sprintf("mint limit of %d per %s %s reached", e.Limit, e.Window, scope)
+}
+
+// MintQuota limits how many mints are queued in a sliding window. Zero
+// limits are disabled.
+type MintQuota struct {
+	PerFID int
+	Global int
+	Window time.Duration
+}
+
+// MintJob mints session of fid. Each stage records what the next one
+// needs, so a job resumes where it stopped after a restart.
+type MintJob struct {
+	ID      string `json:"id"`
+	FID     uint64 `json:"fid"`
+	Session string `json:"session"`
+	// Chain names the chain to mint on, or is empty for the default.
+	Chain string `json:"chain,omitempty"`
+	// CopyOf is the confirmed job whose token this job mints a copy of,
+	// for editions. Session, Image, MetadataURI and TokenID are the
+	// original's, and Session belongs to its user.
+	CopyOf  string `json:"copy_of,omitempty"`
+	TokenID string `json:"token_id,omitempty"`
+	// Image is the content hash of the session's image, which is only
+	// minted once.
+	Image string `json:"image,omitempty"`
+	// MessageHash is the signed frame message that queued the job.
+	MessageHash string     `json:"message_hash,omitempty"`
+	Status      MintStatus `json:"status"`
+	// To is the address chosen to mint to, or else set once pinned along
+	// with MetadataURI. TxHash is set once submitted and Mint once
+	// confirmed.
+	To          string `json:"to,omitempty"`
+	MetadataURI string `json:"metadata_uri,omitempty"`
+	// Metadata is the JSON pinned at MetadataURI, for chains that pin it
+	// again under the token id once it is known. MetadataURI becomes the
+	// token's URI once confirmed.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// TxHash is the newest version of the mint transaction, or the one
+	// mined once confirmed. Replaced lists the versions it replaced with
+	// more gas, Cancels the transfers sent to use up its nonce once it
+	// timed out, SentAt is when the first was sent and BumpedAt the newest.
+	TxHash   string      `json:"tx_hash,omitempty"`
+	Replaced []string    `json:"replaced,omitempty"`
+	Cancels  []string    `json:"cancels,omitempty"`
+	SentAt   time.Time   `json:"sent_at,omitempty"`
+	BumpedAt time.Time   `json:"bumped_at,omitempty"`
+	Mint     *MintRecord `json:"mint,omitempty"`
+	// Error is the last error, which failed the job if its status is
+	// MintFailed.
+	Error       string    `json:"error,omitempty"`
+	Attempts    int       `json:"attempts,omitempty"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+// MintQueue holds mint jobs for workers to claim and, when path is set,
+// persists them to a JSON file.
+type MintQueue struct {
+	path  string
+	ready chan struct{}
+
+	mu      sync.Mutex
+	jobs    map[string]MintJob
+	claimed map[string]bool
+}
+
+// NewMintQueue loads the queue at path. An empty path keeps the queue in
+// memory only.
+func NewMintQueue(path string) (*MintQueue, error) {
+	q := &MintQueue{
+		path:    path,
+		ready:   make(chan struct{}, 1),
+		jobs:    make(map[string]MintJob),
+		claimed: make(map[string]bool),
+	}
+	if path == "" {
+		return q, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []MintJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		q.jobs[j.ID] = j
+	}
+	return q, nil
+}
+
+// Enqueue adds job, which must have an ID, FID, Session and MessageHash,
+// as pending.
+// Its To may be left empty to mint to the user's first address.
+//
+// A job already queued for the same image, or the same session, is
+// returned instead if it is the user's and hasn't failed; if it is
+// another user's, ErrAlreadyMinted is returned. Copies are only checked
+// against the user's own copies and original. Reusing a message hash for
+// a different image returns ErrReplayed, and new jobs over quota a
+// *QuotaError.
+func (q *MintQueue) Enqueue(job MintJob, quota MintQuota) (MintJob, error) {
+	if job.MessageHash == "" {
+		return MintJob{}, ErrNoMessage
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, j := range q.jobs {
+		same := j.CopyOf == "" && (j.Session == job.Session || (job.Image != "" && j.Image == job.Image))
+		if job.CopyOf != "" {
+			same = (j.ID == job.CopyOf || j.CopyOf == job.CopyOf) && j.FID == job.FID
+		}
+		if j.MessageHash == job.MessageHash {
+			if same && j.FID == job.FID {
+				return j, nil
+			}
+			return MintJob{}, ErrReplayed
+		}
+		if !same || j.Status == MintFailed {
+			continue
+		}
+		if j.FID != job.FID {
+			return MintJob{}, ErrAlreadyMinted
+		}
+		return j, nil
+	}
+
+	now := time.Now()
+	if err := q.checkQuota(job.FID, quota, now); err != nil {
+		return MintJob{}, err
+	}
+	for id, j := range q.jobs {
+		if j.Status == MintFailed && now.Sub(j.Updated) > mintJobRetention {
+			delete(q.jobs, id)
+		}
+	}
+	job.Status = MintPending
+	job.Created = now
+	job.Updated = now
+	q.jobs[job.ID] = job
+	if err := q.save(); err != nil {
+		return MintJob{}, err
+	}
+	q.signal()
+	return job, nil
+}
+
+// checkQuota counts the jobs queued in the quota's window, other than
+// those that failed before sending a transaction.
+func (q *MintQueue) checkQuota(fid uint64, quota MintQuota, now time.Time) error {
+	if quota.Window <= 0 || (quota.PerFID <= 0 && quota.Global <= 0) {
+		return nil
+	}
+	var mine, all int
+	for _, j := range q.jobs {
+		if now.Sub(j.Created) > quota.Window || (j.Status == MintFailed && j.TxHash == "") {
+			continue
+		}
+		all++
+		if j.FID == fid {
+			mine++
+		}
+	}
+	if quota.PerFID > 0 && mine >= quota.PerFID {
+		return &QuotaError{Limit: quota.PerFID, Window: quota.Window}
+	}
+	if quota.Global > 0 && all >= quota.Global {
+		return &QuotaError{Global: true, Limit: quota.Global, Window: quota.Window}
+	}
+	return nil
+}
+
+// Get returns job id, which must belong to fid.
+func (q *MintQueue) Get(fid uint64, id string) (MintJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok || j.FID != fid {
+		return MintJob{}, ErrNotFound
+	}
+	return j, nil
+}
+
+// Edition returns confirmed job id, of any user, for minting copies of its
+// token.
+func (q *MintQueue) Edition(id string) (MintJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok || j.Status != MintConfirmed || j.CopyOf != "" || j.Mint == nil || j.Mint.TokenID == "" {
+		return MintJob{}, ErrNotFound
+	}
+	return j, nil
+}
+
+// Claim returns the oldest unfinished job that is due and not claimed by
+// another worker. The worker must Release it when done.
+func (q *MintQueue) Claim() (MintJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for _, j := range q.sorted() {
+		if j.Status.Done() || q.claimed[j.ID] || j.NextAttempt.After(now) {
+			continue
+		}
+		q.claimed[j.ID] = true
+		return j, true
+	}
+	return MintJob{}, false
+}
+
+// Update saves a claimed job.
+func (q *MintQueue) Update(job MintJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.Updated = time.Now()
+	q.jobs[job.ID] = job
+	return q.save()
+}
+
+// Release returns a claimed job to the queue.
+func (q *MintQueue) Release(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.claimed, id)
+	q.signal()
+}
+
+// Ready receives when a job may have become claimable.
+func (q *MintQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+func (q *MintQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// sorted returns the jobs oldest first.
+func (q *MintQueue) sorted() []MintJob {
+	jobs := make([]MintJob, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+	return jobs
+}
+
+func (q *MintQueue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	jobs := q.sorted()
+	return util.WriteFileAtomic(q.path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(jobs)
+	})
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEnqueueChecksMessageHash(t *testing.T) {
+	q := newQueue(t)
+	job := MintJob{ID: "a", FID: 3, Session: "s1", Image: "i1"}
+	if _, err := q.Enqueue(job, MintQuota{}); !errors.Is(err, ErrNoMessage) {
+		t.Fatalf("Enqueue without a hash = %v, want ErrNoMessage", err)
+	}
+
+	job.MessageHash = "0x01"
+	if _, err := q.Enqueue(job, MintQuota{}); err != nil {
+		t.Fatal(err)
+	}
+	// the same message can't queue a mint of another image
+	other := MintJob{ID: "b", FID: 3, Session: "s2", Image: "i2", MessageHash: "0x01"}
+	if _, err := q.Enqueue(other, MintQuota{}); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("Enqueue with a used hash = %v, want ErrReplayed", err)
+	}
+	other.MessageHash = "0x02"
+	if _, err := q.Enqueue(other, MintQuota{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newQueue(t *testing.T) *MintQueue {
+	t.Helper()
+	q, err := NewMintQueue("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestEnqueueQuota(t *testing.T) {
+	quota := MintQuota{PerFID: 2, Global: 3, Window: time.Hour}
+	q := newQueue(t)
+	n := 0
+	enqueue := func(fid uint64) error {
+		n++
+		id := fmt.Sprint(n)
+		_, err := q.Enqueue(MintJob{ID: id, FID: fid, Session: "s" + id, Image: "i" + id, MessageHash: "0x" + id}, quota)
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := enqueue(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var qe *QuotaError
+	if err := enqueue(1); !errors.As(err, &qe) || qe.Global || qe.Limit != 2 {
+		t.Fatalf("third mint by fid 1 = %v, want the per fid limit", err)
+	}
+	if err := enqueue(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := enqueue(3); !errors.As(err, &qe) || !qe.Global || qe.Limit != 3 {
+		t.Fatalf("fourth mint = %v, want the global limit", err)
+	}
+
+	// mints that failed before sending a transaction don't count
+	q.mu.Lock()
+	j := q.jobs["1"]
+	j.Status = MintFailed
+	q.jobs["1"] = j
+	q.mu.Unlock()
+	if err := enqueue(1); err != nil {
+		t.Fatalf("mint after a failure = %v", err)
+	}
+
+	// nor do mints from before the window
+	q.mu.Lock()
+	for id, j := range q.jobs {
+		j.Created = j.Created.Add(-2 * quota.Window)
+		q.jobs[id] = j
+	}
+	q.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		if err := enqueue(1); err != nil {
+			t.Fatalf("mint in a new window = %v", err)
+		}
+	}
+}
+
+func TestEnqueueMintsImagesOnce(t *testing.T) {
+	q := newQueue(t)
+	job := MintJob{ID: "a", FID: 3, Session: "s1", Image: "i1", MessageHash: "0x01"}
+	if _, err := q.Enqueue(job, MintQuota{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// another session of the same image, by someone else
+	other := MintJob{ID: "b", FID: 4, Session: "s2", Image: "i1", MessageHash: "0x02"}
+	if _, err := q.Enqueue(other, MintQuota{}); !errors.Is(err, ErrAlreadyMinted) {
+		t.Fatalf("Enqueue of a minted image = %v, want ErrAlreadyMinted", err)
+	}
+	// the same user pressing mint again gets their job back
+	again := MintJob{ID: "c", FID: 3, Session: "s1", Image: "i1", MessageHash: "0x03"}
+	got, err := q.Enqueue(again, MintQuota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "a" {
+		t.Errorf("Enqueue again = job %s, want a", got.ID)
+	}
+	// as does a retried request with the same message
+	if got, err := q.Enqueue(job, MintQuota{}); err != nil || got.ID != "a" {
+		t.Errorf("Enqueue retried = %s, %v", got.ID, err)
+	}
+
+	// once failed, anyone may mint it
+	q.mu.Lock()
+	j := q.jobs["a"]
+	j.Status = MintFailed
+	q.jobs["a"] = j
+	q.mu.Unlock()
+	if got, err := q.Enqueue(other, MintQuota{}); err != nil || got.ID != "b" {
+		t.Errorf("Enqueue after a failure = %s, %v", got.ID, err)
+	}
+	// but not with a message used by the failed job
+	replay := MintJob{ID: "d", FID: 3, Session: "s3", Image: "i3", MessageHash: "0x01"}
+	if _, err := q.Enqueue(replay, MintQuota{}); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Enqueue with the failed job's message = %v, want ErrReplayed", err)
+	}
+}
@@ -0,0 +1,314 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Store keeps images in a bucket of an S3 compatible service such as
+// AWS S3, MinIO or R2. Requests use path style addressing and are signed
+// with AWS Signature Version 4.
+type S3Store struct {
+	// Endpoint is the service URL, e.g. https://s3.us-east-1.amazonaws.com
+	// or http://localhost:9000.
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// PublicURL is the URL objects are served under, either the bucket
+	// itself or this server's results handler.
+	PublicURL string
+	// HTTPClient defaults to a client with a 30s timeout.
+	HTTPClient *http.Client
+}
+
+func NewS3Store(endpoint, bucket, region, accessKey, secretKey, publicURL string) *S3Store {
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Store{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Bucket:    bucket,
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		PublicURL: strings.TrimSuffix(publicURL, "/"),
+	}
+}
+
+func (s *S3Store) client() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+func (s *S3Store) Put(ctx context.Context, key Key, data []byte) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, key.Path(), nil, data, key.ContentType())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key Key) ([]byte, error) {
+	if err := key.validate(); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, key.Path(), nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *S3Store) Has(ctx context.Context, key Key) (bool, error) {
+	if err := key.validate(); err != nil {
+		return false, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, key.Path(), nil, nil, "")
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+func (s *S3Store) URL(key Key) string {
+	return s.PublicURL + "/" + key.Path()
+}
+
+func (s *S3Store) Delete(ctx context.Context, key Key) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key.Path(), nil, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type listBucketResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+// list pages through a bucket listing, calling fn with each page.
+func (s *S3Store) list(ctx context.Context, query url.Values, fn func(*listBucketResult)) error {
+	query.Set("list-type", "2")
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, "")
+		if err != nil {
+			return err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decode bucket listing: %w", err)
+		}
+		fn(&result)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *S3Store) List(ctx context.Context, fid uint64) ([]Object, error) {
+	objs := []Object{}
+	query := url.Values{}
+	query.Set("prefix", fmt.Sprintf("%d/", fid))
+	err := s.list(ctx, query, func(result *listBucketResult) {
+		for _, c := range result.Contents {
+			key, err := ParsePath(c.Key)
+			if err != nil {
+				continue
+			}
+			objs = append(objs, Object{Key: key, Size: c.Size, ModTime: c.LastModified})
+		}
+	})
+	return objs, err
+}
+
+func (s *S3Store) FIDs(ctx context.Context) ([]uint64, error) {
+	fids := []uint64{}
+	query := url.Values{}
+	query.Set("delimiter", "/")
+	err := s.list(ctx, query, func(result *listBucketResult) {
+		for _, p := range result.CommonPrefixes {
+			if fid, err := strconv.ParseUint(strings.TrimSuffix(p.Prefix, "/"), 10, 64); err == nil {
+				fids = append(fids, fid)
+			}
+		}
+	})
+	return fids, err
+}
+
+// do sends a signed request for path within the bucket. Responses other
+// than 2xx are returned as errors, with 404 mapped to ErrNotFound.
+func (s *S3Store) do(ctx context.Context, method, path string, query url.Values, body []byte, contentType string) (*http.Response, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + s.Bucket
+	if path != "" {
+		u.Path += "/" + path
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if method == http.MethodPut {
+		req.Header.Set("Cache-Control", CacheControl)
+	}
+	s.sign(req, body, time.Now())
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method != http.MethodPut {
+		return nil, ErrNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("s3 %s %s: %s: %s", method, u.Path, resp.Status, bytes.TrimSpace(msg))
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set, as SigV4 requires.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
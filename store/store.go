@@ -0,0 +1,151 @@
+// Package store persists generated images.
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treethought/impression-frame/util"
+)
+
+// ErrNotFound is returned when a key has no stored image.
+var ErrNotFound = errors.New("image not found")
+
+// ImageStore stores encoded images under a Key.
+type ImageStore interface {
+	Put(ctx context.Context, key Key, data []byte) error
+	Get(ctx context.Context, key Key) ([]byte, error)
+	// Has reports whether an image is stored for key.
+	Has(ctx context.Context, key Key) (bool, error)
+	// URL is where the image for key is publicly served from.
+	URL(key Key) string
+	Delete(ctx context.Context, key Key) error
+	// List returns every object stored for fid.
+	List(ctx context.Context, fid uint64) ([]Object, error)
+	// FIDs returns every fid with stored objects.
+	FIDs(ctx context.Context) ([]uint64, error)
+}
+
+// CacheControl is sent with stored images. Keys are never overwritten
+// with different content, so they can be cached forever.
+const CacheControl = "public, max-age=31536000, immutable"
+
+// Key identifies a stored image. It is stored at <fid>/<id>.<format>.
+type Key struct {
+	FID    uint64 `json:"fid"`
+	ID     string `json:"id"`
+	Format string `json:"format"`
+}
+
+// Object is a stored image as returned by List.
+type Object struct {
+	Key
+	Size    int64
+	ModTime time.Time
+}
+
+var (
+	validID     = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	validFormat = map[string]string{
+		"png":  "image/png",
+		"gif":  "image/gif",
+		"jpg":  "image/jpeg",
+		"jpeg": "image/jpeg",
+	}
+)
+
+// Name is the id and extension of the key, as used in session ids.
+func (k Key) Name() string {
+	return k.ID + "." + k.Format
+}
+
+// Path is the slash separated path of the key within a store.
+func (k Key) Path() string {
+	return fmt.Sprintf("%d/%s", k.FID, k.Name())
+}
+
+// ContentType is the MIME type of the key's format.
+func (k Key) ContentType() string {
+	return validFormat[k.Format]
+}
+
+func (k Key) validate() error {
+	if !validID.MatchString(k.ID) {
+		return fmt.Errorf("invalid image id %q", k.ID)
+	}
+	if _, ok := validFormat[k.Format]; !ok {
+		return fmt.Errorf("invalid image format %q", k.Format)
+	}
+	return nil
+}
+
+// ParseKey parses a name as returned by Key.Name. Names without an
+// extension are PNGs.
+func ParseKey(fid uint64, name string) (Key, error) {
+	id, format, ok := strings.Cut(name, ".")
+	if !ok {
+		format = "png"
+	}
+	k := Key{FID: fid, ID: id, Format: strings.ToLower(format)}
+	return k, k.validate()
+}
+
+// ParsePath parses a path as returned by Key.Path.
+func ParsePath(path string) (Key, error) {
+	dir, name, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok {
+		return Key{}, fmt.Errorf("invalid image path %q", path)
+	}
+	fid, err := strconv.ParseUint(dir, 10, 64)
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid image path %q", path)
+	}
+	return ParseKey(fid, name)
+}
+
+// ETag is a strong entity tag for the image stored under the key.
+func (k Key) ETag() string {
+	return `"` + k.ID + `"`
+}
+
+// ContentKey is the content addressed key of img for fid: its id is the
+// hash of the image, and its format is GIF when animated and PNG otherwise.
+func ContentKey(fid uint64, img image.Image) Key {
+	format := "png"
+	if _, ok := img.(*util.Animated); ok {
+		format = "gif"
+	}
+	return Key{FID: fid, ID: util.HashImage(img), Format: format}
+}
+
+// PutImage encodes img in the key's format and stores it.
+func PutImage(ctx context.Context, s ImageStore, key Key, img image.Image) error {
+	if err := key.validate(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := util.EncodeImage(&buf, img, key.Format); err != nil {
+		return err
+	}
+	return s.Put(ctx, key, buf.Bytes())
+}
+
+// GetImage loads and decodes the image stored for key.
+func GetImage(ctx context.Context, s ImageStore, key Key) (image.Image, error) {
+	data, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := util.DecodeImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", key.Path(), err)
+	}
+	return img, nil
+}
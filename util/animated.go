@@ -0,0 +1,220 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"log"
+	"sync"
+
+	"github.com/andybons/gogif"
+)
+
+// MaxAnimationFrames caps how many frames of an animation are kept, since
+// every frame is transformed separately.
+const MaxAnimationFrames = 48
+
+// Animated is a multi-frame image such as an animated GIF. It behaves as
+// an image.Image of its first frame, so code unaware of animation still
+// works with it. Frames are fully composited canvases, with the source
+// disposal methods already applied.
+type Animated struct {
+	Frames []image.Image
+	// Delay is the per frame delay in 100ths of a second.
+	Delay     []int
+	LoopCount int
+}
+
+func (a *Animated) ColorModel() color.Model {
+	return a.Frames[0].ColorModel()
+}
+
+func (a *Animated) Bounds() image.Rectangle {
+	return a.Frames[0].Bounds()
+}
+
+func (a *Animated) At(x, y int) color.Color {
+	return a.Frames[0].At(x, y)
+}
+
+// MaxAnimationPixels caps the canvas pixels summed over the kept frames
+// of an animation, as each frame is composited onto a full canvas.
+const MaxAnimationPixels = 1024 * 1024 * MaxAnimationFrames
+
+// DecodeGIF decodes the frames of a GIF, up to MaxAnimationFrames and
+// MaxAnimationPixels. Frames are counted from the block structure first,
+// so frames past the limits are never decoded. Single frame GIFs are
+// returned as a plain image.
+func DecodeGIF(data []byte) (image.Image, error) {
+	width, height, ends, err := scanGIF(data)
+	if err != nil {
+		return nil, err
+	}
+	keep := framesToKeep(width, height, len(ends))
+	if keep < len(ends) {
+		log.Printf("keeping %d of %d animation frames", keep, len(ends))
+		// cut the stream after the last kept frame and close it
+		data = append(data[:ends[keep-1]:ends[keep-1]], gifTrailer)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) == 1 {
+		return g.Image[0], nil
+	}
+	return composite(g), nil
+}
+
+// framesToKeep returns how many of n frames of a width by height canvas
+// fit the animation limits.
+func framesToKeep(width, height, n int) int {
+	keep := min(n, MaxAnimationFrames)
+	if pixels := width * height; pixels > 0 && keep*pixels > MaxAnimationPixels {
+		keep = max(MaxAnimationPixels/pixels, 1)
+	}
+	return keep
+}
+
+const (
+	gifExtension       = 0x21
+	gifImageDescriptor = 0x2C
+	gifTrailer         = 0x3B
+)
+
+var errMalformedGIF = errors.New("malformed gif")
+
+// scanGIF walks the blocks of a GIF without decoding them, returning its
+// canvas size and the offset just past each frame.
+func scanGIF(data []byte) (width, height int, ends []int, err error) {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return 0, 0, nil, errMalformedGIF
+	}
+	width = int(data[6]) | int(data[7])<<8
+	height = int(data[8]) | int(data[9])<<8
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	for i < len(data) {
+		block := data[i]
+		switch block {
+		case gifExtension:
+			i += 2
+		case gifImageDescriptor:
+			if i+10 > len(data) {
+				return 0, 0, nil, errMalformedGIF
+			}
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << (packed&0x07 + 1)
+			}
+			// LZW minimum code size
+			i++
+		case gifTrailer:
+			return width, height, ends, nil
+		default:
+			return 0, 0, nil, errMalformedGIF
+		}
+		// skip the data sub-blocks up to the empty terminator
+		for {
+			if i >= len(data) {
+				return 0, 0, nil, errMalformedGIF
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+		if block == gifImageDescriptor {
+			ends = append(ends, i)
+		}
+	}
+	return width, height, ends, nil
+}
+
+// composite renders each GIF frame onto the full canvas, honouring the
+// disposal method of the previous frame.
+func composite(g *gif.GIF) *Animated {
+	n := len(g.Image)
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(bounds)
+
+	a := &Animated{LoopCount: g.LoopCount}
+	for i := 0; i < n; i++ {
+		frame := g.Image[i]
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		delay := 10
+		if i < len(g.Delay) {
+			delay = g.Delay[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		a.Frames = append(a.Frames, cloneRGBA(canvas))
+		a.Delay = append(a.Delay, delay)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return a
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	c := image.NewRGBA(img.Bounds())
+	copy(c.Pix, img.Pix)
+	return c
+}
+
+// GIF quantizes the frames into an animated GIF. Since every frame is a
+// full canvas, frames don't depend on the disposal of the one before.
+func (a *Animated) GIF() *gif.GIF {
+	g := &gif.GIF{
+		Image:     make([]*image.Paletted, len(a.Frames)),
+		Delay:     make([]int, len(a.Frames)),
+		Disposal:  make([]byte, len(a.Frames)),
+		LoopCount: a.LoopCount,
+	}
+
+	var wg sync.WaitGroup
+	for i, frame := range a.Frames {
+		wg.Add(1)
+		go func(i int, frame image.Image) {
+			defer wg.Done()
+			quantizer := gogif.MedianCutQuantizer{NumColor: 256}
+			bounds := frame.Bounds()
+			paletted := image.NewPaletted(bounds, nil)
+			quantizer.Quantize(paletted, bounds, frame, bounds.Min)
+			g.Image[i] = paletted
+		}(i, frame)
+
+		g.Delay[i] = 10
+		if i < len(a.Delay) {
+			g.Delay[i] = a.Delay[i]
+		}
+		g.Disposal[i] = gif.DisposalNone
+	}
+	wg.Wait()
+	return g
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func encodeGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+	g := &gif.GIF{}
+	palette := color.Palette{color.Black, color.White}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+		frame.SetColorIndex(i%4, 0, 1)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 5)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestScanGIFCountsFrames(t *testing.T) {
+	data := encodeGIF(t, 7)
+	width, height, ends, err := scanGIF(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 4 || height != 4 {
+		t.Errorf("canvas = %dx%d, want 4x4", width, height)
+	}
+	if len(ends) != 7 {
+		t.Errorf("got %d frames, want 7", len(ends))
+	}
+	if _, _, _, err := scanGIF(data[:len(data)-2]); err == nil {
+		t.Error("expected an error for a truncated gif")
+	}
+}
+
+func TestDecodeGIFCapsFrames(t *testing.T) {
+	img, err := DecodeGIF(encodeGIF(t, MaxAnimationFrames+12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, ok := img.(*Animated)
+	if !ok {
+		t.Fatalf("got %T, want *Animated", img)
+	}
+	if len(a.Frames) != MaxAnimationFrames {
+		t.Errorf("got %d frames, want %d", len(a.Frames), MaxAnimationFrames)
+	}
+}
+
+func TestFramesToKeep(t *testing.T) {
+	cases := []struct {
+		width, height, n, want int
+	}{
+		{100, 100, 10, 10},
+		{100, 100, 100, MaxAnimationFrames},
+		{2048, 2048, 40, MaxAnimationPixels / (2048 * 2048)},
+		{16384, 16384, 5, 1},
+	}
+	for _, c := range cases {
+		if got := framesToKeep(c.width, c.height, c.n); got != c.want {
+			t.Errorf("framesToKeep(%d, %d, %d) = %d, want %d", c.width, c.height, c.n, got, c.want)
+		}
+	}
+}
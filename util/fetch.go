@@ -0,0 +1,357 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	// ErrNotModified is returned by FetchImageIfChanged when the server
+	// reports the image is unchanged.
+	ErrNotModified = errors.New("not modified")
+
+	// ErrBlockedAddress is returned when a fetch would connect to a
+	// loopback, private, link-local or otherwise internal address.
+	ErrBlockedAddress = errors.New("blocked address")
+
+	// ErrTooLarge is returned for images over the byte or pixel limits.
+	ErrTooLarge = errors.New("image too large")
+
+	// DefaultFetcher is used by FetchImage and FetchImageIfChanged.
+	DefaultFetcher = NewFetcher()
+)
+
+// Validators identify a version of a remote resource for conditional
+// requests.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Fetcher downloads images from untrusted URLs, such as the PFP a user's
+// profile points at. It only connects to public addresses, checked at
+// dial time so redirects and DNS rebinding can't reach internal hosts,
+// and bounds the size of what it downloads and decodes.
+type Fetcher struct {
+	client *http.Client
+
+	// MaxBytes limits the size of the response body.
+	MaxBytes int64
+	// MaxPixels limits width*height, checked before the image is decoded.
+	MaxPixels int
+	// Timeout bounds each fetch unless HostTimeouts has an entry for
+	// the host.
+	Timeout      time.Duration
+	HostTimeouts map[string]time.Duration
+
+	// IPFSGateways and ArweaveGateways resolve ipfs:// and ar:// URIs.
+	// Each gateway is tried in order until one serves the image.
+	IPFSGateways    []string
+	ArweaveGateways []string
+}
+
+const (
+	fetchMaxBytes     = 10 << 20
+	fetchMaxPixels    = 4096 * 4096
+	fetchMaxRedirects = 5
+	fetchTimeout      = 15 * time.Second
+)
+
+func NewFetcher() *Fetcher {
+	return NewFetcherAllowing(IsPublicIP)
+}
+
+// NewFetcherAllowing returns a Fetcher only connecting to addresses
+// allowed by public, so tests can reach servers on loopback.
+func NewFetcherAllowing(public func(net.IP) bool) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: blockInternal(public),
+	}
+	transport := &http.Transport{
+		// a proxy would make the dial check meaningless
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &Fetcher{
+		client: &http.Client{
+			Transport:     transport,
+			CheckRedirect: checkRedirect,
+		},
+		MaxBytes:        fetchMaxBytes,
+		MaxPixels:       fetchMaxPixels,
+		Timeout:         fetchTimeout,
+		HostTimeouts:    map[string]time.Duration{},
+		IPFSGateways:    []string{"https://ipfs.io", "https://cloudflare-ipfs.com", "https://dweb.link"},
+		ArweaveGateways: []string{"https://arweave.net", "https://ar-io.net"},
+	}
+}
+
+// resolve returns the HTTP URLs to try for rawURL. ipfs:// and ar:// URIs
+// expand to one URL per gateway; anything else is returned as is.
+func (f *Fetcher) resolve(rawURL string) ([]string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var gateways []string
+	var path string
+	switch u.Scheme {
+	case "ipfs":
+		// ipfs://<cid>/<path>, or the legacy ipfs://ipfs/<cid>
+		path = strings.TrimPrefix(u.Host+u.Path, "ipfs/")
+		for _, gw := range f.IPFSGateways {
+			gateways = append(gateways, strings.TrimSuffix(gw, "/")+"/ipfs")
+		}
+	case "ar":
+		path = u.Host + u.Path
+		gateways = f.ArweaveGateways
+	default:
+		if err := checkScheme(u); err != nil {
+			return nil, err
+		}
+		return []string{rawURL}, nil
+	}
+	if len(gateways) == 0 {
+		return nil, fmt.Errorf("no gateways configured for %s", u.Scheme)
+	}
+
+	urls := make([]string, len(gateways))
+	for i, gw := range gateways {
+		urls[i] = fmt.Sprintf("%s/%s", strings.TrimSuffix(gw, "/"), path)
+		if u.RawQuery != "" {
+			urls[i] += "?" + u.RawQuery
+		}
+	}
+	return urls, nil
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= fetchMaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", len(via))
+	}
+	return checkScheme(req.URL)
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// blockInternal returns a dial control refusing addresses public
+// doesn't allow. It runs after DNS resolution, right before connecting,
+// so it sees the actual address being dialed.
+func blockInternal(public func(net.IP) bool) func(network, address string, _ syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !public(ip) {
+			return fmt.Errorf("%s: %w", address, ErrBlockedAddress)
+		}
+		return nil
+	}
+}
+
+var nonPublicNets = mustParseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"240.0.0.0/4",     // reserved
+	"64:ff9b::/96",    // NAT64, may map to internal IPv4
+	"2001:db8::/32",   // documentation
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// IsPublicIP reports whether ip is a globally routable unicast address.
+func IsPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Fetcher) timeout(host string) time.Duration {
+	if t, ok := f.HostTimeouts[host]; ok {
+		return t
+	}
+	return f.Timeout
+}
+
+// Fetch downloads and decodes the image at rawURL, sending If-None-Match
+// and If-Modified-Since from v. When the server answers 304 it returns
+// ErrNotModified; otherwise it returns the image and its new validators.
+// ipfs:// and ar:// URIs are fetched through the configured gateways,
+// falling back to the next gateway when one fails.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string, v Validators) (image.Image, string, Validators, error) {
+	urls, err := f.resolve(rawURL)
+	if err != nil {
+		return nil, "", v, err
+	}
+	for _, u := range urls {
+		var img image.Image
+		var format string
+		var nv Validators
+		img, format, nv, err = f.fetch(ctx, u, v)
+		if err == nil || errors.Is(err, ErrNotModified) || ctx.Err() != nil {
+			return img, format, nv, err
+		}
+		if len(urls) > 1 {
+			log.Printf("gateway failed for %s: %v", rawURL, err)
+		}
+	}
+	return nil, "", v, err
+}
+
+func (f *Fetcher) fetch(ctx context.Context, rawURL string, v Validators) (image.Image, string, Validators, error) {
+	log.Println("fetching image: ", rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", v, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout(u.Hostname()))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", v, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36")
+	req.Header.Set("Accept", "image/webp,image/apng,image/*,*/*;q=0.8")
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", v, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, "", v, ErrNotModified
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, "", v, fmt.Errorf("fetch %s: %s", rawURL, res.Status)
+	}
+	ct := mediaType(res.Header.Get("Content-Type"))
+	if !isImageContentType(ct) {
+		return nil, "", v, fmt.Errorf("fetch %s: unexpected content type %q", rawURL, ct)
+	}
+	if res.ContentLength > f.MaxBytes {
+		return nil, "", v, fmt.Errorf("fetch %s: %d bytes: %w", rawURL, res.ContentLength, ErrTooLarge)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, f.MaxBytes+1))
+	if err != nil {
+		return nil, "", v, err
+	}
+	if int64(len(data)) > f.MaxBytes {
+		return nil, "", v, fmt.Errorf("fetch %s: over %d bytes: %w", rawURL, f.MaxBytes, ErrTooLarge)
+	}
+
+	img, format, err := f.decode(ct, data)
+	if err != nil {
+		return nil, "", v, fmt.Errorf("decode %s: %w", rawURL, err)
+	}
+	return img, format, Validators{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// decode checks the image dimensions from its header before decoding, so
+// a small file can't claim a huge canvas. SVGs are rasterised at a fixed
+// size instead.
+func (f *Fetcher) decode(contentType string, data []byte) (image.Image, string, error) {
+	if isSVG(contentType, data) {
+		img, err := rasterizeSVG(data)
+		return img, "svg", err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > f.MaxPixels {
+		return nil, "", fmt.Errorf("%dx%d: %w", cfg.Width, cfg.Height, ErrTooLarge)
+	}
+	return DecodeImage(data)
+}
+
+func mediaType(ct string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+}
+
+// isImageContentType accepts image types, plus the generic binary and XML
+// types some IPFS gateways and object stores serve images with.
+func isImageContentType(ct string) bool {
+	switch {
+	case ct == "", strings.HasPrefix(ct, "image/"):
+		return true
+	case ct == "application/octet-stream", ct == "binary/octet-stream":
+		return true
+	case ct == "text/xml", ct == "application/xml", ct == "text/plain":
+		// only decodes if the body turns out to be an SVG
+		return true
+	}
+	return false
+}
+
+func FetchImage(url string) (image.Image, string, error) {
+	img, format, _, err := FetchImageIfChanged(context.Background(), url, Validators{})
+	return img, format, err
+}
+
+// FetchImageIfChanged fetches url with the DefaultFetcher. See Fetcher.Fetch.
+func FetchImageIfChanged(ctx context.Context, url string, v Validators) (image.Image, string, Validators, error) {
+	return DefaultFetcher.Fetch(ctx, url, v)
+}
@@ -0,0 +1,184 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	cases := []struct {
+		ip     string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"1.1.1.1", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"127.1.2.3", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"fc00::1", false},
+		{"fd12:3456:789a::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:8.8.8.8", true},
+		{"64:ff9b::a00:1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"192.0.2.1", false},
+	}
+	for _, c := range cases {
+		ip := net.ParseIP(c.ip)
+		if ip == nil {
+			t.Fatalf("invalid test ip %s", c.ip)
+		}
+		if got := IsPublicIP(ip); got != c.public {
+			t.Errorf("IsPublicIP(%s) = %v, want %v", c.ip, got, c.public)
+		}
+	}
+}
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// loopbackFetcher is a Fetcher that may also connect to loopback, where
+// httptest servers listen.
+func loopbackFetcher() *Fetcher {
+	return NewFetcherAllowing(func(ip net.IP) bool { return ip.IsLoopback() || IsPublicIP(ip) })
+}
+
+func TestFetchBlocksInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("connected to an internal address")
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	// localhost is only known to be internal once resolved
+	for _, u := range []string{
+		srv.URL,
+		fmt.Sprintf("http://localhost:%s/pfp.png", port),
+	} {
+		_, _, _, err := NewFetcher().Fetch(context.Background(), u, Validators{})
+		if !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("Fetch(%s) = %v, want ErrBlockedAddress", u, err)
+		}
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	img := encodePNG(t, 2, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/hops/"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hops/"))
+			if n == 0 {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write(img)
+				return
+			}
+			http.Redirect(w, r, fmt.Sprintf("/hops/%d", n-1), http.StatusFound)
+		case r.URL.Path == "/metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		case r.URL.Path == "/file":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	f := loopbackFetcher()
+	ctx := context.Background()
+
+	if _, _, _, err := f.Fetch(ctx, srv.URL+"/hops/4", Validators{}); err != nil {
+		t.Errorf("4 redirects: %v", err)
+	}
+	if _, _, _, err := f.Fetch(ctx, srv.URL+"/hops/10", Validators{}); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("10 redirects: %v, want the redirect limit", err)
+	}
+	if _, _, _, err := f.Fetch(ctx, srv.URL+"/metadata", Validators{}); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("redirect to the metadata service: %v, want ErrBlockedAddress", err)
+	}
+	if _, _, _, err := f.Fetch(ctx, srv.URL+"/file", Validators{}); err == nil {
+		t.Error("followed a redirect to a file url")
+	}
+}
+
+func TestFetchLimits(t *testing.T) {
+	small := encodePNG(t, 8, 8)
+	wide := encodePNG(t, 200, 200)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(small)
+		case "/wide.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(wide)
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(bytes.Repeat([]byte{0}, 4096))
+		case "/streamed.png":
+			// no Content-Length, so only the read limit applies
+			w.Header().Set("Content-Type", "image/png")
+			for i := 0; i < 4; i++ {
+				w.Write(bytes.Repeat([]byte{0}, 1024))
+				w.(http.Flusher).Flush()
+			}
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write(small)
+		case "/script.js":
+			w.Header().Set("Content-Type", "application/javascript")
+			w.Write(small)
+		}
+	}))
+	defer srv.Close()
+	f := loopbackFetcher()
+	f.MaxBytes = 1024
+	f.MaxPixels = 100 * 100
+	ctx := context.Background()
+
+	img, format, _, err := f.Fetch(ctx, srv.URL+"/small.png", Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || img.Bounds().Dx() != 8 {
+		t.Errorf("fetched a %s of %v", format, img.Bounds())
+	}
+	for _, path := range []string{"/wide.png", "/large.png", "/streamed.png"} {
+		if _, _, _, err := f.Fetch(ctx, srv.URL+path, Validators{}); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: %v, want ErrTooLarge", path, err)
+		}
+	}
+	for _, path := range []string{"/page.html", "/script.js"} {
+		_, _, _, err := f.Fetch(ctx, srv.URL+path, Validators{})
+		if err == nil || !strings.Contains(err.Error(), "content type") {
+			t.Errorf("%s: %v, want the content type rejected", path, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"math"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// svgSize is the length of the longer side SVGs are rasterised at.
+const svgSize = 1024
+
+// isSVG sniffs whether data is an SVG document, as SVGs have no magic
+// bytes for image.Decode to match.
+func isSVG(contentType string, data []byte) bool {
+	if contentType == "image/svg+xml" {
+		return true
+	}
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	head = bytes.TrimSpace(head)
+	return bytes.HasPrefix(head, []byte("<svg")) ||
+		(bytes.HasPrefix(head, []byte("<?xml")) && bytes.Contains(head, []byte("<svg")))
+}
+
+// errEmptySVG is returned for SVGs that draw nothing.
+var errEmptySVG = errors.New("svg draws nothing")
+
+// rasterizeSVG renders an SVG document to an RGBA image, keeping the
+// aspect ratio of its viewBox.
+func rasterizeSVG(data []byte) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.WarnErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	w, h := icon.ViewBox.W, icon.ViewBox.H
+	if !(w > 0 && h > 0) || math.IsInf(w, 0) || math.IsInf(h, 0) {
+		w, h = svgSize, svgSize
+	}
+	scale := svgSize / math.Max(w, h)
+	// a very thin viewBox would round to no pixels at all
+	width, height := max(int(w*scale), 1), max(int(h*scale), 1)
+
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, img, img.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	if !painted(img) {
+		return nil, errEmptySVG
+	}
+	return img, nil
+}
+
+// painted reports whether any pixel of img is not fully transparent.
+func painted(img *image.RGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
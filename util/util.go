@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/draw"
@@ -46,25 +47,18 @@ func getRegion(img image.Image, x, y float64) (x1, x2, y1, y2 int) {
 	return x1, x2, y1, y2
 }
 
-func FetchImage(url string) (image.Image, string, error) {
-	log.Println("fetching image: ", url)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// DecodeImage decodes data like image.Decode, except that animated GIFs
+// keep all of their frames as an *Animated.
+func DecodeImage(data []byte) (image.Image, string, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
 		return nil, "", err
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36")
-	req.Header.Set("Accept", "image/webp,image/apng,image/*,*/*;q=0.8")
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, "", err
-	}
-	defer res.Body.Close()
-
-	img, format, err := image.Decode(res.Body)
-	if err != nil {
-		return nil, "", err
+	if format == "gif" {
+		img, err := DecodeGIF(data)
+		return img, format, err
 	}
-	return img, format, nil
+	return image.Decode(bytes.NewReader(data))
 }
 
 func LoadImage(filepath string) (image.Image, string, error) {
@@ -73,9 +67,13 @@ func LoadImage(filepath string) (image.Image, string, error) {
 		return nil, "", err
 	}
 	defer imgFile.Close()
-	img, format, err := image.Decode(imgFile)
+	data, err := io.ReadAll(imgFile)
 	if err != nil {
-		panic(err)
+		return nil, "", err
+	}
+	img, format, err := DecodeImage(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("decode %s: %w", filepath, err)
 	}
 	return img, format, nil
 }
@@ -190,22 +188,55 @@ func LoadRandomUnsplashImage(width, height int) (image.Image, string, error) {
 	return img, imgUrl, err
 }
 
-func WriteImage(path string, img image.Image) {
-	out, err := os.Create(path)
-	if err != nil {
-		log.Fatal(err)
+// EncodeImage writes img in the given format ("png", "gif" or "jpeg").
+// An *Animated written as a GIF keeps all of its frames.
+func EncodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		if a, ok := img.(*Animated); ok {
+			return gif.EncodeAll(w, a.GIF())
+		}
+		return gif.Encode(w, img, nil)
+	default:
+		return jpeg.Encode(w, img, nil)
 	}
-	defer out.Close()
+}
 
-	if filepath.Ext(path) == ".png" {
-		err = png.Encode(out, img)
-	} else {
-		err = jpeg.Encode(out, img, nil)
-	}
+// WriteImage atomically writes img to path in the format of its extension.
+func WriteImage(path string, img image.Image) error {
+	return WriteFileAtomic(path, func(w io.Writer) error {
+		return EncodeImage(w, img, strings.TrimPrefix(filepath.Ext(path), "."))
+	})
+}
 
+// WriteFileAtomic writes a file by calling write on a temporary file in the
+// same directory and renaming it into place, so readers never observe a
+// partially written file.
+func WriteFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path)+"-*")
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
 	}
+	return os.Rename(tmp.Name(), path)
 }
 
 func fillBlend(imgs ...image.Image) []image.Image {
@@ -268,9 +299,9 @@ func createGif(out string, blend bool, imgs ...image.Image) {
 	gif.EncodeAll(f, outGif)
 }
 
-func GetPalette(img image.Image) *palettor.Palette {
+func GetPalette(img image.Image) (*palettor.Palette, error) {
 	if img == nil {
-		return nil
+		return nil, errors.New("no image")
 	}
 
 	// Reduce it to a manageable size
@@ -285,7 +316,7 @@ func GetPalette(img image.Image) *palettor.Palette {
 	// Err will only be non-nil if k is larger than the number of pixels in the
 	// input image.
 	if err != nil {
-		log.Fatalf("image too small")
+		return nil, fmt.Errorf("image too small for a palette: %w", err)
 	}
 
 	// Palette is a mapping from color to the weight of that color's cluster,
@@ -294,7 +325,7 @@ func GetPalette(img image.Image) *palettor.Palette {
 	for _, color := range palette.Colors() {
 		log.Printf("color: %v; weight: %v", color, palette.Weight(color))
 	}
-	return palette
+	return palette, nil
 	// drawPalette(os.Stdout, thumb, palette, "jpeg")
 
 }
@@ -342,15 +373,24 @@ func drawPalette(dst io.Writer, img image.Image, palette *palettor.Palette, form
 	}
 }
 
-func hashImage(img image.Image) string {
-	buf := new(bytes.Buffer)
-	err := png.Encode(buf, img)
-	if err != nil {
-		log.Fatal(err)
+// HashImage returns the hex SHA-256 of the pixels of img, and of every
+// frame and delay when it is animated. Identical outputs hash the same
+// regardless of how they are encoded.
+func HashImage(img image.Image) string {
+	h := sha256.New()
+	frames := []image.Image{img}
+	if a, ok := img.(*Animated); ok {
+		frames = a.Frames
+		fmt.Fprint(h, "animated", a.Delay, a.LoopCount)
+	}
+	for _, frame := range frames {
+		b := frame.Bounds()
+		fmt.Fprint(h, b)
+		px := image.NewNRGBA(b)
+		draw.Draw(px, b, frame, b.Min, draw.Src)
+		h.Write(px.Pix)
 	}
-	imgBytes := buf.Bytes()
-	h := sha256.Sum256(imgBytes)
-	return fmt.Sprintf("%x", h)
+	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
 func EscapeURL(urlString string) string {